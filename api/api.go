@@ -1,19 +1,47 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
+	"github.com/thrgamon/nous/links"
+	"github.com/thrgamon/nous/notes"
+	"github.com/thrgamon/nous/web"
 )
 
 type Note struct {
-	ID   string         `db:"id" json:"id"`
-	Body string         `db:"body" json:"body"`
-	Tags pq.StringArray `db:"tags" json:"tags"`
+	ID    string           `db:"id" json:"id"`
+	Body  string           `db:"body" json:"body"`
+	Tags  pq.StringArray   `db:"tags" json:"tags"`
+	Links []links.NoteLink `db:"-" json:"links"`
+}
+
+// withLinks fills in the links written in each note.
+func withLinks(ctx context.Context, notes []Note) error {
+	var ids []string
+	for _, note := range notes {
+		ids = append(ids, note.ID)
+	}
+
+	noteLinks, err := links.NewLinkRepo().GetNoteLinks(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range notes {
+		notes[i].Links = noteLinks[notes[i].ID]
+		if notes[i].Links == nil {
+			notes[i].Links = []links.NoteLink{}
+		}
+	}
+	return nil
 }
 
 func AllNotes(w http.ResponseWriter, r *http.Request) {
@@ -51,79 +79,182 @@ func AllNotes(w http.ResponseWriter, r *http.Request) {
 
 	db.Select(&notes, sqlStatement)
 
+	if err := withLinks(r.Context(), notes); err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(notes)
 }
 
 func CreateNote(w http.ResponseWriter, r *http.Request) {
-	db, err := sqlx.Connect("postgres", os.Getenv("DATABASE_URL"))
-	defer db.Close()
-	if err != nil {
-		panic(err)
-	}
-
 	dec := json.NewDecoder(r.Body)
 	var n Note
-	err = dec.Decode(&n)
+	err := dec.Decode(&n)
 
 	if err != nil {
-		panic(err)
+		http.Error(w, "Could not decode note", http.StatusBadRequest)
+		return
 	}
 
-	var noteId string
-	var tagId string
-	tx := db.MustBegin()
-	err = tx.QueryRow("INSERT INTO notes (body) VALUES ($1) RETURNING id", n.Body).Scan(&noteId)
+	noteId, err := notes.NewNoteRepo().Add(r.Context(), n.Body, strings.Join(n.Tags, ","))
 	if err != nil {
-		tx.Rollback()
-		panic(err)
+		web.HandleUnexpectedError(w, err)
+		return
 	}
 
-	for _, tag := range n.Tags {
-		err := tx.QueryRow("INSERT INTO tags (tag) VALUES ($1) ON CONFLICT (tag) DO UPDATE SET updated_at = NOW() RETURNING id", tag).Scan(&tagId)
-		if err != nil {
-			tx.Rollback()
-			panic(err)
-		}
+	n.ID = string(noteId)
+	created := []Note{n}
+	if err := withLinks(r.Context(), created); err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+	n = created[0]
 
-		_, err = tx.Exec("INSERT INTO notetags (tag_id, note_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", tagId, noteId)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(n)
+}
+
+// NotePatch holds the fields of a note to change, fields left out are kept.
+type NotePatch struct {
+	Body *string   `json:"body"`
+	Tags *[]string `json:"tags"`
+}
 
+func GetNote(w http.ResponseWriter, r *http.Request) {
+	noteRepo := notes.NewNoteRepo()
+	note, err := noteRepo.Get(r.Context(), notes.NoteID(mux.Vars(r)["id"]))
+	if errors.Is(err, notes.ErrNoteNotFound) {
+		// Merged notes redirect to the note they were merged into
+		to, err := noteRepo.GetRedirect(r.Context(), notes.NoteID(mux.Vars(r)["id"]))
 		if err != nil {
-			tx.Rollback()
-			panic(err)
+			http.NotFound(w, r)
+			return
 		}
+		http.Redirect(w, r, "/api/note/"+string(to), http.StatusMovedPermanently)
+		return
 	}
-	tx.Commit()
-	n.ID = noteId
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(n)
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	writeNote(w, r, note, http.StatusOK)
 }
 
-func DeleteNote(w http.ResponseWriter, r *http.Request) {
-	noteId := mux.Vars(r)["id"]
+// UpdateNote applies a patch to a note. Clients should send the ETag they
+// last saw in If-Match, if the note has been changed since the patch is
+// refused with 412 and the current note is returned so they can retry.
+func UpdateNote(w http.ResponseWriter, r *http.Request) {
+	noteId := notes.NoteID(mux.Vars(r)["id"])
 
-	db, err := sqlx.Connect("postgres", os.Getenv("DATABASE_URL"))
-	defer db.Close()
+	var patch NotePatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Could not decode note", http.StatusBadRequest)
+		return
+	}
+
+	noteRepo := notes.NewNoteRepo()
+	note, err := noteRepo.Get(r.Context(), noteId)
+	if errors.Is(err, notes.ErrNoteNotFound) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		panic(err)
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	if !notes.MatchETag(r.Header.Get("If-Match"), note) {
+		writeNote(w, r, note, http.StatusPreconditionFailed)
+		return
+	}
+
+	body := note.Body
+	if patch.Body != nil {
+		body = *patch.Body
+	}
+	tags := note.DisplayTags
+	if patch.Tags != nil {
+		tags = strings.Join(*patch.Tags, ",")
 	}
 
-	tx := db.MustBegin()
-	_, err = tx.Exec("DELETE FROM notetags WHERE note_id = $1", noteId)
+	err = noteRepo.Edit(r.Context(), noteId, body, tags, note.Version)
+	if errors.Is(err, notes.ErrStaleNote) {
+		// Someone saved between reading the note and writing it
+		note, err = noteRepo.Get(r.Context(), noteId)
+		if err != nil {
+			web.HandleUnexpectedError(w, err)
+			return
+		}
+		writeNote(w, r, note, http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
-		tx.Rollback()
-		panic(err)
+		web.HandleUnexpectedError(w, err)
+		return
 	}
 
-	_, err = tx.Exec("DELETE FROM notes WHERE id = $1", noteId)
+	note, err = noteRepo.Get(r.Context(), noteId)
 	if err != nil {
-		tx.Rollback()
-		panic(err)
+		web.HandleUnexpectedError(w, err)
+		return
 	}
 
-	tx.Commit()
+	writeNote(w, r, note, http.StatusOK)
+}
+
+// RelatedNote is a note connected to another, with the reasons why.
+type RelatedNote struct {
+	Note    Note     `json:"note"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+func RelatedNotes(w http.ResponseWriter, r *http.Request) {
+	related, err := notes.NewNoteRepo().GetRelated(r.Context(), notes.NoteID(mux.Vars(r)["id"]))
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	response := []RelatedNote{}
+	for _, rn := range related {
+		response = append(response, RelatedNote{
+			Note:    Note{ID: string(rn.Note.ID), Body: rn.Note.Body, Tags: rn.Note.Tags},
+			Score:   rn.Score,
+			Reasons: rn.Reasons,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func writeNote(w http.ResponseWriter, r *http.Request, note notes.Note, status int) {
+	response := []Note{{ID: string(note.ID), Body: note.Body, Tags: note.Tags}}
+	if err := withLinks(r.Context(), response); err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", note.ETag())
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response[0])
+}
+
+func DeleteNote(w http.ResponseWriter, r *http.Request) {
+	noteId := mux.Vars(r)["id"]
+
+	if err := notes.NewNoteRepo().Delete(r.Context(), notes.NoteID(noteId)); err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
 }
 
 func handleErr(err error) {
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/thrgamon/nous/links"
+	"github.com/thrgamon/nous/web"
+)
+
+// Links lists saved links, filtered like the links page with ?domain=,
+// ?status= and ?tag=.
+func Links(w http.ResponseWriter, r *http.Request) {
+	entries, err := links.NewLinkRepo().List(r.Context(), links.FilterFromRequest(r))
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	writeJSON(w, entries, http.StatusOK)
+}
+
+// GetLink returns a link with the notes that mention it.
+func GetLink(w http.ResponseWriter, r *http.Request) {
+	entry, err := links.NewLinkRepo().GetEntry(r.Context(), links.LinkID(mux.Vars(r)["id"]))
+	if errors.Is(err, links.ErrLinkNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	writeJSON(w, entry, http.StatusOK)
+}
+
+// RefetchLink queues a link's page to be fetched again.
+func RefetchLink(w http.ResponseWriter, r *http.Request) {
+	queueLinkJob(w, r, links.RefetchJob)
+}
+
+// ArchiveLink queues a link to be archived again.
+func ArchiveLink(w http.ResponseWriter, r *http.Request) {
+	queueLinkJob(w, r, links.ArchiveJob)
+}
+
+func queueLinkJob(w http.ResponseWriter, r *http.Request, kind string) {
+	linkRepo := links.NewLinkRepo()
+	link, err := linkRepo.Get(r.Context(), links.LinkID(mux.Vars(r)["id"]))
+	if errors.Is(err, links.ErrLinkNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	if err := linkRepo.Queue(r.Context(), kind, link.LinkID); err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	writeJSON(w, link, http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -55,6 +55,40 @@ func (rr ContextRepo) UpdateContext(ctx context.Context, context string) {
 	}
 }
 
+// Archiver is the archiver chosen for the links in a context's notes.
+type Archiver struct {
+	Context  string
+	Archiver string
+}
+
+func (rr ContextRepo) GetArchivers(ctx context.Context) ([]Archiver, error) {
+	rows, err := rr.db.Query(ctx, `SELECT context, archiver FROM contexts ORDER BY context`)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var archivers []Archiver
+	for rows.Next() {
+		var archiver Archiver
+		if err := rows.Scan(&archiver.Context, &archiver.Archiver); err != nil {
+			return archivers, err
+		}
+		archivers = append(archivers, archiver)
+	}
+
+	return archivers, rows.Err()
+}
+
+func (rr ContextRepo) SetArchiver(ctx context.Context, context string, archiver string) error {
+	_, err := rr.db.Exec(ctx, `UPDATE contexts SET archiver = $1 WHERE context = $2`, archiver, context)
+	if err != nil {
+		rr.logger.Println(err.Error())
+	}
+	return err
+}
+
 func (rr ContextRepo) parseData(rows pgx.Rows) []string {
 	var contexts []string
 
@@ -0,0 +1,8 @@
+CREATE TABLE "tag_aliases" (
+  "alias" varchar PRIMARY KEY,
+  "tag_id" int NOT NULL,
+  "inserted_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  CONSTRAINT fk_tag FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_tag_aliases_tag ON tag_aliases (tag_id);
@@ -0,0 +1,2 @@
+DROP TABLE "people";
+UPDATE tags SET type = 1 WHERE type = 3;
@@ -0,0 +1,20 @@
+CREATE TABLE "people" (
+  "id" SERIAL PRIMARY KEY,
+  "tag_id" int NOT NULL,
+  "display_name" text,
+  "contact_notes" text,
+  "inserted_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  CONSTRAINT fk_tag FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_uniq_people_tag ON people (tag_id);
+
+-- Existing @mentions were stored as plain category tags
+UPDATE tags SET type = 3
+WHERE tag IN (
+  SELECT DISTINCT lower(mention[2])
+  FROM notes, regexp_matches(notes.body, '(^|[^[:alnum:]_])@([[:alnum:]_]+)', 'g') AS mention
+);
+
+INSERT INTO people (tag_id) SELECT id FROM tags WHERE type = 3;
@@ -0,0 +1,3 @@
+ALTER TABLE tags DROP CONSTRAINT fk_tag_type;
+DROP TABLE "tag_type_values";
+DROP TABLE "tag_types";
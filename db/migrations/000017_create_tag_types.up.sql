@@ -0,0 +1,36 @@
+CREATE TABLE "tag_types" (
+  "id" SERIAL PRIMARY KEY,
+  "name" varchar NOT NULL,
+  "colour" varchar(7) DEFAULT '#888888' NOT NULL,
+  "exclusive" bool DEFAULT false NOT NULL,
+  "inserted_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_uniq_tag_type_name ON tag_types (name);
+
+-- The built in types keep the ids they have always had in tags.type
+INSERT INTO "tag_types" ("id", "name", "colour", "exclusive") VALUES
+(1, 'category', '#888888', 'f'),
+(2, 'priority', '#c0392b', 't'),
+(3, 'person', '#2e86c1', 'f');
+
+SELECT setval('tag_types_id_seq', 3);
+
+CREATE TABLE "tag_type_values" (
+  "id" SERIAL PRIMARY KEY,
+  "type_id" int NOT NULL,
+  "value" varchar NOT NULL,
+  "position" int DEFAULT 0 NOT NULL,
+  CONSTRAINT fk_tag_type FOREIGN KEY(type_id) REFERENCES tag_types(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_uniq_tag_type_value ON tag_type_values (type_id, value);
+
+INSERT INTO "tag_type_values" ("type_id", "value", "position") VALUES
+(2, 'Important & Urgent', 0),
+(2, 'Important', 1),
+(2, 'Urgent', 2),
+(2, 'Someday', 3);
+
+ALTER TABLE tags ADD CONSTRAINT fk_tag_type FOREIGN KEY(type) REFERENCES tag_types(id);
@@ -0,0 +1 @@
+ALTER TABLE tag_type_values DROP COLUMN wip_limit;
@@ -0,0 +1,11 @@
+ALTER TABLE tag_type_values ADD wip_limit int;
+
+INSERT INTO "tag_types" ("name", "colour", "exclusive") VALUES
+('status', '#27ae60', 't')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO "tag_type_values" ("type_id", "value", "position")
+SELECT tag_types.id, statuses.value, statuses.position
+FROM tag_types, (VALUES ('backlog', 0), ('doing', 1), ('blocked', 2), ('done', 3)) AS statuses (value, position)
+WHERE tag_types.name = 'status'
+ON CONFLICT (type_id, value) DO NOTHING;
@@ -0,0 +1,15 @@
+CREATE TABLE "note_tasks" (
+  "id" SERIAL PRIMARY KEY,
+  "note_id" int NOT NULL,
+  "position" int NOT NULL,
+  "body" text NOT NULL,
+  "done" bool DEFAULT false NOT NULL,
+  "due_date" date,
+  "assignee" varchar,
+  "inserted_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  CONSTRAINT fk_note FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_uniq_note_task_position ON note_tasks (note_id, position);
+CREATE INDEX idx_open_note_tasks ON note_tasks (due_date) WHERE NOT done;
@@ -0,0 +1,2 @@
+ALTER TABLE note_tasks DROP COLUMN hash;
+ALTER TABLE notes DROP COLUMN version;
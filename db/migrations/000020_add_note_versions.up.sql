@@ -0,0 +1,2 @@
+ALTER TABLE notes ADD version int DEFAULT 1 NOT NULL;
+ALTER TABLE note_tasks ADD hash varchar;
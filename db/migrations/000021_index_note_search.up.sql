@@ -0,0 +1,31 @@
+DROP TRIGGER refresh_note_search ON notes;
+DROP TRIGGER refresh_note_search ON notetags;
+DROP FUNCTION refresh_note_search();
+DROP MATERIALIZED VIEW note_search;
+
+CREATE TABLE note_search (
+  id integer PRIMARY KEY REFERENCES notes(id) ON DELETE CASCADE,
+  tags text[] DEFAULT '{}' NOT NULL,
+  doc tsvector NOT NULL
+);
+
+CREATE INDEX idx_fts_search ON note_search USING gin(doc);
+
+-- Rebuilds the search row for a single note. Called from the same
+-- transaction as any write to a note or its tags.
+CREATE FUNCTION index_note(note_id integer)
+RETURNS void LANGUAGE sql
+AS $$
+INSERT INTO note_search (id, tags, doc)
+SELECT notes.id,
+  COALESCE(array_agg(tags.tag) FILTER (WHERE tags.tag IS NOT NULL), '{}'),
+  to_tsvector((notes.body || ' ')) || setweight(to_tsvector(COALESCE(string_agg(tags.tag, ' '), '')), 'A')
+ FROM notes
+   LEFT JOIN notetags on notes.id = notetags.note_id
+   LEFT JOIN tags ON notetags.tag_id = tags.id
+WHERE notes.id = index_note.note_id
+GROUP BY notes.id
+ON CONFLICT (id) DO UPDATE SET tags = EXCLUDED.tags, doc = EXCLUDED.doc;
+$$;
+
+SELECT index_note(id) FROM notes;
@@ -0,0 +1,20 @@
+DROP FUNCTION index_note(integer, regconfig);
+
+CREATE FUNCTION index_note(note_id integer)
+RETURNS void LANGUAGE sql
+AS $$
+INSERT INTO note_search (id, tags, doc)
+SELECT notes.id,
+  COALESCE(array_agg(tags.tag) FILTER (WHERE tags.tag IS NOT NULL), '{}'),
+  to_tsvector((notes.body || ' ')) || setweight(to_tsvector(COALESCE(string_agg(tags.tag, ' '), '')), 'A')
+ FROM notes
+   LEFT JOIN notetags on notes.id = notetags.note_id
+   LEFT JOIN tags ON notetags.tag_id = tags.id
+WHERE notes.id = index_note.note_id
+GROUP BY notes.id
+ON CONFLICT (id) DO UPDATE SET tags = EXCLUDED.tags, doc = EXCLUDED.doc;
+$$;
+
+ALTER TABLE users DROP COLUMN search_language;
+
+DROP INDEX idx_trgm_notes_body;
@@ -0,0 +1,22 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_trgm_notes_body ON notes USING gin(body gin_trgm_ops);
+
+ALTER TABLE users ADD search_language regconfig DEFAULT 'english' NOT NULL;
+
+DROP FUNCTION index_note(integer);
+
+CREATE FUNCTION index_note(note_id integer, config regconfig DEFAULT 'english')
+RETURNS void LANGUAGE sql
+AS $$
+INSERT INTO note_search (id, tags, doc)
+SELECT notes.id,
+  COALESCE(array_agg(tags.tag) FILTER (WHERE tags.tag IS NOT NULL), '{}'),
+  to_tsvector(config, (notes.body || ' ')) || setweight(to_tsvector(config, COALESCE(string_agg(tags.tag, ' '), '')), 'A')
+ FROM notes
+   LEFT JOIN notetags on notes.id = notetags.note_id
+   LEFT JOIN tags ON notetags.tag_id = tags.id
+WHERE notes.id = index_note.note_id
+GROUP BY notes.id
+ON CONFLICT (id) DO UPDATE SET tags = EXCLUDED.tags, doc = EXCLUDED.doc;
+$$;
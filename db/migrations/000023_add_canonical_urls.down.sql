@@ -0,0 +1,4 @@
+DROP INDEX idx_canonical_url;
+
+ALTER TABLE links DROP COLUMN canonical_hash;
+ALTER TABLE links DROP COLUMN canonical_url;
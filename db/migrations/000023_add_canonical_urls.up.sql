@@ -0,0 +1,4 @@
+ALTER TABLE links ADD canonical_url text;
+ALTER TABLE links ADD canonical_hash text GENERATED ALWAYS AS (md5(canonical_url)) STORED;
+
+CREATE INDEX idx_canonical_url ON links (canonical_hash);
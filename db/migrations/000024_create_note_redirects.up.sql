@@ -0,0 +1,8 @@
+CREATE TABLE "note_redirects" (
+  "from_id" int PRIMARY KEY,
+  "to_id" int NOT NULL,
+  "inserted_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  CONSTRAINT fk_note FOREIGN KEY(to_id) REFERENCES notes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_note_redirects_to ON note_redirects (to_id);
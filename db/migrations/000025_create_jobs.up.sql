@@ -0,0 +1,16 @@
+CREATE TABLE "jobs" (
+  "id" SERIAL PRIMARY KEY,
+  "kind" text NOT NULL,
+  "payload" jsonb DEFAULT '{}' NOT NULL,
+  "status" smallint DEFAULT 1 NOT NULL,
+  "attempts" int DEFAULT 0 NOT NULL,
+  "max_attempts" int DEFAULT 8 NOT NULL,
+  "last_error" text,
+  "run_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  "locked_at" TIMESTAMP,
+  "inserted_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+-- Workers only ever look for pending jobs that are due
+CREATE INDEX idx_pending_jobs ON jobs (run_at, id) WHERE status = 1;
@@ -0,0 +1,2 @@
+ALTER TABLE links DROP COLUMN archive_checked_at;
+ALTER TABLE links DROP COLUMN archive_url;
@@ -0,0 +1,2 @@
+ALTER TABLE links ADD archive_url text;
+ALTER TABLE links ADD archive_checked_at TIMESTAMP;
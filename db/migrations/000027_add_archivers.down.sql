@@ -0,0 +1,5 @@
+DROP TABLE snapshot_assets;
+DROP TABLE snapshots;
+
+ALTER TABLE links DROP COLUMN archiver;
+ALTER TABLE contexts DROP COLUMN archiver;
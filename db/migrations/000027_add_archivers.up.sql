@@ -0,0 +1,18 @@
+ALTER TABLE contexts ADD archiver text DEFAULT 'wayback' NOT NULL;
+ALTER TABLE links ADD archiver text DEFAULT 'wayback' NOT NULL;
+
+CREATE TABLE "snapshots" (
+  "id" SERIAL PRIMARY KEY,
+  "url" text NOT NULL,
+  "html" text NOT NULL,
+  "inserted_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE "snapshot_assets" (
+  "id" SERIAL PRIMARY KEY,
+  "snapshot_id" int NOT NULL,
+  "url" text NOT NULL,
+  "content_type" text NOT NULL,
+  "body" bytea NOT NULL,
+  CONSTRAINT fk_snapshot FOREIGN KEY(snapshot_id) REFERENCES snapshots(id) ON DELETE CASCADE
+);
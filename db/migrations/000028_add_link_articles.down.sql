@@ -0,0 +1,21 @@
+CREATE OR REPLACE FUNCTION index_note(note_id integer, config regconfig DEFAULT 'english')
+RETURNS void LANGUAGE sql
+AS $$
+INSERT INTO note_search (id, tags, doc)
+SELECT notes.id,
+  COALESCE(array_agg(tags.tag) FILTER (WHERE tags.tag IS NOT NULL), '{}'),
+  to_tsvector(config, (notes.body || ' ')) || setweight(to_tsvector(config, COALESCE(string_agg(tags.tag, ' '), '')), 'A')
+ FROM notes
+   LEFT JOIN notetags on notes.id = notetags.note_id
+   LEFT JOIN tags ON notetags.tag_id = tags.id
+WHERE notes.id = index_note.note_id
+GROUP BY notes.id
+ON CONFLICT (id) DO UPDATE SET tags = EXCLUDED.tags, doc = EXCLUDED.doc;
+$$;
+
+ALTER TABLE links
+  DROP COLUMN source_urls,
+  DROP COLUMN lead_image,
+  DROP COLUMN published_at,
+  DROP COLUMN byline,
+  DROP COLUMN content;
@@ -0,0 +1,31 @@
+ALTER TABLE links
+  ADD content text,
+  ADD byline text,
+  ADD published_at TIMESTAMP,
+  ADD lead_image text,
+  -- The URL as written in each note that links here, which is how notes
+  -- find the article text for their search document
+  ADD source_urls text[] DEFAULT '{}' NOT NULL;
+
+UPDATE links SET source_urls = ARRAY[url];
+
+CREATE OR REPLACE FUNCTION index_note(note_id integer, config regconfig DEFAULT 'english')
+RETURNS void LANGUAGE sql
+AS $$
+INSERT INTO note_search (id, tags, doc)
+SELECT notes.id,
+  COALESCE(array_agg(tags.tag) FILTER (WHERE tags.tag IS NOT NULL), '{}'),
+  to_tsvector(config, (notes.body || ' '))
+    || setweight(to_tsvector(config, COALESCE(string_agg(tags.tag, ' '), '')), 'A')
+    || setweight(to_tsvector(config, COALESCE((
+      SELECT string_agg(concat_ws(' ', links.title, links.byline, links.content), ' ')
+      FROM links
+      WHERE EXISTS (SELECT 1 FROM unnest(links.source_urls) AS source_url WHERE strpos(notes.body, source_url) > 0)
+    ), '')), 'D')
+ FROM notes
+   LEFT JOIN notetags on notes.id = notetags.note_id
+   LEFT JOIN tags ON notetags.tag_id = tags.id
+WHERE notes.id = index_note.note_id
+GROUP BY notes.id
+ON CONFLICT (id) DO UPDATE SET tags = EXCLUDED.tags, doc = EXCLUDED.doc;
+$$;
@@ -0,0 +1,5 @@
+DROP TABLE link_images;
+
+ALTER TABLE links
+  DROP COLUMN site_name,
+  DROP COLUMN description;
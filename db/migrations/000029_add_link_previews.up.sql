@@ -0,0 +1,13 @@
+ALTER TABLE links
+  ADD description text,
+  ADD site_name text;
+
+-- Preview images are kept here so showing a preview doesn't make a request
+-- to the site it came from
+CREATE TABLE "link_images" (
+  "link_id" int PRIMARY KEY,
+  "source_url" text NOT NULL,
+  "content_type" text NOT NULL,
+  "body" bytea NOT NULL,
+  CONSTRAINT fk_link FOREIGN KEY(link_id) REFERENCES links(id) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+DROP INDEX idx_links_checked_at;
+
+ALTER TABLE links
+  DROP COLUMN dead,
+  DROP COLUMN check_failures,
+  DROP COLUMN checked_at,
+  DROP COLUMN redirect_chain,
+  DROP COLUMN check_error,
+  DROP COLUMN check_status;
@@ -0,0 +1,10 @@
+ALTER TABLE links
+  ADD check_status int,
+  ADD check_error text,
+  ADD redirect_chain text[] DEFAULT '{}' NOT NULL,
+  ADD checked_at TIMESTAMP,
+  -- Failed checks in a row, so one bad day doesn't mark a link dead
+  ADD check_failures int DEFAULT 0 NOT NULL,
+  ADD dead boolean DEFAULT false NOT NULL;
+
+CREATE INDEX idx_links_checked_at ON links (checked_at NULLS FIRST);
@@ -0,0 +1,31 @@
+CREATE OR REPLACE FUNCTION index_note(note_id integer, config regconfig DEFAULT 'english')
+RETURNS void LANGUAGE sql
+AS $$
+INSERT INTO note_search (id, tags, doc)
+SELECT notes.id,
+  COALESCE(array_agg(tags.tag) FILTER (WHERE tags.tag IS NOT NULL), '{}'),
+  to_tsvector(config, (notes.body || ' '))
+    || setweight(to_tsvector(config, COALESCE(string_agg(tags.tag, ' '), '')), 'A')
+    || setweight(to_tsvector(config, COALESCE((
+      SELECT string_agg(concat_ws(' ', links.title, links.byline, links.content), ' ')
+      FROM links
+      WHERE EXISTS (SELECT 1 FROM unnest(links.source_urls) AS source_url WHERE strpos(notes.body, source_url) > 0)
+    ), '')), 'D')
+ FROM notes
+   LEFT JOIN notetags on notes.id = notetags.note_id
+   LEFT JOIN tags ON notetags.tag_id = tags.id
+WHERE notes.id = index_note.note_id
+GROUP BY notes.id
+ON CONFLICT (id) DO UPDATE SET tags = EXCLUDED.tags, doc = EXCLUDED.doc;
+$$;
+
+DROP FUNCTION count_link_notes(integer[]);
+
+ALTER TABLE notes DROP COLUMN links_saved;
+
+ALTER TABLE links
+  DROP COLUMN orphaned_at,
+  DROP COLUMN note_count;
+
+DROP INDEX idx_links_source_urls;
+DROP TABLE note_links_urls;
@@ -0,0 +1,64 @@
+-- Each URL written in a note, and the link it was saved as once it has been
+-- processed
+CREATE TABLE "note_links_urls" (
+  "note_id" integer NOT NULL,
+  "url" text NOT NULL,
+  -- Where the URL comes in the note, to list its links in order
+  "position" integer NOT NULL,
+  "link_id" integer,
+  PRIMARY KEY (note_id, url),
+  CONSTRAINT fk_note FOREIGN KEY(note_id) REFERENCES notes(id) ON DELETE CASCADE,
+  CONSTRAINT fk_link FOREIGN KEY(link_id) REFERENCES links(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_note_links_urls_link_id ON note_links_urls (link_id);
+CREATE INDEX idx_note_links_urls_url ON note_links_urls (url);
+CREATE INDEX idx_links_source_urls ON links USING GIN (source_urls);
+
+ALTER TABLE links
+  -- The notes that mention the link, kept by count_link_notes
+  ADD note_count int DEFAULT 0 NOT NULL,
+  -- When the last note mentioning the link stopped, so it can be cleaned up
+  ADD orphaned_at TIMESTAMP;
+
+-- Notes already saved have their URLs found by the app, the same way it
+-- finds them when a note is saved. Until then no link is counted as orphaned.
+ALTER TABLE notes ADD links_saved boolean DEFAULT false NOT NULL;
+ALTER TABLE notes ALTER COLUMN links_saved SET DEFAULT true;
+
+CREATE OR REPLACE FUNCTION count_link_notes(link_ids integer[])
+RETURNS void LANGUAGE sql
+AS $$
+UPDATE links SET
+  note_count = counted.notes,
+  orphaned_at = CASE WHEN counted.notes = 0 THEN COALESCE(links.orphaned_at, NOW()) END
+FROM (
+  SELECT links.id, count(DISTINCT note_links_urls.note_id) AS notes
+  FROM links
+    LEFT JOIN note_links_urls ON note_links_urls.link_id = links.id
+  WHERE links.id = ANY(link_ids)
+  GROUP BY links.id
+) AS counted
+WHERE links.id = counted.id;
+$$;
+
+CREATE OR REPLACE FUNCTION index_note(note_id integer, config regconfig DEFAULT 'english')
+RETURNS void LANGUAGE sql
+AS $$
+INSERT INTO note_search (id, tags, doc)
+SELECT notes.id,
+  COALESCE(array_agg(tags.tag) FILTER (WHERE tags.tag IS NOT NULL), '{}'),
+  to_tsvector(config, (notes.body || ' '))
+    || setweight(to_tsvector(config, COALESCE(string_agg(tags.tag, ' '), '')), 'A')
+    || setweight(to_tsvector(config, COALESCE((
+      SELECT string_agg(concat_ws(' ', links.title, links.byline, links.content), ' ')
+      FROM links
+      WHERE links.id IN (SELECT link_id FROM note_links_urls WHERE note_links_urls.note_id = notes.id)
+    ), '')), 'D')
+ FROM notes
+   LEFT JOIN notetags on notes.id = notetags.note_id
+   LEFT JOIN tags ON notetags.tag_id = tags.id
+WHERE notes.id = index_note.note_id
+GROUP BY notes.id
+ON CONFLICT (id) DO UPDATE SET tags = EXCLUDED.tags, doc = EXCLUDED.doc;
+$$;
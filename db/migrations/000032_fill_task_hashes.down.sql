@@ -0,0 +1,3 @@
+ALTER TABLE note_tasks ALTER COLUMN hash DROP NOT NULL;
+
+ALTER TABLE notes DROP COLUMN tasks_saved;
@@ -0,0 +1,9 @@
+-- Tasks saved before hashes were added have none. Rather than work their
+-- hashes out here, the app finds the tasks of every note already saved again,
+-- the same way it does when a note is saved.
+ALTER TABLE notes ADD tasks_saved boolean DEFAULT false NOT NULL;
+ALTER TABLE notes ALTER COLUMN tasks_saved SET DEFAULT true;
+
+DELETE FROM note_tasks WHERE hash IS NULL;
+
+ALTER TABLE note_tasks ALTER COLUMN hash SET NOT NULL;
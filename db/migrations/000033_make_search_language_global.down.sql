@@ -0,0 +1,28 @@
+DROP FUNCTION index_note(integer);
+
+CREATE FUNCTION index_note(note_id integer, config regconfig DEFAULT 'english')
+RETURNS void LANGUAGE sql
+AS $$
+INSERT INTO note_search (id, tags, doc)
+SELECT notes.id,
+  COALESCE(array_agg(tags.tag) FILTER (WHERE tags.tag IS NOT NULL), '{}'),
+  to_tsvector(config, (notes.body || ' '))
+    || setweight(to_tsvector(config, COALESCE(string_agg(tags.tag, ' '), '')), 'A')
+    || setweight(to_tsvector(config, COALESCE((
+      SELECT string_agg(concat_ws(' ', links.title, links.byline, links.content), ' ')
+      FROM links
+      WHERE links.id IN (SELECT link_id FROM note_links_urls WHERE note_links_urls.note_id = notes.id)
+    ), '')), 'D')
+ FROM notes
+   LEFT JOIN notetags on notes.id = notetags.note_id
+   LEFT JOIN tags ON notetags.tag_id = tags.id
+WHERE notes.id = index_note.note_id
+GROUP BY notes.id
+ON CONFLICT (id) DO UPDATE SET tags = EXCLUDED.tags, doc = EXCLUDED.doc;
+$$;
+
+ALTER TABLE users ADD search_language regconfig DEFAULT 'english' NOT NULL;
+UPDATE users SET search_language = (SELECT language FROM search_settings);
+
+DROP FUNCTION search_language();
+DROP TABLE search_settings;
@@ -0,0 +1,45 @@
+-- The search language was a setting of each user, but note_search holds one
+-- document per note that everyone searches, so notes were stemmed in whichever
+-- language was last saved. It becomes a single setting of the app instead,
+-- rather than keeping an index per language.
+CREATE TABLE "search_settings" (
+  -- Always true, so there is only ever one row
+  "id" boolean PRIMARY KEY DEFAULT true CHECK (id),
+  "language" regconfig DEFAULT 'english' NOT NULL,
+  "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+-- Notes were last indexed in the language of whoever changed it most recently
+INSERT INTO search_settings (language)
+SELECT COALESCE((SELECT search_language FROM users ORDER BY updated_at DESC LIMIT 1), 'english');
+
+ALTER TABLE users DROP COLUMN search_language;
+
+CREATE FUNCTION search_language()
+RETURNS regconfig LANGUAGE sql STABLE
+AS $$
+SELECT language FROM search_settings;
+$$;
+
+DROP FUNCTION index_note(integer, regconfig);
+
+CREATE FUNCTION index_note(note_id integer)
+RETURNS void LANGUAGE sql
+AS $$
+INSERT INTO note_search (id, tags, doc)
+SELECT notes.id,
+  COALESCE(array_agg(tags.tag) FILTER (WHERE tags.tag IS NOT NULL), '{}'),
+  to_tsvector(search_language(), (notes.body || ' '))
+    || setweight(to_tsvector(search_language(), COALESCE(string_agg(tags.tag, ' '), '')), 'A')
+    || setweight(to_tsvector(search_language(), COALESCE((
+      SELECT string_agg(concat_ws(' ', links.title, links.byline, links.content), ' ')
+      FROM links
+      WHERE links.id IN (SELECT link_id FROM note_links_urls WHERE note_links_urls.note_id = notes.id)
+    ), '')), 'D')
+ FROM notes
+   LEFT JOIN notetags on notes.id = notetags.note_id
+   LEFT JOIN tags ON notetags.tag_id = tags.id
+WHERE notes.id = index_note.note_id
+GROUP BY notes.id
+ON CONFLICT (id) DO UPDATE SET tags = EXCLUDED.tags, doc = EXCLUDED.doc;
+$$;
@@ -0,0 +1,2 @@
+DROP INDEX idx_note_links_urls_domain;
+ALTER TABLE note_links_urls DROP COLUMN domain;
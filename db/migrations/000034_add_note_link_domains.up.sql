@@ -0,0 +1,5 @@
+-- The site each URL in a note is on, for finding notes that link to the same
+-- sites
+ALTER TABLE note_links_urls ADD domain text GENERATED ALWAYS AS (substring(lower(url) from '^https?://(?:www\.)?([^/?#:]+)')) STORED;
+
+CREATE INDEX idx_note_links_urls_domain ON note_links_urls (domain);
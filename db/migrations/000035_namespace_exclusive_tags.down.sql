@@ -0,0 +1,16 @@
+UPDATE tags SET tag = substr(tags.tag, length(tag_types.name) + 2), updated_at = NOW()
+FROM tag_types
+WHERE tag_types.id = tags.type
+  AND tag_types.exclusive
+  AND tag_types.id <> 2
+  AND left(tags.tag, length(tag_types.name) + 1) = tag_types.name || '/'
+  AND NOT EXISTS (SELECT 1 FROM tags other WHERE other.tag = substr(tags.tag, length(tag_types.name) + 2));
+
+SELECT index_note(note_id)
+FROM (
+  SELECT DISTINCT notetags.note_id
+  FROM notetags
+    JOIN tags ON tags.id = notetags.tag_id
+    JOIN tag_types ON tag_types.id = tags.type
+  WHERE tag_types.exclusive AND tag_types.id <> 2
+) AS typed;
@@ -0,0 +1,49 @@
+-- Values of exclusive types, other than priorities, are tagged under the
+-- type's name as in status/done, so they never take the name of an ordinary
+-- tag. Priorities (type 2) keep their own names.
+CREATE TEMPORARY TABLE namespaced AS
+SELECT tags.id, tag_types.name || '/' || tags.tag AS tag, plain.id AS plain_id
+FROM tags
+  JOIN tag_types ON tag_types.id = tags.type
+  LEFT JOIN tags plain ON lower(plain.tag) = lower(tag_types.name || '/' || tags.tag)
+WHERE tag_types.exclusive
+  AND tag_types.id <> 2
+  AND left(tags.tag, length(tag_types.name) + 1) <> tag_types.name || '/';
+
+-- An ordinary tag already written with the new name is folded into it
+INSERT INTO notetags (note_id, tag_id)
+SELECT notetags.note_id, namespaced.id
+FROM namespaced JOIN notetags ON notetags.tag_id = namespaced.plain_id
+ON CONFLICT DO NOTHING;
+
+UPDATE tag_aliases SET tag_id = namespaced.id
+FROM namespaced
+WHERE tag_aliases.tag_id = namespaced.plain_id;
+
+DELETE FROM notetags WHERE tag_id IN (SELECT plain_id FROM namespaced);
+DELETE FROM tags WHERE id IN (SELECT plain_id FROM namespaced);
+
+UPDATE tags SET tag = namespaced.tag, updated_at = NOW()
+FROM namespaced
+WHERE tags.id = namespaced.id;
+
+-- Ordinary tags naming a value the type has no tag for yet take its type
+UPDATE tags SET type = tag_types.id, tag = tag_types.name || '/' || tag_type_values.value, updated_at = NOW()
+FROM tag_types
+  JOIN tag_type_values ON tag_type_values.type_id = tag_types.id
+WHERE tags.type = 1
+  AND tag_types.exclusive
+  AND tag_types.id <> 2
+  AND lower(tags.tag) = lower(tag_types.name || '/' || tag_type_values.value)
+  AND NOT EXISTS (SELECT 1 FROM tags other WHERE other.tag = tag_types.name || '/' || tag_type_values.value);
+
+DROP TABLE namespaced;
+
+SELECT index_note(note_id)
+FROM (
+  SELECT DISTINCT notetags.note_id
+  FROM notetags
+    JOIN tags ON tags.id = notetags.tag_id
+    JOIN tag_types ON tag_types.id = tags.type
+  WHERE tag_types.exclusive AND tag_types.id <> 2
+) AS typed;
@@ -0,0 +1,25 @@
+package jobs
+
+import "time"
+
+const (
+	backoffBase = 30 * time.Second
+	backoffMax  = 6 * time.Hour
+)
+
+// Backoff is how long to wait before the next try of a job that has failed
+// attempts times. It doubles each time from 30 seconds, up to six hours.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return backoffBase
+	}
+
+	delay := backoffBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= backoffMax {
+			return backoffMax
+		}
+	}
+	return delay
+}
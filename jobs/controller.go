@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/web"
+)
+
+// jobsPageSize is how many jobs the jobs page lists.
+const jobsPageSize = 100
+
+type StatusCount struct {
+	Status Status
+	Count  int
+}
+
+type PageData struct {
+	Counts []StatusCount
+	Status Status
+	Jobs   []Job
+}
+
+// JobsHandler lists recent jobs, filtered to one status with ?status=.
+func JobsHandler(w http.ResponseWriter, r *http.Request) {
+	jobRepo := NewJobRepo()
+	status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+
+	counts, err := jobRepo.CountByStatus(r.Context())
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	jobs, err := jobRepo.GetRecent(r.Context(), Status(status), jobsPageSize)
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	pageData := PageData{Status: Status(status), Jobs: jobs}
+	for _, s := range []Status{Pending, Running, Done, Dead} {
+		pageData.Counts = append(pageData.Counts, StatusCount{Status: s, Count: counts[s]})
+	}
+
+	templates.RenderTemplate(w, "jobs", pageData)
+}
+
+// RetryHandler gives a dead job another set of attempts.
+func RetryHandler(w http.ResponseWriter, r *http.Request) {
+	id := JobID(mux.Vars(r)["id"])
+
+	if err := NewJobRepo().Retry(r.Context(), id); err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/jobs?status="+strconv.Itoa(int(Dead)), http.StatusSeeOther)
+}
@@ -0,0 +1,245 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/thrgamon/nous/database"
+	"github.com/thrgamon/nous/logger"
+)
+
+type JobID string
+
+type Status int
+
+const (
+	Pending Status = iota + 1
+	Running
+	Done
+	// Dead jobs have used up their attempts and are only run again if they
+	// are retried by hand
+	Dead
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Running:
+		return "running"
+	case Done:
+		return "done"
+	case Dead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+var ErrNoJobs = errors.New("no jobs are due")
+
+type Job struct {
+	ID          JobID
+	Kind        string
+	Payload     json.RawMessage
+	Status      Status
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	RunAt       time.Time
+	InsertedAt  time.Time
+}
+
+// Execer is satisfied by both the pool and a transaction, so jobs can be
+// enqueued as part of the write that needs them.
+type Execer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+// Enqueue adds a job to the queue. When q is a transaction the job only
+// exists if the transaction commits.
+func Enqueue(ctx context.Context, q Execer, kind string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(ctx, "INSERT INTO jobs (kind, payload) VALUES ($1, $2)", kind, data)
+	return err
+}
+
+type JobRepo struct {
+	db     *pgxpool.Pool
+	logger *log.Logger
+}
+
+func NewJobRepo() *JobRepo {
+	db := database.Database
+	logger := logger.Logger
+	return &JobRepo{db: db, logger: logger}
+}
+
+// Claim takes the next due job and marks it as running. Locked rows are
+// skipped so that any number of workers can claim at once.
+func (jr JobRepo) Claim(ctx context.Context) (Job, error) {
+	rows, err := jr.db.Query(
+		ctx,
+		`UPDATE jobs SET status = $1, attempts = attempts + 1, locked_at = NOW(), updated_at = NOW()
+WHERE id = (
+	SELECT id FROM jobs
+	WHERE status = $2 AND run_at <= NOW()
+	ORDER BY run_at, id
+	FOR UPDATE SKIP LOCKED
+	LIMIT 1
+)
+RETURNING `+jobColumns,
+		Running,
+		Pending,
+	)
+	if err != nil {
+		jr.logger.Println(err.Error())
+		return Job{}, err
+	}
+	defer rows.Close()
+
+	jobs, err := jr.parseData(rows)
+	if err != nil {
+		return Job{}, err
+	}
+	if len(jobs) == 0 {
+		return Job{}, ErrNoJobs
+	}
+
+	return jobs[0], nil
+}
+
+func (jr JobRepo) Complete(ctx context.Context, id JobID) error {
+	_, err := jr.db.Exec(ctx, "UPDATE jobs SET status = $1, last_error = NULL, locked_at = NULL, updated_at = NOW() WHERE id = $2", Done, id)
+	return err
+}
+
+// Fail records why a job failed and schedules it to be tried again after a
+// backoff, or marks it dead once it has used up its attempts.
+func (jr JobRepo) Fail(ctx context.Context, job Job, jobErr error) error {
+	if job.Attempts >= job.MaxAttempts {
+		return jr.Bury(ctx, job.ID, jobErr)
+	}
+
+	_, err := jr.db.Exec(
+		ctx,
+		"UPDATE jobs SET status = $1, last_error = $2, run_at = NOW() + $3::interval, locked_at = NULL, updated_at = NOW() WHERE id = $4",
+		Pending,
+		jobErr.Error(),
+		fmt.Sprintf("%d seconds", int(Backoff(job.Attempts).Seconds())),
+		job.ID,
+	)
+	return err
+}
+
+// Bury marks a job dead straight away, for failures retrying won't fix.
+func (jr JobRepo) Bury(ctx context.Context, id JobID, jobErr error) error {
+	_, err := jr.db.Exec(ctx, "UPDATE jobs SET status = $1, last_error = $2, locked_at = NULL, updated_at = NOW() WHERE id = $3", Dead, jobErr.Error(), id)
+	return err
+}
+
+// Retry gives a dead job a fresh set of attempts.
+func (jr JobRepo) Retry(ctx context.Context, id JobID) error {
+	_, err := jr.db.Exec(ctx, "UPDATE jobs SET status = $1, attempts = 0, run_at = NOW(), updated_at = NOW() WHERE id = $2 AND status = $3", Pending, id, Dead)
+	return err
+}
+
+// Requeue returns jobs left running by a worker that stopped without
+// finishing them, such as when the app was killed. Only jobs locked for
+// longer than age are taken, so jobs other workers are still running are
+// left alone. The attempt counts, so a job that keeps killing its worker
+// ends up dead like any other failing job.
+func (jr JobRepo) Requeue(ctx context.Context, age time.Duration) error {
+	_, err := jr.db.Exec(
+		ctx,
+		`UPDATE jobs SET
+	status = CASE WHEN attempts >= max_attempts THEN $1 ELSE $2 END,
+	last_error = $3,
+	locked_at = NULL,
+	updated_at = NOW()
+WHERE status = $4 AND locked_at < NOW() - $5::interval`,
+		Dead,
+		Pending,
+		"the worker running the job stopped",
+		Running,
+		fmt.Sprintf("%d seconds", int(age.Seconds())),
+	)
+	return err
+}
+
+// Prune deletes finished jobs older than the given age.
+func (jr JobRepo) Prune(ctx context.Context, age time.Duration) error {
+	_, err := jr.db.Exec(ctx, "DELETE FROM jobs WHERE status = $1 AND updated_at < NOW() - $2::interval", Done, fmt.Sprintf("%d seconds", int(age.Seconds())))
+	return err
+}
+
+// CountByStatus is the number of jobs in each state.
+func (jr JobRepo) CountByStatus(ctx context.Context) (map[Status]int, error) {
+	rows, err := jr.db.Query(ctx, "SELECT status, count(*) FROM jobs GROUP BY status")
+	if err != nil {
+		jr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[Status]int)
+	for rows.Next() {
+		var status Status
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// GetRecent returns the most recently changed jobs, optionally only those in
+// one state.
+func (jr JobRepo) GetRecent(ctx context.Context, status Status, limit int) ([]Job, error) {
+	rows, err := jr.db.Query(
+		ctx,
+		"SELECT "+jobColumns+" FROM jobs WHERE $1 = 0 OR status = $1 ORDER BY updated_at DESC LIMIT $2",
+		status,
+		limit,
+	)
+	if err != nil {
+		jr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	return jr.parseData(rows)
+}
+
+const jobColumns = "id, kind, payload, status, attempts, max_attempts, COALESCE(last_error, ''), run_at, inserted_at"
+
+func (jr JobRepo) parseData(rows pgx.Rows) ([]Job, error) {
+	var jobs []Job
+
+	for rows.Next() {
+		var id int
+		var job Job
+		err := rows.Scan(&id, &job.Kind, &job.Payload, &job.Status, &job.Attempts, &job.MaxAttempts, &job.LastError, &job.RunAt, &job.InsertedAt)
+		if err != nil {
+			jr.logger.Println(err.Error())
+			return jobs, err
+		}
+
+		job.ID = JobID(fmt.Sprint(id))
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
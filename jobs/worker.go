@@ -0,0 +1,210 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/thrgamon/nous/logger"
+)
+
+// Handler does the work of one kind of job. Returning an error schedules a
+// retry, unless the error wraps ErrPermanent.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// ErrPermanent marks a failure that retrying won't fix, so the job goes
+// straight to dead.
+var ErrPermanent = errors.New("permanent failure")
+
+const (
+	pollInterval = 2 * time.Second
+	jobTimeout   = 2 * time.Minute
+	// A job locked for longer than this has outlived its timeout, so the
+	// worker running it must have stopped
+	lockTimeout = 2 * jobTimeout
+	// Finished jobs are kept this long for the jobs page
+	keepDone = 7 * 24 * time.Hour
+)
+
+// queue is the part of JobRepo the pool needs.
+type queue interface {
+	Claim(ctx context.Context) (Job, error)
+	Complete(ctx context.Context, id JobID) error
+	Fail(ctx context.Context, job Job, jobErr error) error
+	Bury(ctx context.Context, id JobID, jobErr error) error
+	Requeue(ctx context.Context, age time.Duration) error
+	Prune(ctx context.Context, age time.Duration) error
+}
+
+// Pool runs queued jobs on a fixed number of workers, so however many jobs
+// are queued only that many run at once.
+type Pool struct {
+	queue        queue
+	workers      int
+	pollInterval time.Duration
+	handlers     map[string]Handler
+	logger       *log.Logger
+
+	stop    context.CancelFunc
+	abort   context.CancelFunc
+	running sync.WaitGroup
+}
+
+func NewPool(workers int) *Pool {
+	return newPool(NewJobRepo(), workers, pollInterval)
+}
+
+func newPool(q queue, workers int, poll time.Duration) *Pool {
+	return &Pool{
+		queue:        q,
+		workers:      workers,
+		pollInterval: poll,
+		handlers:     make(map[string]Handler),
+		logger:       logger.Logger,
+	}
+}
+
+// Register sets the handler for a kind of job. It should be called before
+// Start.
+func (p *Pool) Register(kind string, handler Handler) {
+	p.handlers[kind] = handler
+}
+
+// Start puts back any jobs a previous run didn't finish, clears out old
+// finished ones and starts the workers. Jobs left by workers that stop while
+// the pool runs, such as another instance's, are put back as they go stale.
+func (p *Pool) Start(ctx context.Context) error {
+	if err := p.queue.Requeue(ctx, lockTimeout); err != nil {
+		return err
+	}
+	if err := p.queue.Prune(ctx, keepDone); err != nil {
+		return err
+	}
+
+	// Stopping ends the polling, aborting also cancels jobs already running
+	stopCtx, stop := context.WithCancel(ctx)
+	abortCtx, abort := context.WithCancel(context.Background())
+	p.stop, p.abort = stop, abort
+
+	for i := 0; i < p.workers; i++ {
+		p.running.Add(1)
+		go p.work(stopCtx, abortCtx)
+	}
+
+	p.running.Add(1)
+	go p.requeueStale(stopCtx)
+
+	return nil
+}
+
+func (p *Pool) requeueStale(ctx context.Context) {
+	defer p.running.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(lockTimeout):
+		}
+
+		if err := p.queue.Requeue(ctx, lockTimeout); err != nil && ctx.Err() == nil {
+			p.logger.Println(err.Error())
+		}
+	}
+}
+
+// Shutdown stops workers taking new jobs and waits for the ones running to
+// finish. If ctx ends first the running jobs are cancelled and scheduled to
+// be tried again, like any other failure.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.stop()
+
+	done := make(chan struct{})
+	go func() {
+		p.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.abort()
+		return nil
+	case <-ctx.Done():
+		p.abort()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work(stopCtx context.Context, abortCtx context.Context) {
+	defer p.running.Done()
+
+	for {
+		if stopCtx.Err() != nil {
+			return
+		}
+
+		job, err := p.queue.Claim(stopCtx)
+		if err != nil {
+			if !errors.Is(err, ErrNoJobs) && stopCtx.Err() == nil {
+				p.logger.Println(err.Error())
+			}
+
+			select {
+			case <-stopCtx.Done():
+				return
+			case <-time.After(p.pollInterval):
+			}
+			continue
+		}
+
+		p.run(abortCtx, job)
+	}
+}
+
+func (p *Pool) run(ctx context.Context, job Job) {
+	handler, ok := p.handlers[job.Kind]
+	if !ok {
+		p.record(p.queue.Bury(ctx, job.ID, fmt.Errorf("no handler for %s jobs", job.Kind)))
+		return
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	err := safely(jobCtx, handler, job.Payload)
+
+	// Record the outcome even if the job was cancelled by shutdown
+	recordCtx := context.Background()
+	switch {
+	case err == nil:
+		p.record(p.queue.Complete(recordCtx, job.ID))
+	case errors.Is(err, ErrPermanent):
+		p.record(p.queue.Bury(recordCtx, job.ID, err))
+	default:
+		p.logger.Printf("%s job %s failed: %s", job.Kind, job.ID, err)
+		p.record(p.queue.Fail(recordCtx, job, err))
+	}
+}
+
+func (p *Pool) record(err error) {
+	if err != nil {
+		p.logger.Println(err.Error())
+	}
+}
+
+// safely runs a handler, turning a panic into an error so one bad job can't
+// take down the app.
+func safely(ctx context.Context, handler Handler, payload json.RawMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(ctx, payload)
+}
@@ -0,0 +1,183 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryQueue is a queue kept in memory, with every job always due.
+type memoryQueue struct {
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+func (q *memoryQueue) add(kind string, maxAttempts int) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := &Job{ID: JobID(kind), Kind: kind, Status: Pending, MaxAttempts: maxAttempts, Payload: json.RawMessage(`{}`)}
+	q.jobs = append(q.jobs, job)
+	return job
+}
+
+func (q *memoryQueue) Claim(ctx context.Context) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs {
+		if job.Status == Pending {
+			job.Status = Running
+			job.Attempts++
+			return *job, nil
+		}
+	}
+	return Job{}, ErrNoJobs
+}
+
+func (q *memoryQueue) set(id JobID, status Status, jobErr error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs {
+		if job.ID == id {
+			job.Status = status
+			if jobErr != nil {
+				job.LastError = jobErr.Error()
+			}
+		}
+	}
+}
+
+func (q *memoryQueue) Complete(ctx context.Context, id JobID) error {
+	q.set(id, Done, nil)
+	return nil
+}
+
+func (q *memoryQueue) Fail(ctx context.Context, job Job, jobErr error) error {
+	if job.Attempts >= job.MaxAttempts {
+		return q.Bury(ctx, job.ID, jobErr)
+	}
+	q.set(job.ID, Pending, jobErr)
+	return nil
+}
+
+func (q *memoryQueue) Bury(ctx context.Context, id JobID, jobErr error) error {
+	q.set(id, Dead, jobErr)
+	return nil
+}
+
+func (q *memoryQueue) Requeue(ctx context.Context, age time.Duration) error {
+	return nil
+}
+
+func (q *memoryQueue) Prune(ctx context.Context, age time.Duration) error {
+	return nil
+}
+
+func (q *memoryQueue) status(job *Job) Status {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return job.Status
+}
+
+func testPool(q queue) *Pool {
+	p := newPool(q, 2, time.Millisecond)
+	p.logger = log.New(io.Discard, "", 0)
+	return p
+}
+
+func TestPoolRetriesUntilDead(t *testing.T) {
+	q := &memoryQueue{}
+	ok := q.add("ok", 3)
+	flaky := q.add("flaky", 3)
+	broken := q.add("broken", 3)
+	permanent := q.add("permanent", 3)
+	unknown := q.add("unknown", 3)
+
+	var mu sync.Mutex
+	flakyCalls := 0
+
+	p := testPool(q)
+	p.Register("ok", func(ctx context.Context, payload json.RawMessage) error { return nil })
+	p.Register("flaky", func(ctx context.Context, payload json.RawMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		flakyCalls++
+		if flakyCalls < 2 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+	p.Register("broken", func(ctx context.Context, payload json.RawMessage) error { panic("boom") })
+	p.Register("permanent", func(ctx context.Context, payload json.RawMessage) error {
+		return fmt.Errorf("bad url: %w", ErrPermanent)
+	})
+
+	assert.NoError(t, p.Start(context.Background()))
+	assert.Eventually(t, func() bool {
+		for _, job := range []*Job{ok, flaky, broken, permanent, unknown} {
+			if s := q.status(job); s == Pending || s == Running {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, p.Shutdown(context.Background()))
+
+	assert.Equal(t, Done, q.status(ok))
+	assert.Equal(t, Done, q.status(flaky))
+	assert.Equal(t, Dead, q.status(broken))
+	assert.Equal(t, 3, broken.Attempts)
+	assert.Equal(t, "panic: boom", broken.LastError)
+	assert.Equal(t, Dead, q.status(permanent))
+	assert.Equal(t, 1, permanent.Attempts)
+	assert.Equal(t, Dead, q.status(unknown))
+}
+
+func TestPoolShutdownDrains(t *testing.T) {
+	q := &memoryQueue{}
+	slow := q.add("slow", 3)
+
+	started := make(chan struct{})
+	p := testPool(q)
+	p.Register("slow", func(ctx context.Context, payload json.RawMessage) error {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	assert.NoError(t, p.Start(context.Background()))
+	<-started
+	assert.NoError(t, p.Shutdown(context.Background()))
+	assert.Equal(t, Done, q.status(slow))
+}
+
+func TestPoolShutdownCancelsAfterDeadline(t *testing.T) {
+	q := &memoryQueue{}
+	stuck := q.add("stuck", 3)
+
+	started := make(chan struct{})
+	p := testPool(q)
+	p.Register("stuck", func(ctx context.Context, payload json.RawMessage) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	assert.NoError(t, p.Start(context.Background()))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Equal(t, Pending, q.status(stuck))
+}
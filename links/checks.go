@@ -0,0 +1,127 @@
+package links
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// CheckOutcome is what a check says about whether a link still works.
+type CheckOutcome int
+
+const (
+	Alive CheckOutcome = iota + 1
+	Failed
+	// Inconclusive checks, like being rate limited or refused as a bot,
+	// neither count for nor against a link
+	Inconclusive
+)
+
+// Check is the result of requesting a link to see if it still works.
+type Check struct {
+	Status        int
+	RedirectChain []string
+	Error         string
+	Outcome       CheckOutcome
+}
+
+// GetDueChecks returns links last checked before a time, least recently
+// checked first. Links no note mentions are left to be cleaned up.
+func (lr *LinkRepo) GetDueChecks(ctx context.Context, before time.Time, limit int) ([]Link, error) {
+	rows, err := lr.db.Query(
+		ctx,
+		"SELECT "+linkColumns+" FROM links WHERE note_count > 0 AND (checked_at IS NULL OR checked_at < $1) ORDER BY checked_at NULLS FIRST, id LIMIT $2",
+		before,
+		limit,
+	)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	return lr.parseData(rows)
+}
+
+// RecordCheck stores the result of checking a link. A link is dead once
+// deadAfter checks in a row have failed, and alive again as soon as one
+// succeeds.
+func (lr *LinkRepo) RecordCheck(ctx context.Context, id LinkID, check Check, deadAfter int) error {
+	redirectChain := check.RedirectChain
+	if redirectChain == nil {
+		redirectChain = []string{}
+	}
+
+	_, err := lr.db.Exec(
+		ctx,
+		`UPDATE
+	links
+SET
+	check_status = $1,
+	check_error = $2,
+	redirect_chain = $3,
+	checked_at = NOW(),
+	check_failures = CASE $4::int
+		WHEN $6 THEN 0
+		WHEN $7 THEN check_failures + 1
+		ELSE check_failures
+	END,
+	dead = CASE $4::int
+		WHEN $6 THEN FALSE
+		WHEN $7 THEN check_failures + 1 >= $5
+		ELSE dead
+	END
+WHERE
+	id = $8`,
+		check.Status,
+		check.Error,
+		redirectChain,
+		int(check.Outcome),
+		deadAfter,
+		int(Alive),
+		int(Failed),
+		id,
+	)
+	if err != nil {
+		lr.logger.Println(err.Error())
+	}
+	return err
+}
+
+// DeadNote is a note with the dead links it mentions.
+type DeadNote struct {
+	Note  NoteRef
+	Links []Entry
+}
+
+// GroupByNote arranges dead links under each note that mentions them, most
+// dead links first. Links no note mentions any more come last, under an
+// empty NoteRef.
+func GroupByNote(entries []Entry) []DeadNote {
+	var report []DeadNote
+	var unmentioned []Entry
+	byNote := make(map[string]int)
+
+	for _, entry := range entries {
+		if len(entry.Notes) == 0 {
+			unmentioned = append(unmentioned, entry)
+		}
+		for _, note := range entry.Notes {
+			i, ok := byNote[note.ID]
+			if !ok {
+				i = len(report)
+				byNote[note.ID] = i
+				report = append(report, DeadNote{Note: note})
+			}
+			report[i].Links = append(report[i].Links, entry)
+		}
+	}
+
+	sort.SliceStable(report, func(i, j int) bool {
+		return len(report[i].Links) > len(report[j].Links)
+	})
+	if len(unmentioned) > 0 {
+		report = append(report, DeadNote{Links: unmentioned})
+	}
+	return report
+}
@@ -0,0 +1,135 @@
+package links
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/web"
+)
+
+type PageData struct {
+	Links    []Entry
+	Domains  []string
+	Statuses []ArchiveStatus
+	Filter   Filter
+	// The filters as a query string, to come back to after an action
+	Query template.URL
+}
+
+// FilterFromRequest reads the ?domain=, ?status=, ?tag= and ?dead= filters.
+func FilterFromRequest(r *http.Request) Filter {
+	query := r.URL.Query()
+	status, _ := strconv.Atoi(query.Get("status"))
+	dead, _ := strconv.ParseBool(query.Get("dead"))
+	return Filter{Domain: query.Get("domain"), Status: ArchiveStatus(status), Tag: query.Get("tag"), Dead: dead}
+}
+
+// LibraryHandler lists every saved link.
+func LibraryHandler(w http.ResponseWriter, r *http.Request) {
+	linkRepo := NewLinkRepo()
+	filter := FilterFromRequest(r)
+
+	entries, err := linkRepo.List(r.Context(), filter)
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	domains, err := linkRepo.Domains(r.Context())
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "links", PageData{
+		Links:    entries,
+		Domains:  domains,
+		Statuses: ArchiveStatuses,
+		Filter:   filter,
+		Query:    template.URL(r.URL.Query().Encode()),
+	})
+}
+
+// LinkHandler shows a link with every note that mentions it.
+func LinkHandler(w http.ResponseWriter, r *http.Request) {
+	entry, err := NewLinkRepo().GetEntry(r.Context(), LinkID(mux.Vars(r)["id"]))
+	if errors.Is(err, ErrLinkNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "link", entry)
+}
+
+// DeadLinksHandler reports the dead links, arranged by the notes that
+// mention them.
+func DeadLinksHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := NewLinkRepo().List(r.Context(), Filter{Dead: true})
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "dead-links", GroupByNote(entries))
+}
+
+// RefetchHandler queues a link's page to be fetched again for its article
+// and preview.
+func RefetchHandler(w http.ResponseWriter, r *http.Request) {
+	queueAndReturn(w, r, RefetchJob)
+}
+
+// ArchiveHandler queues a link to be archived again.
+func ArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	queueAndReturn(w, r, ArchiveJob)
+}
+
+// queueAndReturn queues a job for the link and goes back to the links page
+// with the same filters.
+func queueAndReturn(w http.ResponseWriter, r *http.Request, kind string) {
+	linkRepo := NewLinkRepo()
+	link, err := linkRepo.Get(r.Context(), LinkID(mux.Vars(r)["id"]))
+	if errors.Is(err, ErrLinkNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	if err := linkRepo.Queue(r.Context(), kind, link.LinkID); err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/links?"+r.URL.RawQuery, http.StatusSeeOther)
+}
+
+// ImageHandler serves the preview image kept for a link, so previews don't
+// load anything from the linked site.
+func ImageHandler(w http.ResponseWriter, r *http.Request) {
+	image, err := NewLinkRepo().GetImage(r.Context(), LinkID(mux.Vars(r)["id"]))
+	if errors.Is(err, ErrLinkNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", image.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Write(image.Body)
+}
@@ -0,0 +1,221 @@
+package links
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thrgamon/nous/jobs"
+)
+
+// The jobs queued from the links page, run by the url package
+const (
+	RefetchJob = "refetch_link"
+	ArchiveJob = "archive_link"
+)
+
+// JobPayload is the payload of a RefetchJob or ArchiveJob.
+type JobPayload struct {
+	LinkID LinkID `json:"link_id"`
+}
+
+// Queue queues a RefetchJob or ArchiveJob for a link.
+func (lr *LinkRepo) Queue(ctx context.Context, kind string, id LinkID) error {
+	return jobs.Enqueue(ctx, lr.db, kind, JobPayload{LinkID: id})
+}
+
+func (s ArchiveStatus) String() string {
+	switch s {
+	case Unsubmitted:
+		return "unsubmitted"
+	case Pending:
+		return "pending"
+	case Error:
+		return "failed"
+	case Success:
+		return "archived"
+	case Skipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("status %d", int(s))
+}
+
+// ArchiveStatuses lists the statuses in the order they are offered.
+var ArchiveStatuses = []ArchiveStatus{Success, Pending, Error, Unsubmitted, Skipped}
+
+func (l Link) Status() ArchiveStatus {
+	return ArchiveStatus(l.ArchiveStatus)
+}
+
+// Href is where to send someone following the link: its archived copy once
+// the link has died, if there is one.
+func (l Link) Href() string {
+	if l.Dead && l.ArchiveURL != "" {
+		return l.ArchiveURL
+	}
+	return l.Url
+}
+
+// NoteRef is a note that mentions a link.
+type NoteRef struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// Entry is a link as listed in the links library.
+type Entry struct {
+	Link
+	Domain string    `json:"domain"`
+	Notes  []NoteRef `json:"notes"`
+}
+
+// Filter narrows the links library. Zero values don't filter.
+type Filter struct {
+	Domain string
+	Status ArchiveStatus
+	Tag    string
+	Dead   bool
+	ID     LinkID
+}
+
+// libraryLimit is the most links listed at once.
+const libraryLimit = 500
+
+// List returns the links matching filter, newest first, with the notes that
+// mention them. A tag matches links mentioned by a note with that tag.
+func (lr *LinkRepo) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	rows, err := lr.db.Query(
+		ctx,
+		`SELECT
+	`+linkColumns+`,
+	COALESCE(link_domain.domain, ''),
+	COALESCE(refs.ids, '{}'),
+	COALESCE(refs.titles, '{}')
+FROM
+	links
+	CROSS JOIN LATERAL (
+		SELECT
+			substring(COALESCE(canonical_url, url) FROM '^[a-z]+://([^/:?#]+)') AS domain) AS link_domain
+	LEFT JOIN LATERAL (
+		SELECT
+			array_agg(notes.id::text ORDER BY notes.id DESC) AS ids,
+			array_agg(left(split_part(notes.body, E'\n', 1), 80) ORDER BY notes.id DESC) AS titles,
+			bool_or($3 = ANY (note_search.tags)) AS tagged
+		FROM
+			notes
+			JOIN note_search ON note_search.id = notes.id
+		WHERE
+			notes.id IN (
+				SELECT
+					note_id
+				FROM
+					note_links_urls
+				WHERE
+					note_links_urls.link_id = links.id)) AS refs ON TRUE
+WHERE ($1 = '' OR link_domain.domain = $1)
+	AND ($2 = 0 OR archive_status = $2)
+	AND ($3 = '' OR refs.tagged)
+	AND (NOT $5 OR dead)
+	AND ($6 = '' OR links.id::text = $6)
+ORDER BY
+	links.id DESC
+LIMIT $4`,
+		filter.Domain,
+		int(filter.Status),
+		filter.Tag,
+		libraryLimit,
+		filter.Dead,
+		string(filter.ID),
+	)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		var ids, titles []string
+		entry.Link, err = lr.scanLink(rows, &entry.Domain, &ids, &titles)
+		if err != nil {
+			return entries, err
+		}
+
+		entry.Notes = []NoteRef{}
+		for i := range ids {
+			entry.Notes = append(entry.Notes, NoteRef{ID: ids[i], Title: titles[i]})
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Domains lists the domains of saved links, most linked first.
+func (lr *LinkRepo) Domains(ctx context.Context) ([]string, error) {
+	rows, err := lr.db.Query(
+		ctx,
+		`SELECT
+	domain
+FROM (
+	SELECT
+		substring(COALESCE(canonical_url, url) FROM '^[a-z]+://([^/:?#]+)') AS domain
+	FROM
+		links) AS link_domains
+WHERE
+	domain IS NOT NULL
+GROUP BY
+	domain
+ORDER BY
+	count(*) DESC,
+	domain`,
+	)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []string
+	for rows.Next() {
+		var domain string
+		if err := rows.Scan(&domain); err != nil {
+			return domains, err
+		}
+		domains = append(domains, domain)
+	}
+
+	return domains, rows.Err()
+}
+
+// GetEntry returns a link with the notes that mention it.
+func (lr *LinkRepo) GetEntry(ctx context.Context, id LinkID) (Entry, error) {
+	entries, err := lr.List(ctx, Filter{ID: id})
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(entries) == 0 {
+		return Entry{}, ErrLinkNotFound
+	}
+
+	return entries[0], nil
+}
+
+func (lr *LinkRepo) Get(ctx context.Context, id LinkID) (Link, error) {
+	rows, err := lr.db.Query(ctx, "SELECT "+linkColumns+" FROM links WHERE id = $1", id)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return Link{}, err
+	}
+	defer rows.Close()
+
+	links, err := lr.parseData(rows)
+	if err != nil {
+		return Link{}, err
+	}
+	if len(links) == 0 {
+		return Link{}, ErrLinkNotFound
+	}
+
+	return links[0], nil
+}
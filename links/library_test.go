@@ -0,0 +1,49 @@
+package links
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/links?domain=example.com&status=3&tag=to+read", nil)
+	assert.Equal(t, Filter{Domain: "example.com", Status: Error, Tag: "to read"}, FilterFromRequest(r))
+
+	r = httptest.NewRequest("GET", "/links?status=soon", nil)
+	assert.Equal(t, Filter{}, FilterFromRequest(r))
+}
+
+func TestArchiveStatusString(t *testing.T) {
+	assert.Equal(t, "archived", Success.String())
+	assert.Equal(t, "skipped", Link{ArchiveStatus: int(Skipped)}.Status().String())
+	assert.Equal(t, "status 9", ArchiveStatus(9).String())
+}
+
+func TestGroupByNote(t *testing.T) {
+	first := NoteRef{ID: "1"}
+	second := NoteRef{ID: "2"}
+	a := Entry{Link: Link{LinkID: "a"}, Notes: []NoteRef{first}}
+	b := Entry{Link: Link{LinkID: "b"}, Notes: []NoteRef{first, second}}
+	c := Entry{Link: Link{LinkID: "c"}, Notes: []NoteRef{second}}
+	orphan := Entry{Link: Link{LinkID: "d"}, Notes: []NoteRef{}}
+
+	report := GroupByNote([]Entry{c, a, b, orphan})
+	assert.Equal(t, []DeadNote{
+		{Note: second, Links: []Entry{c, b}},
+		{Note: first, Links: []Entry{a, b}},
+		{Links: []Entry{orphan}},
+	}, report)
+}
+
+func TestHref(t *testing.T) {
+	link := Link{Url: "https://example.com", ArchiveURL: "/snapshots/1"}
+	assert.Equal(t, "https://example.com", link.Href())
+
+	link.Dead = true
+	assert.Equal(t, "/snapshots/1", link.Href())
+
+	link.ArchiveURL = ""
+	assert.Equal(t, "https://example.com", link.Href())
+}
@@ -0,0 +1,252 @@
+package links
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// NoteLink is a URL written in a note, with the link it was saved as. Links
+// still waiting to be fetched have no ID or title yet.
+type NoteLink struct {
+	URL    string `json:"url"`
+	LinkID LinkID `json:"link_id,omitempty"`
+	Title  string `json:"title"`
+}
+
+// SaveNoteURLs records the URLs written in a note, joining each to its link
+// if it has been saved, and recounts the notes of every link the note
+// mentioned before or mentions now. No URLs forgets the note's links, as
+// when it is deleted. It takes the note's transaction so the two agree.
+func SaveNoteURLs(ctx context.Context, tx pgx.Tx, noteID string, urls []string) error {
+	var before []int
+	err := tx.QueryRow(ctx, "SELECT COALESCE(array_agg(link_id), '{}') FROM note_links_urls WHERE note_id = $1 AND link_id IS NOT NULL", noteID).Scan(&before)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM note_links_urls WHERE note_id = $1", noteID)
+	if err != nil {
+		return err
+	}
+
+	if len(urls) > 0 {
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO note_links_urls (note_id, url, position, link_id)
+SELECT
+	$1,
+	wanted.url,
+	wanted.position,
+	(
+		SELECT
+			id
+		FROM
+			links
+		WHERE
+			source_urls @> ARRAY[wanted.url]
+		ORDER BY
+			id
+		LIMIT 1)
+FROM
+	unnest($2::text[]) WITH ORDINALITY AS wanted (url, position)
+ON CONFLICT DO NOTHING`,
+			noteID,
+			urls,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		"SELECT count_link_notes($1::int[] || ARRAY(SELECT link_id FROM note_links_urls WHERE note_id = $2 AND link_id IS NOT NULL))",
+		before,
+		noteID,
+	)
+	return err
+}
+
+// attachNotes joins the notes that wrote url, before its link was saved, to
+// the link. It reports whether any were.
+func (lr *LinkRepo) attachNotes(ctx context.Context, id LinkID, url string) (bool, error) {
+	tag, err := lr.db.Exec(ctx, "UPDATE note_links_urls SET link_id = $1 WHERE url = $2 AND link_id IS NULL", id, url)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return false, err
+	}
+
+	// Run even when nothing was attached, so a link no note mentions any
+	// more is counted as an orphan
+	_, err = lr.db.Exec(ctx, "SELECT count_link_notes(ARRAY[$1::int])", id)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// CountAllNotes recounts the notes of every link, marking those no note
+// mentions as orphaned.
+func (lr *LinkRepo) CountAllNotes(ctx context.Context) error {
+	_, err := lr.db.Exec(ctx, "SELECT count_link_notes(array_agg(id)) FROM links")
+	if err != nil {
+		lr.logger.Println(err.Error())
+	}
+	return err
+}
+
+// GetByNote returns the saved links a note mentions, in the order it
+// mentions them.
+func (lr *LinkRepo) GetByNote(ctx context.Context, noteID string) ([]Link, error) {
+	rows, err := lr.db.Query(
+		ctx,
+		`SELECT
+	`+linkColumns+`
+FROM
+	links
+	JOIN (
+		SELECT
+			link_id,
+			min(position) AS position
+		FROM
+			note_links_urls
+		WHERE
+			note_id = $1
+		GROUP BY
+			link_id) AS mentioned ON mentioned.link_id = links.id
+ORDER BY
+	mentioned.position`,
+		noteID,
+	)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	return lr.parseData(rows)
+}
+
+// GetNoteLinks returns the URLs written in each of the notes, in order,
+// keyed by note ID.
+func (lr *LinkRepo) GetNoteLinks(ctx context.Context, noteIDs []string) (map[string][]NoteLink, error) {
+	rows, err := lr.db.Query(
+		ctx,
+		`SELECT
+	note_links_urls.note_id,
+	note_links_urls.url,
+	links.id,
+	COALESCE(links.title, '')
+FROM
+	note_links_urls
+	LEFT JOIN links ON links.id = note_links_urls.link_id
+WHERE
+	note_links_urls.note_id::text = ANY ($1)
+ORDER BY
+	note_links_urls.note_id,
+	note_links_urls.position`,
+		noteIDs,
+	)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	noteLinks := make(map[string][]NoteLink)
+	for rows.Next() {
+		var noteID int
+		var linkID *int
+		var link NoteLink
+		if err := rows.Scan(&noteID, &link.URL, &linkID, &link.Title); err != nil {
+			lr.logger.Println(err.Error())
+			return noteLinks, err
+		}
+		if linkID != nil {
+			link.LinkID = LinkID(fmt.Sprint(*linkID))
+		}
+		key := fmt.Sprint(noteID)
+		noteLinks[key] = append(noteLinks[key], link)
+	}
+
+	return noteLinks, rows.Err()
+}
+
+// GetArchivedCopies returns the archived copy of each dead link written in
+// the notes, keyed by note ID and then by the URL as it is written.
+func (lr *LinkRepo) GetArchivedCopies(ctx context.Context, noteIDs []string) (map[string]map[string]string, error) {
+	rows, err := lr.db.Query(
+		ctx,
+		`SELECT
+	note_links_urls.note_id,
+	note_links_urls.url,
+	links.archive_url
+FROM
+	note_links_urls
+	JOIN links ON links.id = note_links_urls.link_id
+WHERE
+	note_links_urls.note_id::text = ANY ($1)
+	AND links.dead
+	AND links.archive_url <> ''`,
+		noteIDs,
+	)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	archived := make(map[string]map[string]string)
+	for rows.Next() {
+		var noteID int
+		var url, archiveURL string
+		if err := rows.Scan(&noteID, &url, &archiveURL); err != nil {
+			lr.logger.Println(err.Error())
+			return archived, err
+		}
+		key := fmt.Sprint(noteID)
+		if archived[key] == nil {
+			archived[key] = make(map[string]string)
+		}
+		archived[key][url] = archiveURL
+	}
+
+	return archived, rows.Err()
+}
+
+// DeleteOrphans deletes the links no note has mentioned since before a
+// time, along with their local snapshots. It returns how many went.
+func (lr *LinkRepo) DeleteOrphans(ctx context.Context, before time.Time) (int, error) {
+	var deleted int
+	err := lr.db.QueryRow(
+		ctx,
+		`WITH deleted AS (
+	DELETE FROM links
+	WHERE note_count = 0
+		AND orphaned_at < $1
+	RETURNING
+		archive_url
+),
+deleted_snapshots AS (
+	DELETE FROM snapshots
+	WHERE '/snapshots/' || snapshots.id IN (
+			SELECT
+				archive_url
+			FROM
+				deleted))
+SELECT
+	count(*)
+FROM
+	deleted`,
+		before,
+	).Scan(&deleted)
+	if err != nil {
+		lr.logger.Println(err.Error())
+	}
+	return deleted, err
+}
@@ -2,9 +2,12 @@ package links
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/thrgamon/nous/database"
 	"github.com/thrgamon/nous/logger"
@@ -19,17 +22,59 @@ const (
 	Pending
 	Error
 	Success
+	// Skipped links belong to a context that doesn't archive
+	Skipped
 )
 
 type Link struct {
-	LinkID           LinkID `json:"id"`
-	Url              string `json:"url"`
-	Title            string `json:"title"`
-	ArchiveStatus    int    `json:"archive_status"`
-	ArchiveJobID     string `json:"archive_job_id"`
-	ArchiveException string `json:"archive_exception"`
+	LinkID           LinkID     `json:"id"`
+	Url              string     `json:"url"`
+	Title            string     `json:"title"`
+	ArchiveStatus    int        `json:"archive_status"`
+	ArchiveJobID     string     `json:"archive_job_id"`
+	ArchiveException string     `json:"archive_exception"`
+	ArchiveURL       string     `json:"archive_url"`
+	Archiver         string     `json:"archiver"`
+	Byline           string     `json:"byline"`
+	PublishedAt      *time.Time `json:"published_at"`
+	LeadImage        string     `json:"lead_image"`
+	Description      string     `json:"description"`
+	SiteName         string     `json:"site_name"`
+	HasImage         bool       `json:"has_image"`
+	Dead             bool       `json:"dead"`
+	CheckStatus      int        `json:"check_status"`
+	CheckError       string     `json:"check_error"`
+	RedirectChain    []string   `json:"redirect_chain"`
+	CheckedAt        *time.Time `json:"checked_at"`
+	NoteCount        int        `json:"note_count"`
 }
 
+// Preview is what a link's page says about itself for link previews.
+type Preview struct {
+	Title       string
+	Description string
+	SiteName    string
+	Image       string
+}
+
+// Image is a preview image kept for a link.
+type Image struct {
+	SourceURL   string
+	ContentType string
+	Body        []byte
+}
+
+// Article is the readable part of a linked page.
+type Article struct {
+	Title       string
+	Text        string
+	Byline      string
+	PublishedAt *time.Time
+	LeadImage   string
+}
+
+var ErrLinkNotFound = errors.New("link not found")
+
 type LinkRepo struct {
 	db     *pgxpool.Pool
 	logger *log.Logger
@@ -41,16 +86,92 @@ func NewLinkRepo() *LinkRepo {
 	return &LinkRepo{db: db, logger: logger}
 }
 
-func (lr *LinkRepo) Exists(ctx context.Context, url string) (bool, error) {
+// Exists checks for a link with the same canonical form, so that variants
+// of a URL already saved aren't saved again.
+func (lr *LinkRepo) Exists(ctx context.Context, canonicalURL string) (bool, error) {
 	var exists bool
-	err := lr.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM links WHERE url_hash=md5($1))`, url).Scan(&exists)
+	err := lr.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM links WHERE canonical_hash=md5($1))`, canonicalURL).Scan(&exists)
 	return exists, err
 }
 
-func (lr *LinkRepo) AddLink(ctx context.Context, url string) (LinkID, error) {
+// GetByCanonical finds the link saved for a canonical URL.
+func (lr *LinkRepo) GetByCanonical(ctx context.Context, canonicalURL string) (Link, error) {
+	rows, err := lr.db.Query(ctx, "SELECT "+linkColumns+" FROM links WHERE canonical_hash=md5($1)", canonicalURL)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return Link{}, err
+	}
+	defer rows.Close()
+
+	links, err := lr.parseData(rows)
+	if err != nil {
+		return Link{}, err
+	}
+	if len(links) == 0 {
+		return Link{}, ErrLinkNotFound
+	}
+
+	return links[0], nil
+}
+
+// GetPendingArchives returns links submitted to the archive that haven't
+// finished, least recently checked first.
+func (lr *LinkRepo) GetPendingArchives(ctx context.Context, limit int) ([]Link, error) {
+	rows, err := lr.db.Query(
+		ctx,
+		"SELECT "+linkColumns+" FROM links WHERE archive_status = $1 AND archive_job_id <> '' ORDER BY archive_checked_at NULLS FIRST, id LIMIT $2",
+		Pending,
+		limit,
+	)
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	return lr.parseData(rows)
+}
+
+// AddLink saves a link for a URL written in a note, and joins the notes
+// that wrote it to the link.
+func (lr *LinkRepo) AddLink(ctx context.Context, url string, canonicalURL string) (LinkID, error) {
 	var id int
-	err := lr.db.QueryRow(ctx, "INSERT INTO links (url) VALUES ($1) RETURNING id", url).Scan(&id)
-	return LinkID(fmt.Sprint(id)), err
+	err := lr.db.QueryRow(ctx, "INSERT INTO links (url, canonical_url, source_urls) VALUES ($1, $2, ARRAY[$1::text]) RETURNING id", url, canonicalURL).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	linkID := LinkID(fmt.Sprint(id))
+	_, err = lr.attachNotes(ctx, linkID, url)
+	return linkID, err
+}
+
+// GetUncanonicalised returns the links saved before URLs were canonicalised,
+// keyed by id.
+func (lr *LinkRepo) GetUncanonicalised(ctx context.Context) (map[LinkID]string, error) {
+	rows, err := lr.db.Query(ctx, "SELECT id, url FROM links WHERE canonical_url IS NULL")
+	if err != nil {
+		lr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make(map[LinkID]string)
+	for rows.Next() {
+		var id int
+		var url string
+		if err := rows.Scan(&id, &url); err != nil {
+			return urls, err
+		}
+		urls[LinkID(fmt.Sprint(id))] = url
+	}
+
+	return urls, rows.Err()
+}
+
+func (lr *LinkRepo) EditCanonicalURL(ctx context.Context, id LinkID, canonicalURL string) error {
+	_, err := lr.db.Exec(ctx, "UPDATE links SET canonical_url=$1 WHERE links.id = $2", canonicalURL, id)
+	return err
 }
 
 func (lr *LinkRepo) EditLinkTitle(ctx context.Context, id LinkID, title string) error {
@@ -63,7 +184,139 @@ func (lr *LinkRepo) EditLinkURL(ctx context.Context, id LinkID, url string) erro
 	return err
 }
 
+// EditArchiveURL records the snapshot of a link made by the archive.
+func (lr *LinkRepo) EditArchiveURL(ctx context.Context, id LinkID, archiveURL string) error {
+	_, err := lr.db.Exec(ctx, "UPDATE links SET archive_url=$1 WHERE links.id = $2", archiveURL, id)
+	return err
+}
+
+// MarkArchiveChecked notes that an archive job was checked and hadn't
+// finished, so other pending links are checked first next time.
+func (lr *LinkRepo) MarkArchiveChecked(ctx context.Context, id LinkID) error {
+	_, err := lr.db.Exec(ctx, "UPDATE links SET archive_checked_at=NOW() WHERE links.id = $1", id)
+	return err
+}
+
 func (lr *LinkRepo) EditArchiveStatus(ctx context.Context, id LinkID, status ArchiveStatus, jobID string, exception string) error {
 	_, err := lr.db.Exec(ctx, "UPDATE links SET archive_status=$1, archive_exception=$2, archive_job_id=$3 WHERE links.id = $4", status, exception, jobID, id)
 	return err
 }
+
+// EditPreview stores what a link's page says about itself. Its title is
+// usually tidier than the page's, so replaces it.
+func (lr *LinkRepo) EditPreview(ctx context.Context, id LinkID, preview Preview) error {
+	_, err := lr.db.Exec(
+		ctx,
+		"UPDATE links SET title=COALESCE(NULLIF($1, ''), title), description=$2, site_name=$3, updated_at=NOW() WHERE links.id = $4",
+		preview.Title,
+		preview.Description,
+		preview.SiteName,
+		id,
+	)
+	return err
+}
+
+func (lr *LinkRepo) SaveImage(ctx context.Context, id LinkID, image Image) error {
+	_, err := lr.db.Exec(
+		ctx,
+		`INSERT INTO link_images (link_id, source_url, content_type, body) VALUES ($1, $2, $3, $4)
+ON CONFLICT (link_id) DO UPDATE SET source_url = EXCLUDED.source_url, content_type = EXCLUDED.content_type, body = EXCLUDED.body`,
+		id,
+		image.SourceURL,
+		image.ContentType,
+		image.Body,
+	)
+	return err
+}
+
+func (lr *LinkRepo) GetImage(ctx context.Context, id LinkID) (Image, error) {
+	var image Image
+	err := lr.db.QueryRow(ctx, "SELECT source_url, content_type, body FROM link_images WHERE link_id = $1", id).Scan(&image.SourceURL, &image.ContentType, &image.Body)
+	if err == pgx.ErrNoRows {
+		return image, ErrLinkNotFound
+	}
+	return image, err
+}
+
+// EditArticle stores the text extracted from a link's page.
+func (lr *LinkRepo) EditArticle(ctx context.Context, id LinkID, article Article) error {
+	_, err := lr.db.Exec(
+		ctx,
+		"UPDATE links SET title=$1, content=$2, byline=$3, published_at=$4, lead_image=$5, updated_at=NOW() WHERE links.id = $6",
+		article.Title,
+		article.Text,
+		article.Byline,
+		article.PublishedAt,
+		article.LeadImage,
+		id,
+	)
+	return err
+}
+
+// AddSourceURL records a URL as it was written in a note, and joins the
+// notes that wrote it that way to the link. It reports whether any notes
+// were newly joined, and so need indexing with the link's article.
+func (lr *LinkRepo) AddSourceURL(ctx context.Context, id LinkID, url string) (bool, error) {
+	_, err := lr.db.Exec(
+		ctx,
+		"UPDATE links SET source_urls = array_append(source_urls, $1) WHERE links.id = $2 AND NOT $1 = ANY(source_urls)",
+		url,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	return lr.attachNotes(ctx, id, url)
+}
+
+// IndexNotes rebuilds the search documents of the notes that link here, so
+// they pick up the link's article text.
+func (lr *LinkRepo) IndexNotes(ctx context.Context, id LinkID) error {
+	_, err := lr.db.Exec(
+		ctx,
+		"SELECT index_note(note_id) FROM (SELECT DISTINCT note_id FROM note_links_urls WHERE link_id = $1) AS linking",
+		id,
+	)
+	if err != nil {
+		lr.logger.Println(err.Error())
+	}
+	return err
+}
+
+// EditArchiver records which archiver a link was submitted to, so its job
+// is checked with the same one.
+func (lr *LinkRepo) EditArchiver(ctx context.Context, id LinkID, archiver string) error {
+	_, err := lr.db.Exec(ctx, "UPDATE links SET archiver=$1 WHERE links.id = $2", archiver, id)
+	return err
+}
+
+const linkColumns = "id, url, COALESCE(title, ''), archive_status, COALESCE(archive_job_id, ''), COALESCE(archive_exception, ''), COALESCE(archive_url, ''), archiver, COALESCE(byline, ''), published_at, COALESCE(lead_image, ''), COALESCE(description, ''), COALESCE(site_name, ''), EXISTS(SELECT 1 FROM link_images WHERE link_images.link_id = links.id), dead, COALESCE(check_status, 0), COALESCE(check_error, ''), redirect_chain, checked_at, note_count"
+
+func (lr *LinkRepo) parseData(rows pgx.Rows) ([]Link, error) {
+	var links []Link
+
+	for rows.Next() {
+		link, err := lr.scanLink(rows)
+		if err != nil {
+			return links, err
+		}
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// scanLink reads the linkColumns of a row, followed by any extra columns.
+func (lr *LinkRepo) scanLink(rows pgx.Rows, extra ...interface{}) (Link, error) {
+	var id int
+	var link Link
+	dest := []interface{}{&id, &link.Url, &link.Title, &link.ArchiveStatus, &link.ArchiveJobID, &link.ArchiveException, &link.ArchiveURL, &link.Archiver, &link.Byline, &link.PublishedAt, &link.LeadImage, &link.Description, &link.SiteName, &link.HasImage, &link.Dead, &link.CheckStatus, &link.CheckError, &link.RedirectChain, &link.CheckedAt, &link.NoteCount}
+
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		lr.logger.Println(err.Error())
+		return link, err
+	}
+
+	link.LinkID = LinkID(fmt.Sprint(id))
+	return link, nil
+}
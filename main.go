@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/thrgamon/go-utils/env"
@@ -14,9 +17,16 @@ import (
 	"github.com/thrgamon/nous/database"
 	"github.com/thrgamon/nous/environment"
 	isoDate "github.com/thrgamon/nous/iso_date"
+	"github.com/thrgamon/nous/jobs"
+	"github.com/thrgamon/nous/links"
 	"github.com/thrgamon/nous/logger"
 	"github.com/thrgamon/nous/notes"
+	"github.com/thrgamon/nous/people"
+	"github.com/thrgamon/nous/settings"
+	"github.com/thrgamon/nous/snapshots"
+	"github.com/thrgamon/nous/tags"
 	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/url"
 	"github.com/thrgamon/nous/web"
 
 	"github.com/gorilla/handlers"
@@ -58,28 +68,100 @@ func main() {
 	authedRouter.HandleFunc("/search", SearchHandler)
 	authedRouter.HandleFunc("/live_search", LiveSearchHandler)
 	authedRouter.HandleFunc("/tag", TagHandler)
-
+	authedRouter.HandleFunc("/tags", tags.ManageHandler).Methods("GET")
+	authedRouter.HandleFunc("/tags/{id:[0-9]+}", tags.RenameHandler).Methods("PUT")
+	authedRouter.HandleFunc("/tags/{id:[0-9]+}", tags.DeleteHandler).Methods("DELETE")
+	authedRouter.HandleFunc("/tags/{id:[0-9]+}/merge", tags.MergeHandler).Methods("POST")
+	authedRouter.HandleFunc("/tags/{id:[0-9]+}/aliases", tags.AddAliasHandler).Methods("POST")
+	authedRouter.HandleFunc("/tags/aliases", tags.DeleteAliasHandler).Methods("DELETE")
+	authedRouter.HandleFunc("/tags/tree", tags.TreeHandler).Methods("GET")
+	authedRouter.HandleFunc("/tag-types", tags.TypesHandler).Methods("GET")
+	authedRouter.HandleFunc("/tag-types", tags.CreateTypeHandler).Methods("POST")
+	authedRouter.HandleFunc("/tag-types/{id:[0-9]+}", tags.UpdateTypeHandler).Methods("POST")
+	authedRouter.HandleFunc("/tags/suggest", tags.SuggestHandler).Methods("GET", "POST")
+
+	authedRouter.HandleFunc("/settings", settings.Handler).Methods("GET")
+	authedRouter.HandleFunc("/settings", settings.UpdateHandler).Methods("POST")
+	authedRouter.HandleFunc("/settings/archivers", settings.ArchiversHandler).Methods("POST")
 	authedRouter.HandleFunc("/active-context", GetActiveContextHandler).Methods("GET")
 	authedRouter.HandleFunc("/switch-context", GetContextHandler).Methods("GET")
 	authedRouter.HandleFunc("/switch-context/{context:[a-z]+}", UpdateContextHandler).Methods("PUT")
 	authedRouter.HandleFunc("/note", notes.CreateHandler).Methods("POST")
 	authedRouter.HandleFunc("/note/{id:[0-9]+}", notes.ViewNoteHandler).Methods("GET")
+	authedRouter.HandleFunc("/notes/{id:[0-9]+}", notes.NotePageHandler).Methods("GET")
 	authedRouter.HandleFunc("/note/{id:[0-9]+}/delete", notes.DeleteHandler)
 	authedRouter.HandleFunc("/note/{id:[0-9]+}/edit", notes.EditHandler).Methods("GET")
 	authedRouter.HandleFunc("/note/{id:[0-9]+}/edit", notes.UpdateHandler).Methods("PUT")
 	authedRouter.HandleFunc("/note/{id:[0-9]+}/toggle", notes.ToggleHandler)
 	authedRouter.HandleFunc("/note/{id:[0-9]+}/review", notes.ReviewedHandler).Methods("PATCH")
 	authedRouter.HandleFunc("/note/{id:[0-9]+}/status", notes.StatusHandler).Methods("PATCH")
-	authedRouter.HandleFunc("/note/{id:[0-9]+}/todo/{todoIndex:[0-9]+}", notes.ToggleTodoHandler).Methods("PUT")
+	authedRouter.HandleFunc("/note/{id:[0-9]+}/tag/{type}", notes.SetTagHandler).Methods("PATCH")
+	authedRouter.HandleFunc("/note/{id:[0-9]+}/merge", notes.MergeHandler).Methods("POST")
+	authedRouter.HandleFunc("/note/{id:[0-9]+}/split", notes.SplitHandler).Methods("POST")
+	authedRouter.HandleFunc("/note/{id:[0-9]+}/append", notes.AppendHandler).Methods("POST")
+	authedRouter.HandleFunc("/note/{id:[0-9]+}/related", notes.RelatedHandler).Methods("GET")
+	authedRouter.HandleFunc("/note/{id:[0-9]+}/previews", notes.PreviewsHandler).Methods("GET")
+	authedRouter.HandleFunc("/note/{id:[0-9]+}/task/{hash:[0-9a-f]+}", notes.ToggleTaskHandler).Methods("PUT")
 	authedRouter.HandleFunc("/todos", TodoHandler).Methods("GET")
+	authedRouter.HandleFunc("/tasks", notes.TasksHandler).Methods("GET")
+	authedRouter.HandleFunc("/board", notes.BoardHandler).Methods("GET")
+	authedRouter.HandleFunc("/board/note/{id:[0-9]+}", notes.MoveCardHandler).Methods("PATCH")
+	authedRouter.HandleFunc("/board/limit", notes.WIPLimitHandler).Methods("POST")
+	authedRouter.HandleFunc("/people", people.DirectoryHandler).Methods("GET")
+	authedRouter.HandleFunc("/people/{name}", people.PersonHandler).Methods("GET")
+	authedRouter.HandleFunc("/people/{name}", people.UpdateHandler).Methods("POST")
+	authedRouter.HandleFunc("/people/{name}/aliases", people.AddAliasHandler).Methods("POST")
+	authedRouter.HandleFunc("/links", links.LibraryHandler).Methods("GET")
+	authedRouter.HandleFunc("/links/dead", links.DeadLinksHandler).Methods("GET")
+	authedRouter.HandleFunc("/links/{id:[0-9]+}", links.LinkHandler).Methods("GET")
+	authedRouter.HandleFunc("/links/{id:[0-9]+}/image", links.ImageHandler).Methods("GET")
+	authedRouter.HandleFunc("/links/{id:[0-9]+}/refetch", links.RefetchHandler).Methods("POST")
+	authedRouter.HandleFunc("/links/{id:[0-9]+}/archive", links.ArchiveHandler).Methods("POST")
+	authedRouter.HandleFunc("/jobs", jobs.JobsHandler).Methods("GET")
+	authedRouter.HandleFunc("/jobs/{id:[0-9]+}/retry", jobs.RetryHandler).Methods("POST")
+	authedRouter.HandleFunc("/snapshots/{id:[0-9]+}", snapshots.SnapshotHandler).Methods("GET")
+	authedRouter.HandleFunc("/snapshots/{id:[0-9]+}/assets/{asset:[0-9]+}", snapshots.AssetHandler).Methods("GET")
 	authedRouter.HandleFunc("/api/readings", ApiReadingHandler).Methods("GET")
 
 	authedRouter.HandleFunc("/api/notes", api.AllNotes).Methods("GET")
 	authedRouter.HandleFunc("/api/note", api.CreateNote).Methods("POST")
+	authedRouter.HandleFunc("/api/note/{id:[0-9]+}", api.GetNote).Methods("GET")
+	authedRouter.HandleFunc("/api/note/{id:[0-9]+}", api.UpdateNote).Methods("PATCH")
 	authedRouter.HandleFunc("/api/note/{id:[0-9]+}", api.DeleteNote).Methods("DELETE")
+	authedRouter.HandleFunc("/api/v1/notes/{id:[0-9]+}/related", api.RelatedNotes).Methods("GET")
+	authedRouter.HandleFunc("/api/v1/links", api.Links).Methods("GET")
+	authedRouter.HandleFunc("/api/v1/links/{id:[0-9]+}", api.GetLink).Methods("GET")
+	authedRouter.HandleFunc("/api/v1/links/{id:[0-9]+}/refetch", api.RefetchLink).Methods("POST")
+	authedRouter.HandleFunc("/api/v1/links/{id:[0-9]+}/archive", api.ArchiveLink).Methods("POST")
 
 	authedRouter.PathPrefix("/public/").HandlerFunc(web.ServeResources)
 
+	// Bring notes saved by older versions up to date
+	noteRepo := notes.NewNoteRepo()
+	for _, backfill := range []func(context.Context) error{
+		url.CanonicaliseLinks,
+		noteRepo.SaveUnsavedLinks,
+		noteRepo.SaveUnsavedTasks,
+	} {
+		go func(backfill func(context.Context) error) {
+			if err := backfill(context.Background()); err != nil {
+				logger.Logger.Println(err.Error())
+			}
+		}(backfill)
+	}
+
+	pool := jobs.NewPool(4)
+	pool.Register(url.ProcessURLJob, url.HandleProcessURL)
+	pool.Register(links.RefetchJob, url.HandleRefetchLink)
+	pool.Register(links.ArchiveJob, url.HandleArchiveLink)
+	if err := pool.Start(context.Background()); err != nil {
+		logger.Logger.Fatal(err)
+	}
+
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	go url.NewArchivePoller().Run(pollCtx)
+	go url.NewLinkChecker().Run(pollCtx)
+
 	srv := &http.Server{
 		Handler:      handlers.LoggingHandler(os.Stdout, r),
 		Addr:         "0.0.0.0:" + env.GetEnvWithFallback("PORT", "8080"),
@@ -87,8 +169,29 @@ func main() {
 		ReadTimeout:  15 * time.Second,
 	}
 
-	logger.Logger.Println("Server listening")
-	logger.Logger.Fatal(srv.ListenAndServe())
+	go func() {
+		logger.Logger.Println("Server listening")
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			logger.Logger.Fatal(err)
+		}
+	}()
+
+	// Finish in flight requests and jobs before exiting
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	logger.Logger.Println("Shutting down")
+	stopPolling()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Logger.Println(err.Error())
+	}
+	if err := pool.Shutdown(ctx); err != nil {
+		logger.Logger.Println(err.Error())
+	}
 }
 
 type PageData struct {
@@ -208,10 +311,10 @@ func TodoHandler(w http.ResponseWriter, r *http.Request) {
 func TagHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 
-	tags := r.FormValue("tags")
+	tagInput := r.FormValue("tags")
 
 	noteRepo := notes.NewNoteRepo()
-	notes, err := noteRepo.GetByTags(r.Context(), tags)
+	notes, err := noteRepo.GetByTagTree(r.Context(), tagInput)
 
 	if err != nil {
 		web.HandleUnexpectedError(w, err)
@@ -229,7 +332,7 @@ func LiveSearchHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.FormValue("query")
 
 	noteRepo := notes.NewNoteRepo()
-	notes, err := noteRepo.Search(r.Context(), query)
+	notes, err := noteRepo.SearchPrefix(r.Context(), query)
 
 	if err != nil {
 		web.HandleUnexpectedError(w, err)
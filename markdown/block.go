@@ -0,0 +1,1266 @@
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type blockKind int
+
+const (
+	documentBlock blockKind = iota
+	paragraphBlock
+	headingBlock
+	thematicBreakBlock
+	codeBlock
+	fencedCodeBlock
+	blockquoteBlock
+	listBlock
+	listItemBlock
+	htmlBlock
+)
+
+// blockState is what a block reports back after trying to open or
+// continue on the current line.
+type blockState int
+
+const (
+	stateClose blockState = 1 << iota
+	stateContinue
+	stateHasChildren
+	stateNoChildren
+	stateRequireParagraph
+)
+
+// segment is a slice of the source, optionally preceded by padding spaces
+// left over from a tab that was only partly consumed as indentation.
+type segment struct {
+	start, stop, padding int
+}
+
+func (s segment) value(source string) string {
+	if s.padding == 0 {
+		return source[s.start:s.stop]
+	}
+	return strings.Repeat(" ", s.padding) + source[s.start:s.stop]
+}
+
+type block struct {
+	kind        blockKind
+	parent      *block
+	children    []*block
+	lines       []segment
+	blankBefore bool
+
+	level  int    // heading level
+	setext bool   // heading underlined rather than prefixed with #
+	id     string // heading id
+
+	marker byte // list marker
+	start  int  // ordered list start
+	tight  bool // list without blank lines between its items
+	offset int  // column list item content starts at
+
+	textBlock bool  // paragraph rendered without <p> in a tight list
+	inlines   *node // parsed content of a paragraph or heading
+
+	info     string // fenced code info string
+	hasInfo  bool
+	htmlType int
+	closure  bool // html block ended on a line of its own
+}
+
+func (b *block) appendChild(c *block) {
+	c.parent = b
+	b.children = append(b.children, c)
+}
+
+func (b *block) removeChild(c *block) {
+	for i, child := range b.children {
+		if child == c {
+			b.children = append(b.children[:i], b.children[i+1:]...)
+			c.parent = nil
+			return
+		}
+	}
+}
+
+func (b *block) lastChild() *block {
+	if len(b.children) == 0 {
+		return nil
+	}
+	return b.children[len(b.children)-1]
+}
+
+func (b *block) ordered() bool {
+	return b.marker == '.' || b.marker == ')'
+}
+
+// reader walks the source a line at a time, keeping track of columns so
+// tabs can be expanded to the next multiple of four.
+type reader struct {
+	source  string
+	line    int
+	head    int
+	pos     int
+	stop    int
+	padding int
+}
+
+func newReader(source string) *reader {
+	r := &reader{source: source, line: -1}
+	r.advanceLine()
+	return r
+}
+
+func (r *reader) peekLine() (string, segment, bool) {
+	if r.pos < len(r.source) {
+		s := segment{r.pos, r.stop, r.padding}
+		return s.value(r.source), s, true
+	}
+	return "", segment{}, false
+}
+
+func (r *reader) lineOffset() int {
+	v := 0
+	for i := r.head; i < r.pos; i++ {
+		if r.source[i] == '\t' {
+			v += tabWidth(v)
+		} else {
+			v++
+		}
+	}
+	return v - r.padding
+}
+
+func (r *reader) advance(n int) {
+	if line, _, _ := r.peekLine(); n < len(line) && r.padding == 0 {
+		r.pos += n
+		return
+	}
+	for ; n > 0 && r.pos < len(r.source); n-- {
+		if r.padding != 0 {
+			r.padding--
+			continue
+		}
+		if r.source[r.pos] == '\n' {
+			r.advanceLine()
+			continue
+		}
+		r.pos++
+	}
+}
+
+func (r *reader) advanceAndSetPadding(n, padding int) {
+	r.advance(n)
+	if padding > r.padding {
+		r.padding = padding
+	}
+}
+
+func (r *reader) advanceLine() {
+	r.pos = r.stop
+	r.head = r.pos
+	r.stop = len(r.source)
+	if i := strings.IndexByte(r.source[r.pos:], '\n'); i >= 0 {
+		r.stop = r.pos + i + 1
+	}
+	r.line++
+	r.padding = 0
+}
+
+type lineStat struct {
+	lineNum int
+	level   int
+	isBlank bool
+}
+
+type fenceData struct {
+	char   byte
+	indent int
+	length int
+	node   *block
+}
+
+type blockParser struct {
+	r           *reader
+	doc         *block
+	open        []*block
+	blankLines  []lineStat
+	blockOffset int
+
+	skipList                bool
+	emptyItemWithBlankLines bool
+	fence                   *fenceData
+	setextParagraph         *block
+	ids                     map[string]bool
+}
+
+// opener is one kind of block that can start on a line.
+type opener struct {
+	triggers        string
+	interrupts      bool // may start while a paragraph is open
+	acceptsIndented bool // may start on a line indented four or more columns
+	open            func(p *blockParser, parent *block) (*block, blockState)
+}
+
+var openers []opener
+
+func init() {
+	openers = []opener{
+		{"-=", true, false, (*blockParser).openSetextHeading},
+		{"-*_", true, false, (*blockParser).openThematicBreak},
+		{"-+*0123456789", true, false, (*blockParser).openList},
+		{"-+*0123456789", true, false, (*blockParser).openListItem},
+		{"", false, true, (*blockParser).openCodeBlock},
+		{"#", true, false, (*blockParser).openATXHeading},
+		{"`~", true, false, (*blockParser).openFencedCode},
+		{">", true, false, (*blockParser).openBlockquote},
+		{"<", true, false, (*blockParser).openHTMLBlock},
+		{"", false, false, (*blockParser).openParagraph},
+	}
+}
+
+// openersFor returns the openers triggered by c, followed by the ones that
+// may start on any line.
+func openersFor(c byte) []opener {
+	var triggered, free []opener
+	for _, o := range openers {
+		if o.triggers == "" {
+			free = append(free, o)
+		} else if strings.IndexByte(o.triggers, c) >= 0 {
+			triggered = append(triggered, o)
+		}
+	}
+	return append(triggered, free...)
+}
+
+func parseBlocks(source string) *block {
+	p := &blockParser{
+		r:   newReader(source),
+		doc: &block{kind: documentBlock},
+		ids: map[string]bool{},
+	}
+	p.parse()
+	return p.doc
+}
+
+func (p *blockParser) lastOpened() *block {
+	if len(p.open) == 0 {
+		return nil
+	}
+	return p.open[len(p.open)-1]
+}
+
+func (p *blockParser) parse() {
+	for {
+		lines := 0
+		for {
+			line, _, ok := p.r.peekLine()
+			if !ok {
+				return
+			}
+			if !isBlank(line) {
+				break
+			}
+			lines++
+			p.r.advanceLine()
+		}
+		lineNum := p.r.line
+		if lines != 0 {
+			p.blankLines = p.blankLines[:0]
+			for i := range p.open {
+				p.blankLines = append(p.blankLines, lineStat{lineNum - 1, i, true})
+			}
+		}
+		if p.openBlocks(p.doc, isBlankLine(lineNum-1, 0, p.blankLines)) != newBlocksOpened {
+			return
+		}
+		p.r.advanceLine()
+		for len(p.open) > 0 {
+			open := p.open
+			lastIndex := len(open) - 1
+			for i := range open {
+				b := open[i]
+				line, _, ok := p.r.peekLine()
+				if !ok {
+					p.closeBlocks(lastIndex, 0)
+					p.r.advanceLine()
+					return
+				}
+				lineNum := p.r.line
+				p.blankLines = append(p.blankLines, lineStat{lineNum, i, isBlank(line)})
+				if b.kind != paragraphBlock {
+					state := p.continueBlock(b)
+					if state&stateContinue != 0 {
+						if state&stateHasChildren != 0 && i == lastIndex {
+							p.openBlocks(b, isBlankLine(lineNum-1, i, p.blankLines))
+							break
+						}
+						continue
+					}
+				}
+				parent := p.doc
+				if i != 0 {
+					parent = open[i-1]
+				}
+				last := open[lastIndex]
+				if p.openBlocks(parent, isBlankLine(lineNum-1, i, p.blankLines)) != paragraphContinuation {
+					if open[lastIndex] != last {
+						lastIndex--
+					}
+					p.closeBlocks(lastIndex, i)
+				}
+				break
+			}
+			p.r.advanceLine()
+		}
+	}
+}
+
+type openResult int
+
+const (
+	paragraphContinuation openResult = iota
+	newBlocksOpened
+	noBlocksOpened
+)
+
+func (p *blockParser) openBlocks(parent *block, blankLine bool) openResult {
+	result := noBlocksOpened
+	last := p.lastOpened()
+	continuable := last != nil && last.kind == paragraphBlock
+retry:
+	line, _, _ := p.r.peekLine()
+	w, pos := indentWidth(line, p.r.lineOffset())
+	if w >= len(line) {
+		p.blockOffset = -1
+	} else {
+		p.blockOffset = pos
+	}
+	if line != "" && line[0] != '\n' {
+		candidates := openersFor(0)
+		if pos < len(line) {
+			candidates = openersFor(line[pos])
+		}
+		for _, o := range candidates {
+			if continuable && result == noBlocksOpened && !o.interrupts {
+				continue
+			}
+			if w > 3 && !o.acceptsIndented {
+				continue
+			}
+			last = p.lastOpened()
+			node, state := o.open(p, parent)
+			if node == nil {
+				continue
+			}
+			if state&stateRequireParagraph != 0 && last == parent.lastChild() {
+				p.closeBlock(last)
+				p.open = p.open[:len(p.open)-1]
+			}
+			node.blankBefore = blankLine
+			parent.appendChild(node)
+			result = newBlocksOpened
+			p.open = append(p.open, node)
+			if state&stateHasChildren != 0 {
+				parent = node
+				goto retry
+			}
+			break
+		}
+	}
+	if result == noBlocksOpened && continuable {
+		if p.continueBlock(last)&stateContinue != 0 {
+			result = paragraphContinuation
+		}
+	}
+	return result
+}
+
+func (p *blockParser) closeBlocks(from, to int) {
+	for i := from; i >= to; i-- {
+		p.closeBlock(p.open[i])
+	}
+	if from == len(p.open)-1 {
+		p.open = p.open[:to]
+	} else {
+		p.open = append(p.open[:to], p.open[from+1:]...)
+	}
+}
+
+func isBlankLine(lineNum, level int, stats []lineStat) bool {
+	ret := true
+	for i := len(stats) - 1 - level; i >= 0; i-- {
+		ret = false
+		s := stats[i]
+		if s.lineNum == lineNum {
+			if s.level < level && s.isBlank {
+				return true
+			} else if s.level == level {
+				return s.isBlank
+			}
+		}
+		if s.lineNum < lineNum {
+			return ret
+		}
+	}
+	return ret
+}
+
+func (p *blockParser) continueBlock(b *block) blockState {
+	switch b.kind {
+	case paragraphBlock:
+		return p.continueParagraph(b)
+	case codeBlock:
+		return p.continueCodeBlock(b)
+	case fencedCodeBlock:
+		return p.continueFencedCode(b)
+	case blockquoteBlock:
+		if p.blockquoteMarker() {
+			return stateContinue | stateHasChildren
+		}
+		return stateClose
+	case listBlock:
+		return p.continueList(b)
+	case listItemBlock:
+		return p.continueListItem(b)
+	case htmlBlock:
+		return p.continueHTMLBlock(b)
+	}
+	return stateClose
+}
+
+func (p *blockParser) closeBlock(b *block) {
+	switch b.kind {
+	case paragraphBlock:
+		if b.parent == nil {
+			return
+		}
+		if n := len(b.lines); n != 0 {
+			b.lines[n-1] = trimRightSpaceSegment(b.lines[n-1], p.r.source)
+		} else {
+			b.parent.removeChild(b)
+		}
+	case headingBlock:
+		if b.setext {
+			p.closeSetextHeading(b)
+		}
+		var line string
+		if n := len(b.lines); n != 0 {
+			line = b.lines[n-1].value(p.r.source)
+		}
+		b.id = p.generateID(line)
+	case codeBlock:
+		n := len(b.lines)
+		for n > 0 && isBlank(b.lines[n-1].value(p.r.source)) {
+			n--
+		}
+		b.lines = b.lines[:n]
+	case fencedCodeBlock:
+		if p.fence != nil && p.fence.node == b {
+			p.fence = nil
+		}
+	case listBlock:
+		p.closeList(b)
+	}
+}
+
+func (p *blockParser) openParagraph(parent *block) (*block, blockState) {
+	_, seg, _ := p.r.peekLine()
+	seg = trimLeftSpaceSegment(seg, p.r.source)
+	if seg.start == seg.stop {
+		return nil, stateNoChildren
+	}
+	node := &block{kind: paragraphBlock, lines: []segment{seg}}
+	p.r.advance(seg.stop - seg.start - 1)
+	return node, stateNoChildren
+}
+
+func (p *blockParser) continueParagraph(b *block) blockState {
+	_, seg, _ := p.r.peekLine()
+	seg = trimLeftSpaceSegment(seg, p.r.source)
+	if seg.start == seg.stop {
+		return stateClose
+	}
+	b.lines = append(b.lines, seg)
+	p.r.advance(seg.stop - seg.start - 1)
+	return stateContinue | stateNoChildren
+}
+
+func (p *blockParser) openThematicBreak(parent *block) (*block, blockState) {
+	line, seg, _ := p.r.peekLine()
+	if isThematicBreak(line, p.r.lineOffset()) {
+		p.r.advance(seg.stop - seg.start + seg.padding - 1)
+		return &block{kind: thematicBreakBlock}, stateNoChildren
+	}
+	return nil, stateNoChildren
+}
+
+func isThematicBreak(line string, offset int) bool {
+	w, pos := indentWidth(line, offset)
+	if w > 3 {
+		return false
+	}
+	mark := byte(0)
+	count := 0
+	for i := pos; i < len(line); i++ {
+		c := line[i]
+		if isSpace(c) {
+			continue
+		}
+		if mark == 0 {
+			mark = c
+			count = 1
+			if mark == '*' || mark == '-' || mark == '_' {
+				continue
+			}
+			return false
+		}
+		if c != mark {
+			return false
+		}
+		count++
+	}
+	return count > 2
+}
+
+func (p *blockParser) openATXHeading(parent *block) (*block, blockState) {
+	line, seg, _ := p.r.peekLine()
+	pos := p.blockOffset
+	if pos < 0 {
+		return nil, stateNoChildren
+	}
+	i := pos
+	for ; i < len(line) && line[i] == '#'; i++ {
+	}
+	level := i - pos
+	if i == pos || level > 6 {
+		return nil, stateNoChildren
+	}
+	node := &block{kind: headingBlock, level: level}
+	if i == len(line) {
+		return node, stateNoChildren
+	}
+	l := trimLeftSpaceLength(line[i:])
+	if l == 0 {
+		return nil, stateNoChildren
+	}
+	start := i + l
+	if start >= len(line) {
+		start = len(line) - 1
+	}
+	stop := len(line) - trimRightSpaceLength(line)
+	if stop <= start {
+		stop = start
+	} else {
+		i = stop - 1
+		for ; line[i] == '#' && i >= start; i-- {
+		}
+		if i != stop-1 && !isSpace(line[i]) {
+			i = stop - 1
+		}
+		i++
+		stop = i
+	}
+	if len(strings.TrimRight(line[start:stop], "#")) != 0 {
+		node.lines = append(node.lines, segment{seg.start + start - seg.padding, seg.start + stop - seg.padding, 0})
+	}
+	return node, stateNoChildren
+}
+
+func (p *blockParser) openSetextHeading(parent *block) (*block, blockState) {
+	last := p.lastOpened()
+	if last == nil || last.kind != paragraphBlock || last.parent != parent {
+		return nil, stateNoChildren
+	}
+	line, seg, _ := p.r.peekLine()
+	c, ok := matchesSetextHeadingBar(line)
+	if !ok {
+		return nil, stateNoChildren
+	}
+	level := 1
+	if c == '-' {
+		level = 2
+	}
+	p.setextParagraph = last
+	node := &block{kind: headingBlock, level: level, setext: true, lines: []segment{seg}}
+	return node, stateNoChildren | stateRequireParagraph
+}
+
+// closeSetextHeading takes over the lines of the paragraph the underline
+// turned into a heading.
+func (p *blockParser) closeSetextHeading(b *block) {
+	tmp := p.setextParagraph
+	p.setextParagraph = nil
+	b.lines = tmp.lines
+	b.blankBefore = tmp.blankBefore
+	if tmp.parent != nil {
+		tmp.parent.removeChild(tmp)
+	}
+}
+
+func matchesSetextHeadingBar(line string) (byte, bool) {
+	start := 0
+	end := len(line)
+	space := len(line) - len(strings.TrimLeft(line, " "))
+	if space > 3 {
+		return 0, false
+	}
+	start += space
+	level1 := len(line[start:end]) - len(strings.TrimLeft(line[start:end], "="))
+	c := byte('=')
+	var level2 int
+	if level1 == 0 {
+		level2 = len(line[start:end]) - len(strings.TrimLeft(line[start:end], "-"))
+		c = '-'
+	}
+	if isSpace(line[end-1]) {
+		end -= trimRightSpaceLength(line[start:end])
+	}
+	if !((level1 > 0 && start+level1 == end) || (level2 > 0 && start+level2 == end)) {
+		return 0, false
+	}
+	return c, true
+}
+
+func (p *blockParser) generateID(value string) string {
+	value = trimSpace(value)
+	var result []byte
+	for i := 0; i < len(value); {
+		v := value[i]
+		l := utf8Len(v)
+		i += l
+		if l != 1 {
+			continue
+		}
+		if isAlphaNumeric(v) {
+			if 'A' <= v && v <= 'Z' {
+				v += 'a' - 'A'
+			}
+			result = append(result, v)
+		} else if isSpace(v) || v == '-' || v == '_' {
+			result = append(result, '-')
+		}
+	}
+	id := string(result)
+	if id == "" {
+		id = "heading"
+	}
+	if !p.ids[id] {
+		p.ids[id] = true
+		return id
+	}
+	for i := 1; ; i++ {
+		next := fmt.Sprintf("%s-%d", id, i)
+		if !p.ids[next] {
+			p.ids[next] = true
+			return next
+		}
+	}
+}
+
+func (p *blockParser) openCodeBlock(parent *block) (*block, blockState) {
+	line, _, _ := p.r.peekLine()
+	pos, padding := indentPosition(line, p.r.lineOffset(), 4)
+	if pos < 0 || isBlank(line) {
+		return nil, stateNoChildren
+	}
+	node := &block{kind: codeBlock}
+	p.appendCodeLine(node, pos, padding)
+	return node, stateNoChildren
+}
+
+func (p *blockParser) continueCodeBlock(b *block) blockState {
+	line, seg, _ := p.r.peekLine()
+	if isBlank(line) {
+		b.lines = append(b.lines, trimLeftSpaceWidth(seg, 4, p.r.source))
+		return stateContinue | stateNoChildren
+	}
+	pos, padding := indentPosition(line, p.r.lineOffset(), 4)
+	if pos < 0 {
+		return stateClose
+	}
+	p.appendCodeLine(b, pos, padding)
+	return stateContinue | stateNoChildren
+}
+
+func (p *blockParser) appendCodeLine(b *block, pos, padding int) {
+	p.r.advanceAndSetPadding(pos, padding)
+	_, seg, _ := p.r.peekLine()
+	if seg.padding != 0 {
+		p.preserveLeadingTab(&seg, 0)
+	}
+	b.lines = append(b.lines, seg)
+	p.r.advance(seg.stop - seg.start + seg.padding - 1)
+}
+
+// preserveLeadingTab keeps a tab at the start of a code line as a tab
+// rather than the spaces it was partly consumed into.
+func (p *blockParser) preserveLeadingTab(seg *segment, indent int) {
+	offsetWithPadding := p.r.lineOffset() + indent
+	pos, padding := p.r.pos, p.r.padding
+	p.r.pos, p.r.padding = seg.start-1, 0
+	if offsetWithPadding == p.r.lineOffset() {
+		seg.padding = 0
+		seg.start--
+	}
+	p.r.pos, p.r.padding = pos, padding
+}
+
+func (p *blockParser) openFencedCode(parent *block) (*block, blockState) {
+	line, seg, _ := p.r.peekLine()
+	pos := p.blockOffset
+	if pos < 0 || (line[pos] != '`' && line[pos] != '~') {
+		return nil, stateNoChildren
+	}
+	fenceChar := line[pos]
+	i := pos
+	for ; i < len(line) && line[i] == fenceChar; i++ {
+	}
+	length := i - pos
+	if length < 3 {
+		return nil, stateNoChildren
+	}
+	node := &block{kind: fencedCodeBlock}
+	if i < len(line)-1 {
+		rest := line[i:]
+		left := trimLeftSpaceLength(rest)
+		right := trimRightSpaceLength(rest)
+		if left < len(rest)-right {
+			start, stop := seg.start-seg.padding+i+left, seg.stop-right
+			value := rest[left : len(rest)-right]
+			if fenceChar == '`' && strings.IndexByte(value, '`') > -1 {
+				return nil, stateNoChildren
+			} else if start != stop {
+				node.info = p.r.source[start:stop]
+				node.hasInfo = true
+			}
+		}
+	}
+	p.fence = &fenceData{fenceChar, pos, length, node}
+	return node, stateNoChildren
+}
+
+func (p *blockParser) continueFencedCode(b *block) blockState {
+	line, seg, _ := p.r.peekLine()
+	fence := p.fence
+	w, pos := indentWidth(line, p.r.lineOffset())
+	if w < 4 {
+		i := pos
+		for ; i < len(line) && line[i] == fence.char; i++ {
+		}
+		if i-pos >= fence.length && isBlank(line[i:]) {
+			newline := 1
+			if line[len(line)-1] != '\n' {
+				newline = 0
+			}
+			p.r.advance(seg.stop - seg.start - newline - seg.padding)
+			return stateClose
+		}
+	}
+	pos, padding := indentPositionPadding(line, p.r.lineOffset(), seg.padding, fence.indent)
+	if pos < 0 {
+		pos = firstNonSpacePosition(line)
+		if pos < 0 {
+			pos = 0
+		}
+		padding = 0
+	}
+	code := segment{seg.start + pos, seg.stop, padding}
+	if padding != 0 {
+		p.preserveLeadingTab(&code, fence.indent)
+	}
+	b.lines = append(b.lines, code)
+	p.r.advanceAndSetPadding(seg.stop-seg.start-pos-1, padding)
+	return stateContinue | stateNoChildren
+}
+
+func (p *blockParser) openBlockquote(parent *block) (*block, blockState) {
+	if p.blockquoteMarker() {
+		return &block{kind: blockquoteBlock}, stateHasChildren
+	}
+	return nil, stateNoChildren
+}
+
+// blockquoteMarker consumes a leading '>' and the space after it.
+func (p *blockParser) blockquoteMarker() bool {
+	line, _, ok := p.r.peekLine()
+	if !ok {
+		return false
+	}
+	w, pos := indentWidth(line, p.r.lineOffset())
+	if w > 3 || pos >= len(line) || line[pos] != '>' {
+		return false
+	}
+	pos++
+	if pos >= len(line) || line[pos] == '\n' {
+		p.r.advance(pos)
+		return true
+	}
+	if line[pos] == ' ' || line[pos] == '\t' {
+		pos++
+	}
+	p.r.advance(pos)
+	if line[pos-1] == '\t' {
+		p.r.padding = 2
+	}
+	return true
+}
+
+type listItemType int
+
+const (
+	notList listItemType = iota
+	bulletList
+	orderedList
+)
+
+func (p *blockParser) openList(parent *block) (*block, blockState) {
+	last := p.lastOpened()
+	if (last != nil && last.kind == listBlock) || p.skipList {
+		p.skipList = false
+		return nil, stateNoChildren
+	}
+	line, _, _ := p.r.peekLine()
+	match, typ := matchesListItem(line, true)
+	if typ == notList {
+		return nil, stateNoChildren
+	}
+	start := -1
+	if typ == orderedList {
+		start, _ = strconv.Atoi(line[match[2] : match[3]-1])
+	}
+	if last != nil && last.kind == paragraphBlock && last.parent == parent {
+		if typ == orderedList && start != 1 {
+			return nil, stateNoChildren
+		}
+		if match[4] < 0 || isBlank(line[match[4]:match[5]]) {
+			return nil, stateNoChildren
+		}
+	}
+	node := &block{kind: listBlock, marker: line[match[3]-1], tight: true}
+	if start > -1 {
+		node.start = start
+	}
+	p.emptyItemWithBlankLines = false
+	return node, stateHasChildren
+}
+
+func (p *blockParser) continueList(b *block) blockState {
+	line, _, _ := p.r.peekLine()
+	if isBlank(line) {
+		if len(b.lastChild().children) == 0 {
+			p.emptyItemWithBlankLines = true
+		}
+		return stateContinue | stateHasChildren
+	}
+	offset := lastOffset(b)
+	lastIsEmpty := len(b.lastChild().children) == 0
+	indent, _ := indentWidth(line, p.r.lineOffset())
+	if indent < offset || lastIsEmpty {
+		if indent < 4 {
+			match, typ := matchesListItem(line, false)
+			if typ != notList && match[1]-offset < 4 {
+				marker := line[match[3]-1]
+				if marker != b.marker || (typ == orderedList) != b.ordered() {
+					return stateClose
+				}
+				if isThematicBreak(line[match[3]-1:], 0) {
+					isHeading := false
+					if last := p.lastOpened(); last != nil && last.kind == paragraphBlock {
+						c, ok := matchesSetextHeadingBar(line[match[3]-1:])
+						isHeading = ok && c == '-'
+					}
+					if !isHeading {
+						return stateClose
+					}
+				}
+				return stateContinue | stateHasChildren
+			}
+		}
+		if !lastIsEmpty {
+			return stateClose
+		}
+	}
+	if lastIsEmpty && indent < offset {
+		return stateClose
+	}
+	if p.emptyItemWithBlankLines {
+		return stateClose
+	}
+	return stateContinue | stateHasChildren
+}
+
+// closeList decides whether the list is tight: no blank line between its
+// items or between the blocks inside an item.
+func (p *blockParser) closeList(b *block) {
+	for i, item := range b.children {
+		if !b.tight {
+			break
+		}
+		if len(item.children) > 1 {
+			for _, c := range item.children[1:] {
+				if c.blankBefore {
+					b.tight = false
+					break
+				}
+			}
+		}
+		if i != 0 && item.blankBefore {
+			b.tight = false
+		}
+	}
+	if b.tight {
+		for _, item := range b.children {
+			for _, c := range item.children {
+				if c.kind == paragraphBlock {
+					c.textBlock = true
+				}
+			}
+		}
+	}
+}
+
+func (p *blockParser) openListItem(parent *block) (*block, blockState) {
+	if parent.kind != listBlock {
+		return nil, stateNoChildren
+	}
+	offset := lastOffset(parent)
+	line, _, _ := p.r.peekLine()
+	match, typ := matchesListItem(line, false)
+	if typ == notList {
+		return nil, stateNoChildren
+	}
+	if match[1]-offset > 3 {
+		return nil, stateNoChildren
+	}
+	p.emptyItemWithBlankLines = false
+	itemOffset := calcListOffset(line, match)
+	node := &block{kind: listItemBlock, offset: match[3] + itemOffset}
+	if match[4] < 0 || isBlank(line[match[4]:match[5]]) {
+		return node, stateNoChildren
+	}
+	pos, padding := indentPosition(line[match[4]:], match[4], itemOffset)
+	p.r.advanceAndSetPadding(match[3]+pos, padding)
+	return node, stateHasChildren
+}
+
+func (p *blockParser) continueListItem(b *block) blockState {
+	line, _, _ := p.r.peekLine()
+	if isBlank(line) {
+		p.r.advance(len(line) - 1)
+		return stateContinue | stateHasChildren
+	}
+	offset := lastOffset(b.parent)
+	isEmpty := len(b.children) == 0
+	indent, _ := indentWidth(line, p.r.lineOffset())
+	if (isEmpty || indent < offset) && indent < 4 {
+		if _, typ := matchesListItem(line, true); typ != notList {
+			p.skipList = true
+			return stateClose
+		}
+		if !isEmpty {
+			return stateClose
+		}
+	}
+	pos, padding := indentPosition(line, p.r.lineOffset(), offset)
+	p.r.advanceAndSetPadding(pos, padding)
+	return stateContinue | stateHasChildren
+}
+
+func lastOffset(list *block) int {
+	if last := list.lastChild(); last != nil {
+		return last.offset
+	}
+	return 0
+}
+
+// parseListItem finds the marker of a list item. The result holds the
+// start of the line, the marker start, the number start, the marker end
+// and the content start and end, with -1 for content on an empty item.
+func parseListItem(line string) ([6]int, listItemType) {
+	i := 0
+	l := len(line)
+	ret := [6]int{}
+	for ; i < l && line[i] == ' '; i++ {
+	}
+	if i > 3 {
+		return ret, notList
+	}
+	ret[1] = i
+	ret[2] = i
+	var typ listItemType
+	if i < l && (line[i] == '-' || line[i] == '*' || line[i] == '+') {
+		i++
+		ret[3] = i
+		typ = bulletList
+	} else if i < l {
+		for ; i < l && isNumeric(line[i]); i++ {
+		}
+		ret[3] = i
+		if ret[3] == ret[2] || ret[3]-ret[2] > 9 {
+			return ret, notList
+		}
+		if i < l && (line[i] == '.' || line[i] == ')') {
+			i++
+			ret[3] = i
+		} else {
+			return ret, notList
+		}
+		typ = orderedList
+	} else {
+		return ret, notList
+	}
+	if i < l && line[i] != '\n' {
+		if w, _ := indentWidth(line[i:], 0); w == 0 {
+			return ret, notList
+		}
+	}
+	if i >= l {
+		ret[4] = -1
+		ret[5] = -1
+		return ret, typ
+	}
+	ret[4] = i
+	ret[5] = len(line)
+	if line[ret[5]-1] == '\n' && line[i] != '\n' {
+		ret[5]--
+	}
+	return ret, typ
+}
+
+func matchesListItem(line string, strict bool) ([6]int, listItemType) {
+	m, typ := parseListItem(line)
+	if typ != notList && (!strict || m[1] < 4) {
+		return m, typ
+	}
+	return m, notList
+}
+
+func calcListOffset(line string, match [6]int) int {
+	if match[4] < 0 || isBlank(line[match[4]:]) {
+		return 1
+	}
+	offset, _ := indentWidth(line[match[4]:], match[4])
+	if offset > 4 {
+		return 1
+	}
+	return offset
+}
+
+var allowedBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true,
+	"basefont": true, "blockquote": true, "body": true, "caption": true,
+	"center": true, "col": true, "colgroup": true, "dd": true,
+	"details": true, "dialog": true, "dir": true, "div": true,
+	"dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "frame": true,
+	"frameset": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "head": true,
+	"header": true, "hr": true, "html": true, "iframe": true,
+	"legend": true, "li": true, "link": true, "main": true,
+	"menu": true, "menuitem": true, "meta": true, "nav": true,
+	"noframes": true, "ol": true, "optgroup": true, "option": true,
+	"p": true, "param": true, "section": true, "source": true,
+	"summary": true, "table": true, "tbody": true, "td": true,
+	"tfoot": true, "th": true, "thead": true, "title": true,
+	"tr": true, "track": true, "ul": true,
+}
+
+var (
+	htmlBlockType1Open  = regexp.MustCompile(`(?i)^[ ]{0,3}<(script|pre|style|textarea)(?:\s.*|>.*|/>.*|)(?:\r\n|\n)?$`)
+	htmlBlockType1Close = regexp.MustCompile(`(?i)^.*</(?:script|pre|style|textarea)>.*`)
+	htmlBlockType2Open  = regexp.MustCompile(`^[ ]{0,3}<!\-\-`)
+	htmlBlockType3Open  = regexp.MustCompile(`^[ ]{0,3}<\?`)
+	htmlBlockType4Open  = regexp.MustCompile(`^[ ]{0,3}<![A-Z]+.*(?:\r\n|\n)?$`)
+	htmlBlockType5Open  = regexp.MustCompile(`^[ ]{0,3}<\!\[CDATA\[`)
+	htmlBlockType6      = regexp.MustCompile(`^[ ]{0,3}<(?:/[ ]*)?([a-zA-Z]+[a-zA-Z0-9\-]*)(?:[ ].*|>.*|/>.*|)(?:\r\n|\n)?$`)
+	htmlBlockType7      = regexp.MustCompile(`^[ ]{0,3}<(/[ ]*)?([a-zA-Z]+[a-zA-Z0-9\-]*)(` + attributePattern + `*)[ ]*(?:>|/>)[ ]*(?:\r\n|\n)?$`)
+)
+
+// htmlBlockClosers holds the text that ends html blocks of types 2 to 5.
+var htmlBlockClosers = map[int]string{2: "-->", 3: "?>", 4: ">", 5: "]]>"}
+
+func (p *blockParser) openHTMLBlock(parent *block) (*block, blockState) {
+	line, seg, _ := p.r.peekLine()
+	last := p.lastOpened()
+	if pos := p.blockOffset; pos < 0 || line[pos] != '<' {
+		return nil, stateNoChildren
+	}
+	typ := 0
+	if htmlBlockType1Open.MatchString(line) {
+		typ = 1
+	} else if htmlBlockType2Open.MatchString(line) {
+		typ = 2
+	} else if htmlBlockType3Open.MatchString(line) {
+		typ = 3
+	} else if htmlBlockType4Open.MatchString(line) {
+		typ = 4
+	} else if htmlBlockType5Open.MatchString(line) {
+		typ = 5
+	} else if match := htmlBlockType7.FindStringSubmatchIndex(line); match != nil {
+		isCloseTag := match[2] > -1 && line[match[2]:match[3]] == "/"
+		hasAttr := match[6] != match[7]
+		tagName := strings.ToLower(line[match[4]:match[5]])
+		if allowedBlockTags[tagName] {
+			typ = 6
+		} else if tagName != "script" && tagName != "style" && tagName != "pre" &&
+			!(last != nil && last.kind == paragraphBlock) && !(isCloseTag && hasAttr) {
+			typ = 7
+		}
+	}
+	if typ == 0 {
+		if match := htmlBlockType6.FindStringSubmatchIndex(line); match != nil {
+			if allowedBlockTags[strings.ToLower(line[match[2]:match[3]])] {
+				typ = 6
+			}
+		}
+	}
+	if typ == 0 {
+		return nil, stateNoChildren
+	}
+	p.r.advance(seg.stop - seg.start + seg.padding - 1)
+	return &block{kind: htmlBlock, htmlType: typ, lines: []segment{seg}}, stateNoChildren
+}
+
+func (p *blockParser) continueHTMLBlock(b *block) blockState {
+	line, seg, _ := p.r.peekLine()
+	switch b.htmlType {
+	case 1:
+		if len(b.lines) == 1 && htmlBlockType1Close.MatchString(b.lines[0].value(p.r.source)) {
+			return stateClose
+		}
+		if htmlBlockType1Close.MatchString(line) {
+			b.closure = true
+			p.r.advance(seg.stop - seg.start + seg.padding - 1)
+			return stateClose
+		}
+	case 2, 3, 4, 5:
+		closer := htmlBlockClosers[b.htmlType]
+		if len(b.lines) == 1 && strings.Contains(b.lines[0].value(p.r.source), closer) {
+			return stateClose
+		}
+		if strings.Contains(line, closer) {
+			b.closure = true
+			p.r.advance(seg.stop - seg.start + seg.padding)
+			return stateClose
+		}
+	case 6, 7:
+		if isBlank(line) {
+			return stateClose
+		}
+	}
+	b.lines = append(b.lines, seg)
+	p.r.advance(seg.stop - seg.start + seg.padding - 1)
+	return stateContinue | stateNoChildren
+}
+
+func tabWidth(currentPos int) int {
+	return 4 - currentPos%4
+}
+
+// indentWidth returns the width of the leading whitespace of line, which
+// starts at column currentPos, and the number of bytes it takes up.
+func indentWidth(line string, currentPos int) (width, pos int) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			width++
+			pos++
+		case '\t':
+			width += tabWidth(currentPos + width)
+			pos++
+		default:
+			return
+		}
+	}
+	return
+}
+
+func indentPosition(line string, currentPos, width int) (pos, padding int) {
+	return indentPositionPadding(line, currentPos, 0, width)
+}
+
+// indentPositionPadding returns the byte position reached after width
+// columns of indentation, and how many columns of the last tab were left
+// over, or -1, -1 if the line isn't indented that far.
+func indentPositionPadding(line string, currentPos, paddingv, width int) (pos, padding int) {
+	if width == 0 {
+		return 0, paddingv
+	}
+	w := 0
+	i := 0
+	for ; i < len(line); i++ {
+		if line[i] == '\t' && w < width {
+			w += tabWidth(currentPos + w)
+		} else if line[i] == ' ' && w < width {
+			w++
+		} else {
+			break
+		}
+	}
+	if w >= width {
+		return i - paddingv, w - width
+	}
+	return -1, -1
+}
+
+func firstNonSpacePosition(line string) int {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == ' ' || c == '\t' {
+			continue
+		}
+		if c == '\n' {
+			return -1
+		}
+		return i
+	}
+	return -1
+}
+
+func trimLeftSpaceSegment(s segment, source string) segment {
+	v := source[s.start:s.stop]
+	return segment{s.start + trimLeftSpaceLength(v), s.stop, 0}
+}
+
+func trimRightSpaceSegment(s segment, source string) segment {
+	v := source[s.start:s.stop]
+	l := trimRightSpaceLength(v)
+	if l == len(v) {
+		return segment{s.start, s.start, 0}
+	}
+	return segment{s.start, s.stop - l, s.padding}
+}
+
+// trimLeftSpaceWidth drops up to width columns of leading whitespace.
+func trimLeftSpaceWidth(s segment, width int, source string) segment {
+	padding := s.padding
+	for ; width > 0 && padding != 0; width-- {
+		padding--
+	}
+	if width == 0 {
+		return segment{s.start, s.stop, padding}
+	}
+	start := s.start
+	for _, c := range []byte(source[s.start:s.stop]) {
+		if start >= s.stop-1 || width <= 0 {
+			break
+		}
+		if c == ' ' {
+			width--
+		} else if c == '\t' {
+			width -= 4
+		} else {
+			break
+		}
+		start++
+	}
+	if width < 0 {
+		padding = -width
+	}
+	return segment{start, s.stop, padding}
+}
@@ -0,0 +1,52 @@
+package markdown
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkparser "github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// reference is goldmark configured as notes/render.go configures it, which
+// this package is expected to match.
+var reference = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(
+		goldmarkparser.WithAutoHeadingID(),
+	),
+	goldmark.WithRendererOptions(
+		html.WithHardWraps(),
+		html.WithXHTML(),
+	),
+)
+
+// Each .md file in testdata is rendered by both, and must come out the same.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.md"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".md"), func(t *testing.T) {
+			text, err := os.ReadFile(file)
+			assert.NoError(t, err)
+
+			want := bytes.Buffer{}
+			err = reference.Convert(text, &want)
+			assert.NoError(t, err)
+
+			buf := bytes.Buffer{}
+			err = Convert(text, &buf)
+
+			assert.NoError(t, err)
+			assert.Equal(t, want.String(), buf.String())
+		})
+	}
+}
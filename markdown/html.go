@@ -0,0 +1,523 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type renderer struct {
+	source string
+	out    *strings.Builder
+}
+
+func (r *renderer) renderBlocks(b *block) {
+	for _, c := range b.children {
+		r.renderBlock(c)
+	}
+}
+
+func (r *renderer) renderBlock(b *block) {
+	out := r.out
+	switch b.kind {
+	case paragraphBlock:
+		if b.textBlock {
+			r.renderInline(b)
+			if b != b.parent.lastChild() && b.inlines.first != nil {
+				out.WriteByte('\n')
+			}
+			return
+		}
+		out.WriteString("<p>")
+		r.renderInline(b)
+		out.WriteString("</p>\n")
+	case headingBlock:
+		fmt.Fprintf(out, "<h%d id=\"%s\">", b.level, escapeHTML(b.id))
+		r.renderInline(b)
+		fmt.Fprintf(out, "</h%d>\n", b.level)
+	case thematicBreakBlock:
+		out.WriteString("<hr />\n")
+	case codeBlock, fencedCodeBlock:
+		out.WriteString("<pre><code")
+		if b.hasInfo {
+			language := b.info
+			if i := strings.IndexByte(language, ' '); i >= 0 {
+				language = language[:i]
+			}
+			out.WriteString(` class="language-`)
+			writeText(out, language)
+			out.WriteByte('"')
+		}
+		out.WriteByte('>')
+		for _, line := range b.lines {
+			writeRaw(out, line.value(r.source))
+		}
+		out.WriteString("</code></pre>\n")
+	case blockquoteBlock:
+		out.WriteString("<blockquote>\n")
+		r.renderBlocks(b)
+		out.WriteString("</blockquote>\n")
+	case listBlock:
+		tag := "ul"
+		if b.ordered() {
+			tag = "ol"
+		}
+		out.WriteString("<" + tag)
+		if b.ordered() && b.start != 1 {
+			fmt.Fprintf(out, " start=\"%d\"", b.start)
+		}
+		out.WriteString(">\n")
+		r.renderBlocks(b)
+		out.WriteString("</" + tag + ">\n")
+	case listItemBlock:
+		out.WriteString("<li>")
+		if len(b.children) != 0 && !b.children[0].textBlock {
+			out.WriteByte('\n')
+		}
+		r.renderBlocks(b)
+		out.WriteString("</li>\n")
+	case htmlBlock:
+		out.WriteString("<!-- raw HTML omitted -->\n")
+		if b.closure {
+			out.WriteString("<!-- raw HTML omitted -->\n")
+		}
+	}
+}
+
+func (r *renderer) renderInline(b *block) {
+	for n := b.inlines.first; n != nil; n = n.next {
+		r.renderNode(n)
+	}
+}
+
+func (r *renderer) renderChildren(n *node) {
+	for c := n.first; c != nil; c = c.next {
+		r.renderNode(c)
+	}
+}
+
+func (r *renderer) renderNode(n *node) {
+	out := r.out
+	switch n.kind {
+	case textNode:
+		writeText(out, n.value)
+	case breakNode:
+		out.WriteString("<br />\n")
+	case codeNode:
+		out.WriteString("<code>")
+		writeRaw(out, strings.ReplaceAll(n.value, "\n", " "))
+		out.WriteString("</code>")
+	case emphasisNode:
+		tag := "em"
+		if n.level == 2 {
+			tag = "strong"
+		}
+		out.WriteString("<" + tag + ">")
+		r.renderChildren(n)
+		out.WriteString("</" + tag + ">")
+	case strikethroughNode:
+		out.WriteString("<del>")
+		r.renderChildren(n)
+		out.WriteString("</del>")
+	case linkNode:
+		out.WriteString(`<a href="`)
+		if !isDangerousURL(n.dest) {
+			out.WriteString(escapeHTML(urlEscape(n.dest, true)))
+		}
+		out.WriteByte('"')
+		r.renderTitle(n)
+		out.WriteByte('>')
+		r.renderChildren(n)
+		out.WriteString("</a>")
+	case imageNode:
+		out.WriteString(`<img src="`)
+		if !isDangerousURL(n.dest) {
+			out.WriteString(escapeHTML(urlEscape(n.dest, true)))
+		}
+		out.WriteString(`" alt="`)
+		out.WriteString(escapeHTML(n.text()))
+		out.WriteByte('"')
+		r.renderTitle(n)
+		out.WriteString(" />")
+	case autoLinkNode:
+		out.WriteString(`<a href="`)
+		if n.email && !strings.HasPrefix(strings.ToLower(n.dest), "mailto:") {
+			out.WriteString("mailto:")
+		}
+		out.WriteString(escapeHTML(urlEscape(n.dest, false)))
+		out.WriteString(`">`)
+		out.WriteString(escapeHTML(n.value))
+		out.WriteString("</a>")
+	case htmlNode:
+		out.WriteString("<!-- raw HTML omitted -->")
+	case taskBoxNode:
+		if n.checked {
+			out.WriteString(`<input checked="" disabled="" type="checkbox" /> `)
+		} else {
+			out.WriteString(`<input disabled="" type="checkbox" /> `)
+		}
+	}
+}
+
+func (r *renderer) renderTitle(n *node) {
+	if n.hasTitle {
+		r.out.WriteString(` title="`)
+		writeText(r.out, n.title)
+		r.out.WriteByte('"')
+	}
+}
+
+// writeText writes inline text, dropping the backslash from escaped
+// punctuation and decoding entity and numeric character references.
+func writeText(out *strings.Builder, s string) {
+	escaped := false
+	n := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if escaped && isPunct(c) {
+			writeRaw(out, s[n:i-1])
+			n = i
+			escaped = false
+			continue
+		}
+		if c == 0 {
+			writeRaw(out, s[n:i])
+			out.WriteRune(utf8.RuneError)
+			n = i + 1
+			escaped = false
+			continue
+		}
+		if c == '&' {
+			pos := i
+			if r, end, ok := numericReference(s, i, 7, 10); ok {
+				writeRaw(out, s[n:pos])
+				writeRune(out, r)
+				i = end
+				n = i + 1
+				continue
+			}
+			if v, end, ok := entityReference(s, i); ok {
+				writeRaw(out, s[n:pos])
+				writeRaw(out, v)
+				i = end
+				n = i + 1
+				continue
+			}
+		}
+		if c == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+	}
+	writeRaw(out, s[n:])
+}
+
+// numericReference reads a reference like &#x22; or &#34; at s[i], returning
+// the rune and the position of its ';'. Hex references longer than maxHex
+// digits are rejected, decimal ones are parsed in the given base.
+func numericReference(s string, i, maxHex, base int) (rune, int, bool) {
+	if i+2 >= len(s) || s[i+1] != '#' {
+		return 0, 0, false
+	}
+	if c := s[i+2]; c == 'x' || c == 'X' {
+		start := i + 3
+		end := start
+		for end < len(s) && isHex(s[end]) {
+			end++
+		}
+		if end > start && end < len(s) && s[end] == ';' && (maxHex < 0 || end-start < maxHex) {
+			v, _ := strconv.ParseUint(s[start:end], 16, 32)
+			return rune(v), end, true
+		}
+	} else if isNumeric(c) {
+		start := i + 2
+		end := start
+		for end < len(s) && isNumeric(s[end]) {
+			end++
+		}
+		if end < len(s) && end-start < 8 && s[end] == ';' {
+			v, _ := strconv.ParseUint(s[start:end], base, 32)
+			return rune(v), end, true
+		}
+	}
+	return 0, 0, false
+}
+
+// entityReference reads a named reference like &amp; at s[i].
+func entityReference(s string, i int) (string, int, bool) {
+	if i+1 < len(s) && s[i+1] == '#' {
+		return "", 0, false
+	}
+	start := i + 1
+	end := start
+	for end < len(s) && isAlphaNumeric(s[end]) {
+		end++
+	}
+	if end == start || end >= len(s) || s[end] != ';' {
+		return "", 0, false
+	}
+	ref := s[i : end+1]
+	v := html.UnescapeString(ref)
+	if v == ref || utf8.RuneCountInString(v) > 2 {
+		return "", 0, false
+	}
+	return v, end, true
+}
+
+func writeRune(out *strings.Builder, r rune) {
+	if r < 256 {
+		if v := htmlEscape(byte(r)); v != "" {
+			out.WriteString(v)
+			return
+		}
+	}
+	out.WriteRune(validRune(r))
+}
+
+func validRune(r rune) rune {
+	if r == 0 || !utf8.ValidRune(r) {
+		return utf8.RuneError
+	}
+	return r
+}
+
+// writeRaw writes s with only the HTML special characters escaped.
+func writeRaw(out *strings.Builder, s string) {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if v := htmlEscape(s[i]); v != "" {
+			out.WriteString(s[n:i])
+			out.WriteString(v)
+			n = i + 1
+		}
+	}
+	out.WriteString(s[n:])
+}
+
+func escapeHTML(s string) string {
+	var out strings.Builder
+	writeRaw(&out, s)
+	return out.String()
+}
+
+func htmlEscape(c byte) string {
+	switch c {
+	case '"':
+		return "&quot;"
+	case '&':
+		return "&amp;"
+	case '<':
+		return "&lt;"
+	case '>':
+		return "&gt;"
+	}
+	return ""
+}
+
+const urlSafe = "!#$&'()*+,-./0123456789:;=?@ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz~"
+
+// urlEscape percent-encodes a link destination, leaving existing %xx
+// escapes alone. With resolve set, backslash escapes and character
+// references are decoded first.
+func urlEscape(v string, resolve bool) string {
+	if resolve {
+		v = unescapePunctuation(v)
+		v = resolveNumericReferences(v)
+		v = resolveEntityNames(v)
+	}
+	var out strings.Builder
+	n := 0
+	for i := 0; i < len(v); {
+		c := v[i]
+		if strings.IndexByte(urlSafe, c) >= 0 {
+			i++
+			continue
+		}
+		if c == '%' && i+2 < len(v) && isHex(v[i+1]) {
+			i += 3
+			continue
+		}
+		l := utf8Len(c)
+		if l == 99 {
+			i++
+			continue
+		}
+		if c == ' ' {
+			out.WriteString(v[n:i])
+			out.WriteString("%20")
+			i++
+			n = i
+			continue
+		}
+		if l > len(v) {
+			l = len(v) - 1
+		}
+		if l == 0 {
+			i++
+			n = i
+			continue
+		}
+		out.WriteString(v[n:i])
+		if i+l > len(v) {
+			i++
+			n = i
+			continue
+		}
+		out.WriteString(url.QueryEscape(v[i : i+l]))
+		i += l
+		n = i
+	}
+	out.WriteString(v[n:])
+	return out.String()
+}
+
+func unescapePunctuation(s string) string {
+	var out strings.Builder
+	n := 0
+	for i := 0; i < len(s); {
+		if i < len(s)-1 && s[i] == '\\' && isPunct(s[i+1]) {
+			out.WriteString(s[n:i])
+			out.WriteByte(s[i+1])
+			i += 2
+			n = i
+			continue
+		}
+		i++
+	}
+	out.WriteString(s[n:])
+	return out.String()
+}
+
+func resolveNumericReferences(s string) string {
+	var out strings.Builder
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '&' {
+			continue
+		}
+		if r, end, ok := numericReference(s, i, -1, 0); ok {
+			out.WriteString(s[n:i])
+			out.WriteRune(validRune(r))
+			i = end
+			n = i + 1
+		}
+	}
+	out.WriteString(s[n:])
+	return out.String()
+}
+
+func resolveEntityNames(s string) string {
+	var out strings.Builder
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '&' {
+			continue
+		}
+		if v, end, ok := entityReference(s, i); ok {
+			out.WriteString(s[n:i])
+			out.WriteString(v)
+			i = end
+			n = i + 1
+		}
+	}
+	out.WriteString(s[n:])
+	return out.String()
+}
+
+// isDangerousURL reports whether a link would run script or read local
+// files if followed. Inline images in common formats are allowed.
+func isDangerousURL(v string) bool {
+	if strings.HasPrefix(v, "data:image/") {
+		for _, ext := range []string{"png;", "gif;", "jpeg;", "webp;", "svg;"} {
+			if strings.HasPrefix(v[11:], ext) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, scheme := range []string{"javascript:", "vbscript:", "file:", "data:"} {
+		if strings.HasPrefix(v, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isPunct(c byte) bool {
+	return c >= '!' && c <= '/' || c >= ':' && c <= '@' || c >= '[' && c <= '`' || c >= '{' && c <= '~'
+}
+
+func isSpaceRune(r rune) bool {
+	return r <= 256 && isSpace(byte(r)) || unicode.IsSpace(r)
+}
+
+func isPunctRune(r rune) bool {
+	return r <= 256 && isPunct(byte(r)) || unicode.IsPunct(r)
+}
+
+func isNumeric(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func isAlphaNumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// utf8Len returns the length of the UTF-8 sequence c starts, or 99 if c
+// can't start one.
+func utf8Len(c byte) int {
+	switch {
+	case c < 0x80:
+		return 1
+	case c < 0xc0:
+		return 99
+	case c < 0xe0:
+		return 2
+	case c < 0xf0:
+		return 3
+	case c < 0xf8:
+		return 4
+	}
+	return 99
+}
+
+func isBlank(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isSpace(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func trimLeftSpaceLength(s string) int {
+	i := 0
+	for ; i < len(s) && isSpace(s[i]); i++ {
+	}
+	return i
+}
+
+func trimRightSpaceLength(s string) int {
+	i := len(s) - 1
+	for ; i >= 0 && isSpace(s[i]); i-- {
+	}
+	return len(s) - 1 - i
+}
+
+func trimSpace(s string) string {
+	s = s[trimLeftSpaceLength(s):]
+	return s[:len(s)-trimRightSpaceLength(s)]
+}
@@ -2,21 +2,39 @@ package markdown
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"unicode/utf8"
 )
 
+// lexer scans the text of a paragraph or heading into inline items. The
+// block's lines are joined, newlines included, into a single input.
 type lexer struct {
-	name  string    // used for error reports
-	input string    // string being scanned
-	start int       // start position of this item
-	pos   int       // current position in the input
-	width int       // width of the last rune read from input
-	items chan item // channel of the scanned items
+	name      string    // used for error reports
+	input     string    // string being scanned
+	start     int       // start position of the pending text
+	pos       int       // current position in the input
+	head      int       // position scanning last restarted from
+	stop      int       // end of the text to scan on this line
+	lineEnd   int       // position just past the current line
+	breakType itemType  // break ending the current line, or itemEOF
+	visible   bool      // break is a backslash, so trailing space is kept
+	escaped   bool      // previous character was a backslash
+	openers   []opening // unclosed [ and ![
+	items     chan item // channel of the scanned items
+	linked    chan bool // parser's answer to each itemLinkClose
+
+	source   string    // whole document, for characters before each line
+	lines    []segment // lines of the block in the source
+	offsets  []int     // position of each line in the input
+	taskList bool      // block is the first in a list item
 }
 
-const eof = rune(0)
-const ws = ' '
+// opening is a link or image label that hasn't been closed yet.
+type opening struct {
+	pos, stop int
+}
 
 type stateFn func(*lexer) stateFn
 
@@ -25,28 +43,52 @@ type itemType int
 const (
 	itemError itemType = iota
 	itemEOF
-	itemHeader
 	itemText
 	itemNewLine
-	itemList
-	itemBold
+	itemHardBreak
+	itemCode
+	itemDelimiter
+	itemLinkOpen
+	itemImageOpen
+	itemLinkClose
+	itemLinkText
+	itemAutoLink
+	itemHTML
+	itemTaskBox
 )
 
-const header = "#"
-const eol = "\n"
-const list = "-"
-const bold = "*"
-
 type item struct {
-	typ itemType
-	val string
+	typ  itemType
+	val  string
+	pos  int  // position of the item in the input
+	join bool // text may run on from the text before it
+
+	dest     string // link destination or autolink URL
+	title    string // link title
+	hasTitle bool
+	canOpen  bool // delimiter can start emphasis
+	canClose bool // delimiter can end emphasis
+	email    bool // autolink is an email address
+	checked  bool // task box is ticked
 }
 
-func lex(name, input string) (*lexer, chan item) {
+func lex(name, source string, lines []segment, taskList bool) (*lexer, chan item) {
+	var input strings.Builder
+	offsets := make([]int, len(lines))
+	for i, line := range lines {
+		offsets[i] = input.Len()
+		input.WriteString(line.value(source))
+	}
+
 	l := &lexer{
-		name:  name,
-		input: input,
-		items: make(chan item),
+		name:     name,
+		input:    input.String(),
+		items:    make(chan item),
+		linked:   make(chan bool),
+		source:   source,
+		lines:    lines,
+		offsets:  offsets,
+		taskList: taskList,
 	}
 
 	go l.run()
@@ -55,7 +97,7 @@ func lex(name, input string) (*lexer, chan item) {
 }
 
 func (l *lexer) run() {
-	for state := lexText; state != nil; {
+	for state := lexLine; state != nil; {
 		state = state(l)
 	}
 
@@ -63,136 +105,674 @@ func (l *lexer) run() {
 }
 
 func (l *lexer) emit(t itemType) {
-	l.items <- item{t, l.input[l.start:l.pos]}
+	l.items <- item{typ: t, val: l.input[l.start:l.pos], pos: l.start}
 	l.start = l.pos
 }
 
-func lexNewLine(l *lexer) stateFn {
-	if l.accept(eol) {
-		l.emit(itemNewLine)
+// emitText sends any text scanned since the last item. Only text cut
+// short by an inline item may later run on from text before it.
+func (l *lexer) emitText(join bool) {
+	if l.pos > l.start {
+		l.items <- item{typ: itemText, val: l.input[l.start:l.pos], pos: l.start, join: join}
+		l.start = l.pos
 	}
-	return lexText
 }
 
-func lexText(l *lexer) stateFn {
-	for {
-		if strings.HasPrefix(l.input[l.pos:], list) {
-			if l.pos > l.start {
-				l.emit(itemText)
-			}
-			return lexList
+// send sends an item at the current position and skips past the input
+// it covers.
+func (l *lexer) send(i item, pos int) {
+	i.pos = l.pos
+	l.items <- i
+	l.pos = pos
+	l.start = pos
+}
+
+// line returns the rest of the current line, including its newline.
+func (l *lexer) line() string {
+	return l.input[l.pos:l.lineEnd]
+}
+
+// lexLine starts scanning from the current position, working out how the
+// line it is on ends. Scanning restarts here after every inline item, so
+// the checks only ever see the rest of the line.
+func lexLine(l *lexer) stateFn {
+	if l.pos >= len(l.input) {
+		l.emit(itemEOF)
+		return nil
+	}
+	l.lineEnd = len(l.input)
+	if i := strings.IndexByte(l.input[l.pos:], '\n'); i >= 0 {
+		l.lineEnd = l.pos + i + 1
+	}
+	line := l.line()
+	n := len(line)
+	l.breakType = itemEOF
+	l.visible = false
+	if line[n-1] == '\n' {
+		switch {
+		case n >= 3 && line[n-2] == '\\' && line[n-3] != '\\' || n == 2 && line[0] == '\\':
+			n -= 2
+			l.breakType, l.visible = itemHardBreak, true
+		case n >= 4 && line[n-3] == '\\' && line[n-2] == '\r' && line[n-4] != '\\' || n == 3 && line[0] == '\\' && line[1] == '\r':
+			n -= 3
+			l.breakType, l.visible = itemHardBreak, true
+		case n >= 3 && line[n-3] == ' ' && line[n-2] == ' ':
+			n -= 3
+			l.breakType = itemHardBreak
+		case n >= 4 && line[n-4] == ' ' && line[n-3] == ' ' && line[n-2] == '\r':
+			n -= 4
+			l.breakType = itemHardBreak
+		default:
+			l.breakType = itemNewLine
 		}
-		if strings.HasPrefix(l.input[l.pos:], eol) {
-			if l.pos > l.start {
-				l.emit(itemText)
-			}
-			return lexNewLine
+	}
+	l.head = l.pos
+	l.start = l.pos
+	l.stop = l.pos + n
+	return lexInline
+}
+
+// lexInline scans up to the end of the line, trying each character that
+// can start an inline item.
+func lexInline(l *lexer) stateFn {
+	for ; l.pos < l.stop; l.pos++ {
+		c := l.input[l.pos]
+		if c == '\n' {
+			break
 		}
-		if strings.HasPrefix(l.input[l.pos:], header) {
-			if l.pos > l.start {
-				l.emit(itemText)
+		space, punct := isSpace(c), isPunct(c)
+		if punct && !l.escaped || space || l.pos == l.head {
+			trigger := c
+			if space || l.pos == l.head && !punct {
+				trigger = ' '
+			}
+			if isTrigger(trigger) {
+				l.emitText(true)
+				if l.lexTrigger(trigger) {
+					return lexLine
+				}
 			}
-			return lexHeader
 		}
-		if strings.HasPrefix(l.input[l.pos:], bold) {
-			if l.pos > l.start {
-				l.emit(itemText)
+		if l.escaped {
+			l.escaped = false
+			continue
+		}
+		l.escaped = c == '\\'
+	}
+	return lexLineEnd
+}
+
+// lexLineEnd sends the text at the end of the line and the break after it.
+func lexLineEnd(l *lexer) stateFn {
+	if !l.visible {
+		l.pos = l.start + len(strings.TrimRight(l.input[l.start:l.pos], " \t\n\r"))
+	}
+	l.emitText(false)
+	if l.breakType != itemEOF {
+		l.items <- item{typ: l.breakType}
+	}
+	l.pos = l.lineEnd
+	l.start = l.pos
+	return lexLine
+}
+
+func isTrigger(c byte) bool {
+	return strings.IndexByte(" *_~([]!`<", c) >= 0
+}
+
+func (l *lexer) lexTrigger(c byte) bool {
+	switch c {
+	case '[':
+		return l.lexTaskBox() || l.lexLink()
+	case '!', ']':
+		return l.lexLink()
+	case '`':
+		return l.lexCodeSpan()
+	case '<':
+		return l.lexAutoLink() || l.lexRawHTML()
+	case '*', '_':
+		return l.lexDelimiter(1)
+	case '~':
+		return l.lexDelimiter(2) || l.lexLinkify()
+	}
+	return l.lexLinkify()
+}
+
+// before returns the character preceding the current position, looking
+// back into the source at the start of a line.
+func (l *lexer) before() rune {
+	i := sort.SearchInts(l.offsets, l.pos)
+	if i < len(l.offsets) && l.offsets[i] == l.pos {
+		if i == 0 || l.lines[i].start == 0 {
+			return '\n'
+		}
+		return runeAt(l.source, l.lines[i].start-1)
+	}
+	seg := l.lines[i-1]
+	if k := l.pos - l.offsets[i-1]; k > seg.padding {
+		return runeAt(l.source, seg.start+k-seg.padding-1)
+	}
+	return ' '
+}
+
+// runeAt decodes the rune that the byte at i belongs to. Like goldmark,
+// it steps back to the nearest rune start, so a stray continuation byte
+// reads as the character before it, or as a newline at the very start.
+func runeAt(s string, i int) rune {
+	for i >= 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	if i < 0 {
+		return '\n'
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return r
+}
+
+var taskBoxRegexp = regexp.MustCompile(`^\[([\sxX])\]\s*`)
+
+func (l *lexer) lexTaskBox() bool {
+	if !l.taskList {
+		return false
+	}
+	m := taskBoxRegexp.FindStringSubmatchIndex(l.line())
+	if m == nil {
+		return false
+	}
+	c := l.input[l.pos+m[2]]
+	l.send(item{typ: itemTaskBox, checked: c == 'x' || c == 'X'}, l.pos+m[1])
+	return true
+}
+
+// lexLink handles the brackets of links and images. Openers are kept on a
+// stack; a ] either closes the last one into a link, if a destination in
+// parentheses follows, or turns it back into text.
+func (l *lexer) lexLink() bool {
+	line := l.line()
+	switch line[0] {
+	case '!':
+		if len(line) < 2 || line[1] != '[' {
+			return false
+		}
+		l.openers = append(l.openers, opening{pos: l.pos, stop: l.pos + 2})
+		l.send(item{typ: itemImageOpen, val: "!["}, l.pos+2)
+		return true
+	case '[':
+		l.openers = append(l.openers, opening{pos: l.pos, stop: l.pos + 1})
+		l.send(item{typ: itemLinkOpen, val: "["}, l.pos+1)
+		return true
+	}
+
+	if len(l.openers) == 0 {
+		return false
+	}
+	l.openers = l.openers[:len(l.openers)-1]
+	// CommonMark limits link labels to 999 characters.
+	if n := len(l.openers); n > 0 && l.openers[n-1].stop-l.openers[0].pos > 998 {
+		l.items <- item{typ: itemLinkText}
+		return false
+	}
+	link, pos, ok := l.scanLinkTail(l.pos + 1)
+	if !ok {
+		l.items <- item{typ: itemLinkText}
+		return false
+	}
+	// Whether the label already holds a link depends on how emphasis has
+	// been matched so far, so only the parser can tell.
+	link.pos = l.pos
+	l.items <- link
+	if !<-l.linked {
+		return false
+	}
+	l.pos = pos
+	l.start = pos
+	return true
+}
+
+// scanLinkTail reads the `(destination "title")` after a link label.
+func (l *lexer) scanLinkTail(pos int) (item, int, bool) {
+	link := item{typ: itemLinkClose}
+	if pos >= len(l.input) || l.input[pos] != '(' {
+		return link, 0, false
+	}
+	pos = l.skipSpaces(pos + 1)
+	if pos < len(l.input) && l.input[pos] == ')' {
+		return link, pos + 1, true
+	}
+
+	var ok bool
+	link.dest, pos, ok = l.scanLinkDestination(pos)
+	if !ok {
+		return link, 0, false
+	}
+	pos = l.skipSpaces(pos)
+	if pos < len(l.input) && l.input[pos] == ')' {
+		return link, pos + 1, true
+	}
+	link.title, pos, ok = l.scanLinkTitle(pos)
+	if !ok {
+		return link, 0, false
+	}
+	link.hasTitle = true
+	pos = l.skipSpaces(pos)
+	if pos < len(l.input) && l.input[pos] == ')' {
+		return link, pos + 1, true
+	}
+	return link, 0, false
+}
+
+func (l *lexer) skipSpaces(pos int) int {
+	for pos < len(l.input) && isSpace(l.input[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// lineAt returns the rest of the line from pos, including its newline.
+func (l *lexer) lineAt(pos int) string {
+	if i := strings.IndexByte(l.input[pos:], '\n'); i >= 0 {
+		return l.input[pos : pos+i+1]
+	}
+	return l.input[pos:]
+}
+
+func (l *lexer) scanLinkDestination(pos int) (string, int, bool) {
+	line := l.lineAt(pos)
+	if len(line) > 0 && line[0] == '<' {
+		for i := 1; i < len(line); i++ {
+			c := line[i]
+			if c == '\\' && i < len(line)-1 && isPunct(line[i+1]) {
+				i++
+			} else if c == '>' {
+				return line[1:i], pos + i + 1, true
 			}
-			return lexBold
 		}
-		if l.next() == eof {
+		return "", 0, false
+	}
+	opened := 0
+	i := 0
+	for i < len(line) {
+		c := line[i]
+		if c == '\\' && i < len(line)-1 && isPunct(line[i+1]) {
+			i += 2
+			continue
+		} else if c == '(' {
+			opened++
+		} else if c == ')' {
+			opened--
+			if opened < 0 {
+				break
+			}
+		} else if isSpace(c) {
 			break
 		}
+		i++
 	}
-	if l.pos > l.start {
-		l.emit(itemText)
-	}
-	l.emit(itemEOF)
-	return nil
+	return line[:i], pos + i, i != 0
 }
 
-func lexHeader(l *lexer) stateFn {
-	if l.accept(header) {
-		l.acceptRun(header)
+func (l *lexer) scanLinkTitle(pos int) (string, int, bool) {
+	if pos >= len(l.input) {
+		return "", 0, false
+	}
+	opener := l.input[pos]
+	closer := opener
+	switch opener {
+	case '"', '\'':
+	case '(':
+		closer = ')'
+	default:
+		return "", 0, false
 	}
-	if l.peek() == ws {
-		l.emit(itemHeader)
-		l.next()
-		l.ignore()
+	start := pos + 1
+	for i := start; i < len(l.input); i++ {
+		c := l.input[i]
+		if c == '\\' && i+1 < len(l.input) && isPunct(l.input[i+1]) {
+			i++
+		} else if c == closer {
+			return l.input[start:i], i + 1, true
+		} else if c == opener {
+			return "", 0, false
+		}
 	}
-	return lexText
+	return "", 0, false
 }
 
-func lexBold(l *lexer) stateFn {
-	if l.accept(bold) {
-		l.emit(itemBold)
+// lexCodeSpan scans a run of backticks and everything up to a matching
+// run. A run that is never matched is left as text.
+func (l *lexer) lexCodeSpan() bool {
+	opener := 0
+	for l.pos+opener < len(l.input) && l.input[l.pos+opener] == '`' {
+		opener++
+	}
+	start := l.pos + opener
+	for i := start; i < len(l.input); {
+		if l.input[i] != '`' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(l.input) && l.input[j] == '`' {
+			j++
+		}
+		if j-i == opener {
+			code := l.input[start:i]
+			if !isBlank(code) && isSpaceOrNewline(code[0]) && isSpaceOrNewline(code[len(code)-1]) {
+				code = code[1 : len(code)-1]
+			}
+			l.send(item{typ: itemCode, val: code}, j)
+			return true
+		}
+		i = j
 	}
-	return lexText
+	l.send(item{typ: itemText, val: l.input[l.pos:start]}, start)
+	return true
+}
+
+func isSpaceOrNewline(c byte) bool {
+	return c == ' ' || c == '\n'
 }
 
-func lexList(l *lexer) stateFn {
-	if l.accept(list) && l.peek() == ws {
-		l.emit(itemList)
-		l.next()
-		l.ignore()
+func (l *lexer) lexAutoLink() bool {
+	line := l.line()
+	email := true
+	stop := findEmailIndex(line[1:])
+	if stop < 0 {
+		email = false
+		stop = findURLIndex(line[1:])
 	}
-	return lexText
+	if stop < 0 {
+		return false
+	}
+	stop++
+	if stop >= len(line) || line[stop] != '>' {
+		return false
+	}
+	label := line[1:stop]
+	l.send(item{typ: itemAutoLink, val: label, dest: label, email: email}, l.pos+stop+1)
+	return true
 }
 
-func (l *lexer) next() (r rune) {
-	if l.pos >= len(l.input) {
-		l.width = 0
-		return eof
+const (
+	tagnamePattern   = `([A-Za-z][A-Za-z0-9-]*)`
+	attributePattern = `(?:[\r\n \t]+[a-zA-Z_:][a-zA-Z0-9:._-]*(?:[\r\n \t]*=[\r\n \t]*(?:[^\"'=<>` + "`" + `\x00-\x20]+|'[^']*'|"[^"]*"))?)`
+)
+
+var (
+	openTagRegexp  = regexp.MustCompile("^<" + tagnamePattern + attributePattern + `*[ \t]*/?>`)
+	closeTagRegexp = regexp.MustCompile("^</" + tagnamePattern + `\s*>`)
+)
+
+// lexRawHTML recognises inline tags, comments, processing instructions,
+// declarations and CDATA sections. They are never rendered.
+func (l *lexer) lexRawHTML() bool {
+	line := l.line()
+	var end int
+	switch {
+	case len(line) > 1 && isAlphaNumeric(line[1]):
+		end = l.matchRegexp(openTagRegexp)
+	case len(line) > 2 && line[1] == '/' && isAlphaNumeric(line[2]):
+		end = l.matchRegexp(closeTagRegexp)
+	case strings.HasPrefix(line, "<!--"):
+		end = l.scanComment()
+	case strings.HasPrefix(line, "<?"):
+		end = l.scanUntil("?>")
+	case len(line) > 2 && line[1] == '!' && line[2] >= 'A' && line[2] <= 'Z':
+		end = l.scanUntil(">")
+	case strings.HasPrefix(line, "<![CDATA["):
+		end = l.scanUntil("]]>")
+	default:
+		return false
 	}
+	if end < 0 {
+		return false
+	}
+	l.send(item{typ: itemHTML}, end)
+	return true
+}
 
-	r, l.width = utf8.DecodeRuneInString(l.input[l.pos:])
-	l.pos += l.width
-	return r
+// matchRegexp matches re against the rest of the block, which may span
+// lines, and returns the position after the match or -1.
+func (l *lexer) matchRegexp(re *regexp.Regexp) int {
+	rest := l.input[l.pos:]
+	for i, r := range rest {
+		if r == utf8.RuneError {
+			rest = rest[:i]
+			break
+		}
+	}
+	m := re.FindStringIndex(rest)
+	if m == nil {
+		return -1
+	}
+	return l.pos + m[1]
 }
 
-func (l *lexer) ignore() {
-	l.start = l.pos
+func (l *lexer) scanUntil(closer string) int {
+	for pos := l.pos; pos < len(l.input); {
+		line := l.lineAt(pos)
+		if i := strings.Index(line, closer); i >= 0 {
+			return pos + i + len(closer)
+		}
+		pos += len(line)
+	}
+	return -1
 }
 
-func (l *lexer) skip() {
-	l.pos += 1
+func (l *lexer) scanComment() int {
+	line := l.line()
+	if strings.HasPrefix(line, "<!---->") {
+		return l.pos + len("<!---->")
+	}
+	if strings.HasPrefix(line, "<!-->") || strings.HasPrefix(line, "<!--->") {
+		return -1
+	}
+	pos := l.pos
+	offset := len("<!--")
+	line = line[offset:]
+	for {
+		hindex := strings.Index(line, "--")
+		if hindex > -1 {
+			hindex += offset
+		}
+		index := strings.Index(line, "-->") + offset
+		if index > -1 && hindex == index {
+			if index == 0 || len(line) < 2 || line[index-offset-1] != '-' {
+				return pos + index + len("-->")
+			}
+		}
+		if hindex > 0 {
+			return -1
+		}
+		pos += offset + len(line)
+		if pos >= len(l.input) {
+			return -1
+		}
+		line = l.lineAt(pos)
+		offset = 0
+	}
 }
 
-func (l *lexer) backup() {
-	l.pos -= l.width
+// lexDelimiter scans a run of emphasis or strikethrough characters,
+// working out from its neighbours whether it can open or close a span.
+func (l *lexer) lexDelimiter(min int) bool {
+	line := l.line()
+	c := line[0]
+	j := 0
+	for j < len(line) && line[j] == c {
+		j++
+	}
+	if j < min {
+		return false
+	}
+	after := ' '
+	if j != len(line) {
+		after = runeAt(line, j)
+	}
+	before := l.before()
+	beforeIsPunct, beforeIsSpace := isPunctRune(before), isSpaceRune(before)
+	afterIsPunct, afterIsSpace := isPunctRune(after), isSpaceRune(after)
+
+	isLeft := !afterIsSpace && (!afterIsPunct || beforeIsSpace || beforeIsPunct)
+	isRight := !beforeIsSpace && (!beforeIsPunct || afterIsSpace || afterIsPunct)
+
+	canOpen, canClose := isLeft, isRight
+	if c == '_' {
+		canOpen = isLeft && (!isRight || beforeIsPunct)
+		canClose = isRight && (!isLeft || afterIsPunct)
+	}
+	l.send(item{typ: itemDelimiter, val: line[:j], canOpen: canOpen, canClose: canClose}, l.pos+j)
+	return true
 }
 
-func (l *lexer) peek() rune {
-	rune := l.next()
-	l.backup()
-	return rune
+var (
+	urlRegexp = regexp.MustCompile(`^(?:http|https|ftp)://[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[a-z]+(?::\d+)?(?:[/#?][-a-zA-Z0-9@:%_+.~#$!?&/=\(\);,'">\^{}\[\]` + "`" + `]*)?`)
+	wwwRegexp = regexp.MustCompile(`^www\.[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[a-z]+(?:[/#?][-a-zA-Z0-9@:%_\+.~#!?&/=\(\);,'">\^{}\[\]` + "`" + `]*)?`)
+)
+
+// lexLinkify turns bare URLs, www. addresses and email addresses into
+// links, as GitHub does. It may step over one leading space or bracket.
+func (l *lexer) lexLinkify() bool {
+	if len(l.openers) != 0 {
+		return false
+	}
+	line := l.line()
+	consumes := 0
+	switch line[0] {
+	case ' ', '*', '_', '~', '(':
+		consumes++
+		line = line[1:]
+	}
+
+	var m []int
+	protocol := ""
+	email := false
+	if strings.HasPrefix(line, "http:") || strings.HasPrefix(line, "https:") || strings.HasPrefix(line, "ftp:") {
+		m = urlRegexp.FindStringIndex(line)
+	}
+	if m == nil && strings.HasPrefix(line, "www.") {
+		m = wwwRegexp.FindStringIndex(line)
+		protocol = "http"
+	}
+	if m != nil {
+		switch line[m[1]-1] {
+		case '.':
+			m[1]--
+		case ')':
+			closing := 0
+			for i := m[1] - 1; i >= m[0]; i-- {
+				if line[i] == ')' {
+					closing++
+				} else if line[i] == '(' {
+					closing--
+				}
+			}
+			if closing > 0 {
+				m[1] -= closing
+			}
+		case ';':
+			i := m[1] - 2
+			for ; i >= m[0] && isAlphaNumeric(line[i]); i-- {
+			}
+			if i != m[1]-2 && line[i] == '&' {
+				m[1] = i
+			}
+		}
+	} else {
+		if len(line) > 0 && isPunct(line[0]) {
+			return false
+		}
+		email = true
+		stop := findEmailIndex(line)
+		if stop < 0 {
+			return false
+		}
+		at := strings.IndexByte(line, '@')
+		if strings.IndexByte(line[at:stop-1], '.') < 0 {
+			return false
+		}
+		if line[stop-1] == '.' {
+			stop--
+		}
+		if stop < len(line) && (line[stop] == '-' || line[stop] == '_') {
+			return false
+		}
+		m = []int{0, stop}
+	}
+
+	if consumes != 0 {
+		l.send(item{typ: itemText, val: l.input[l.pos : l.pos+1], join: true}, l.pos+1)
+	}
+	i := m[1] - 1
+	for ; i > 0 && strings.IndexByte("?!.,:*_~", line[i]) >= 0; i-- {
+	}
+	i++
+	label := line[:i]
+	dest := label
+	if protocol != "" {
+		dest = protocol + "://" + label
+	}
+	l.send(item{typ: itemAutoLink, val: label, dest: dest, email: email}, l.pos+i)
+	return true
 }
 
-func (l *lexer) accept(valid string) bool {
-	if strings.IndexRune(valid, l.next()) >= 0 {
-		return true
+// findURLIndex returns the end of an absolute URI like scheme:rest at the
+// start of b, or -1.
+func findURLIndex(b string) int {
+	if len(b) == 0 || !isAlpha(b[0]) {
+		return -1
+	}
+	i := 1
+	for i < len(b) && (isAlphaNumeric(b[i]) || b[i] == '+' || b[i] == '.' || b[i] == '-') {
+		i++
+	}
+	if i == 1 || i > 33 || i >= len(b) || b[i] != ':' {
+		return -1
+	}
+	for i++; i < len(b); i++ {
+		c := b[i]
+		if c <= ' ' || c == '<' || c == '>' {
+			break
+		}
 	}
-	l.backup()
-	return false
+	return i
 }
 
-func (l *lexer) acceptRun(valid string) {
-	for strings.IndexRune(valid, l.next()) >= 0 {
+var emailDomainRegexp = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*`)
+
+// findEmailIndex returns the end of an email address at the start of b,
+// or -1.
+func findEmailIndex(b string) int {
+	i := 0
+	for i < len(b) && (isAlphaNumeric(b[i]) || strings.IndexByte(".!#$%&'*+/=?^_`{|}~-", b[i]) >= 0) {
+		i++
+	}
+	if i == 0 || i >= len(b) || b[i] != '@' {
+		return -1
+	}
+	i++
+	if i >= len(b) {
+		return -1
+	}
+	m := emailDomainRegexp.FindStringIndex(b[i:])
+	if m == nil {
+		return -1
 	}
-	l.backup()
+	return i + m[1]
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
 
 func (i item) String() string {
-	switch i.typ {
-	case itemEOF:
+	switch {
+	case i.typ == itemEOF:
 		return "EOF"
-	case itemError:
+	case i.typ == itemError:
 		return i.val
-	}
-
-	if len(i.val) > 10 {
+	case len(i.val) > 10:
 		return fmt.Sprintf("%.10q...", i.val)
 	}
-
 	return fmt.Sprintf("%q", i.val)
 }
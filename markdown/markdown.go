@@ -0,0 +1,49 @@
+// Package markdown renders Markdown as HTML without third-party
+// dependencies. Its output matches goldmark configured as the notes
+// renderer is: GitHub flavoured, with heading IDs, hard line breaks and
+// XHTML tags, and with raw HTML left out.
+//
+// It supports headings, paragraphs, emphasis, strikethrough, inline code,
+// fenced and indented code blocks, block quotes, nested ordered and
+// unordered lists, task list items, thematic breaks, links, images and
+// autolinks, including bare URLs and email addresses.
+//
+// Tables and link reference definitions are not supported. A table is
+// rendered as a paragraph, and [text][label] or [label] is always left as
+// literal text.
+package markdown
+
+import (
+	"io"
+	"strings"
+)
+
+// Convert renders the Markdown in source as HTML and writes it to w.
+func Convert(source []byte, w io.Writer) error {
+	src := string(source)
+	doc := parseBlocks(src)
+	parseInlines(&parser{}, doc, src)
+
+	var out strings.Builder
+	r := &renderer{source: src, out: &out}
+	r.renderBlocks(doc)
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// parseInlines parses the text of every paragraph and heading under b,
+// children first. Code and HTML blocks are left as they are.
+func parseInlines(p *parser, b *block, source string) {
+	for _, c := range b.children {
+		parseInlines(p, c, source)
+	}
+	switch b.kind {
+	case codeBlock, fencedCodeBlock, htmlBlock:
+	case paragraphBlock, headingBlock:
+		taskList := b.parent.kind == listItemBlock && b.parent.children[0] == b
+		l, items := lex("markdown", source, b.lines, taskList)
+		b.inlines = p.parse(items, l.linked)
+	default:
+		p.closeBlock()
+	}
+}
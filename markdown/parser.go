@@ -1,184 +1,400 @@
 package markdown
 
-import (
-	"bytes"
-	"fmt"
-)
+import "strings"
 
-type parser struct {
-	items  chan item // channel of the scanned items
-	output *bytes.Buffer
-}
-
-type contextType int
+type nodeKind int
 
 const (
-	inText contextType = iota
-	inList
+	rootNode nodeKind = iota
+	textNode
+	breakNode
+	codeNode
+	emphasisNode
+	strikethroughNode
+	linkNode
+	imageNode
+	autoLinkNode
+	htmlNode
+	taskBoxNode
+	delimiterNode
+	openerNode
 )
 
-type Tag interface {
-	Open() string
-	Close() string
-	Tag() string
+// node is an inline element. Children are kept as a linked list so spans
+// can be cut out and wrapped as emphasis and links are matched.
+type node struct {
+	kind                     nodeKind
+	parent, prev, next       *node
+	first, last              *node
+	value                    string // text, code or autolink label
+	start, stop              int    // text, delimiter or opener position in the input
+	level                    int    // emphasis: 1 for <em>, 2 for <strong>
+	dest, title              string
+	hasTitle, email, checked bool
+
+	// delimiter run state
+	char                 byte
+	canOpen, canClose    bool
+	length, origLength   int
+	prevDelim, nextDelim *node
 }
 
-type HeaderTag struct {
-	level int
+func (n *node) appendChild(c *node) {
+	if c.parent != nil {
+		c.parent.removeChild(c)
+	}
+	if n.last == nil {
+		n.first = c
+	} else {
+		n.last.next = c
+		c.prev = n.last
+	}
+	c.parent = n
+	n.last = c
 }
 
-func (h *HeaderTag) Open() string {
-	return fmt.Sprintf("<h%d>", h.level)
+func (n *node) removeChild(c *node) {
+	if c.parent != n {
+		return
+	}
+	if c.prev != nil {
+		c.prev.next = c.next
+	} else {
+		n.first = c.next
+	}
+	if c.next != nil {
+		c.next.prev = c.prev
+	} else {
+		n.last = c.prev
+	}
+	c.parent, c.prev, c.next = nil, nil, nil
 }
 
-func (h *HeaderTag) Close() string {
-	return fmt.Sprintf("</h%d>", h.level)
+func (n *node) insertAfter(at, c *node) {
+	if c.parent != nil {
+		c.parent.removeChild(c)
+	}
+	if at.next == nil {
+		n.appendChild(c)
+		return
+	}
+	c.prev, c.next = at, at.next
+	at.next.prev = c
+	at.next = c
+	c.parent = n
 }
 
-func (h *HeaderTag) Tag() string {
-	return "h"
+func (n *node) replaceChild(old, c *node) {
+	n.insertAfter(old, c)
+	n.removeChild(old)
 }
 
-type UnorderedListTag struct{}
-
-func (ul *UnorderedListTag) Open() string {
-	return fmt.Sprintf("<ul>")
+// text returns the plain text of n's children, as used for image alt text.
+func (n *node) text() string {
+	var b strings.Builder
+	for c := n.first; c != nil; c = c.next {
+		switch c.kind {
+		case textNode, codeNode:
+			b.WriteString(c.value)
+		case delimiterNode:
+			b.WriteString(c.value[:c.length])
+		case openerNode:
+			b.WriteString(c.value)
+		case autoLinkNode, htmlNode:
+		default:
+			b.WriteString(c.text())
+		}
+	}
+	return b.String()
 }
 
-func (ul *UnorderedListTag) Close() string {
-	return fmt.Sprintf("</ul>")
+type parser struct {
+	items      chan item // channel of the scanned items
+	linked     chan bool // whether each itemLinkClose made a link
+	root       *node
+	openers    []*node // unclosed link labels
+	firstDelim *node   // delimiter runs not yet matched
+	lastDelim  *node
+	linkBottom *node // last delimiter before the latest [
 }
 
-func (ul *UnorderedListTag) Tag() string {
-	return "ul"
-}
+// parse builds the inline tree of a block from its lexed items. The
+// delimiter list outlives the block: a run left inside a link is only
+// cleared when the next block closes, and may be matched from there.
+func (p *parser) parse(items chan item, linked chan bool) *node {
+	p.items = items
+	p.linked = linked
+	p.root = &node{kind: rootNode}
 
-type ListItemTag struct{}
+	for item := range p.items {
+		switch item.typ {
+		case itemText:
+			p.appendText(item)
+		case itemNewLine, itemHardBreak:
+			p.root.appendChild(&node{kind: breakNode})
+		case itemCode:
+			p.root.appendChild(&node{kind: codeNode, value: item.val})
+		case itemDelimiter:
+			d := &node{
+				kind:       delimiterNode,
+				value:      item.val,
+				char:       item.val[0],
+				canOpen:    item.canOpen,
+				canClose:   item.canClose,
+				start:      item.pos,
+				length:     len(item.val),
+				origLength: len(item.val),
+			}
+			p.root.appendChild(d)
+			p.pushDelimiter(d)
+		case itemLinkOpen, itemImageOpen:
+			p.linkBottom = p.lastDelim
+			o := &node{kind: openerNode, value: item.val, start: item.pos}
+			p.root.appendChild(o)
+			p.openers = append(p.openers, o)
+		case itemLinkClose:
+			p.linked <- p.closeLink(item)
+		case itemLinkText:
+			last := p.popOpener()
+			replaceWithText(last, last.value, last.start)
+		case itemAutoLink:
+			p.root.appendChild(&node{kind: autoLinkNode, value: item.val, dest: item.dest, email: item.email})
+		case itemHTML:
+			p.root.appendChild(&node{kind: htmlNode})
+		case itemTaskBox:
+			p.root.appendChild(&node{kind: taskBoxNode, checked: item.checked})
+		case itemEOF:
+			p.closeBlock()
+		}
+	}
 
-func (li *ListItemTag) Open() string {
-	return fmt.Sprintf("<li>")
+	return p.root
 }
 
-func (li *ListItemTag) Close() string {
-	return fmt.Sprintf("</li>")
+// closeBlock resolves the pending delimiters and turns unclosed link
+// labels back into text. It runs at the end of every block, containers
+// included.
+func (p *parser) closeBlock() {
+	p.processDelimiters(nil)
+	p.linkBottom = nil
+	for _, o := range p.openers {
+		o.parent.replaceChild(o, newText(o.value, o.start))
+	}
+	p.openers = nil
 }
 
-func (li *ListItemTag) Tag() string {
-	return "li"
+func newText(value string, start int) *node {
+	return &node{kind: textNode, value: value, start: start, stop: start + len(value)}
 }
 
-type BoldTag struct{}
-
-func (em *BoldTag) Open() string {
-	return fmt.Sprintf("<em>")
+// appendText adds text to the end of the block, running it on from the
+// text before it if the two are adjacent in the input. This matters for
+// rendering: a backslash only escapes what follows it in the same node.
+func (p *parser) appendText(i item) {
+	if last := p.root.last; i.join && last != nil && last.kind == textNode && last.stop == i.pos {
+		last.value += i.val
+		last.stop += len(i.val)
+		return
+	}
+	p.root.appendChild(newText(i.val, i.pos))
 }
 
-func (em *BoldTag) Close() string {
-	return fmt.Sprintf("</em>")
+// replaceWithText turns n into text, running it on from the text before
+// it if the two are adjacent.
+func replaceWithText(n *node, value string, start int) {
+	if prev := n.prev; prev != nil && prev.kind == textNode && prev.stop == start {
+		prev.value += value
+		prev.stop += len(value)
+		n.parent.removeChild(n)
+		return
+	}
+	n.parent.replaceChild(n, newText(value, start))
 }
 
-func (em *BoldTag) Tag() string {
-	return "em"
+func (p *parser) popOpener() *node {
+	last := p.openers[len(p.openers)-1]
+	p.openers = p.openers[:len(p.openers)-1]
+	return last
 }
 
-func parse(items chan item, buf *bytes.Buffer) *bytes.Buffer {
-	p := &parser{
-		items:  items,
-		output: buf,
+// closeLink turns everything after the last opener into a link or image.
+// Emphasis inside the label is resolved first so it can't reach outside.
+// A link can't hold another link, so then the opener becomes text.
+func (p *parser) closeLink(i item) bool {
+	last := p.popOpener()
+	if last.value == "[" && containsLink(last) {
+		replaceWithText(last, last.value, last.start)
+		return false
+	}
+	link := &node{kind: linkNode, dest: i.dest, title: i.title, hasTitle: i.hasTitle}
+	if last.value == "![" {
+		link.kind = imageNode
 	}
 
-	context := inText
-	stack := NewStack()
+	bottom := p.linkBottom
+	p.linkBottom = nil
+	p.processDelimiters(bottom)
+	for c := last.next; c != nil; {
+		next := c.next
+		link.appendChild(c)
+		c = next
+	}
+	last.parent.removeChild(last)
+	p.root.appendChild(link)
+	return true
+}
 
-	for item := range p.items {
-		switch item.typ {
-		case itemText:
-			fmt.Fprintf(p.output, item.val)
-		case itemBold:
-			if !stack.Empty() && stack.Peek().Tag() == "em" {
-				em := stack.Pop()
-				fmt.Fprintf(p.output, em.Close())
-			} else {
-				em := &BoldTag{}
-				stack.Push(em)
-				fmt.Fprintf(p.output, em.Open())
-			}
-		case itemHeader:
-			h := &HeaderTag{len(item.val)}
-			stack.Push(h)
-			fmt.Fprintf(p.output, h.Open())
-		case itemUnorderedList:
-			if context != inList {
-				ul := &UnorderedListTag{}
-				stack.Push(ul)
-				context = inList
-				fmt.Fprintf(p.output, ul.Open())
-				fmt.Fprintf(p.output, "\n")
-			}
-			li := &ListItemTag{}
-			stack.Push(li)
-			fmt.Fprintf(p.output, li.Open())
-		case itemNewLine:
-			if stack.Empty() {
-				fmt.Fprintf(p.output, "\n")
-			} else {
-				switch stack.Peek().Tag() {
-				case "h1":
-					fmt.Fprintf(p.output, stack.Pop().Close())
-				case "li":
-					fmt.Fprintf(p.output, stack.Pop().Close())
-					fmt.Fprintf(p.output, "\n")
-				case "ul":
-					fmt.Fprintf(p.output, stack.Pop().Close())
-					fmt.Fprintf(p.output, "\n")
-					context = inText
-				}
-			}
-		case itemEOF:
-			if stack.Empty() {
-				break
-			}
-			switch stack.Peek().Tag() {
-			case "h":
-				fmt.Fprintf(p.output, stack.Pop().Close())
-			case "li":
-				fmt.Fprintf(p.output, stack.Pop().Close())
-				fmt.Fprintf(p.output, "\n")
-				fmt.Fprintf(p.output, stack.Pop().Close())
-				fmt.Fprintf(p.output, "\n")
-			}
+// containsLink reports whether a link follows n, at any depth.
+func containsLink(n *node) bool {
+	for c := n; c != nil; c = c.next {
+		if c.kind == linkNode || containsLink(c.first) {
+			return true
 		}
 	}
-
-	return buf
+	return false
 }
 
-type Stack struct {
-	s []Tag
+func (p *parser) pushDelimiter(d *node) {
+	if p.firstDelim == nil {
+		p.firstDelim = d
+	} else {
+		p.lastDelim.nextDelim = d
+		d.prevDelim = p.lastDelim
+	}
+	p.lastDelim = d
 }
 
-func NewStack() *Stack {
-	return &Stack{[]Tag{}}
+// removeDelimiter drops d from the delimiter list, leaving whatever of the
+// run wasn't used for emphasis as text.
+func (p *parser) removeDelimiter(d *node) {
+	if d.prevDelim == nil {
+		p.firstDelim = d.nextDelim
+	} else {
+		d.prevDelim.nextDelim = d.nextDelim
+		if d.nextDelim != nil {
+			d.nextDelim.prevDelim = d.prevDelim
+		}
+	}
+	if d.nextDelim == nil {
+		p.lastDelim = d.prevDelim
+	}
+	if p.firstDelim != nil {
+		p.firstDelim.prevDelim = nil
+	}
+	if p.lastDelim != nil {
+		p.lastDelim.nextDelim = nil
+	}
+	d.prevDelim, d.nextDelim = nil, nil
+	if d.length != 0 {
+		replaceWithText(d, d.value[:d.length], d.start)
+	} else {
+		d.parent.removeChild(d)
+	}
 }
 
-func (s *Stack) Push(item Tag) {
-	s.s = append(s.s, item)
+// clearDelimiters removes the delimiters after bottom.
+func (p *parser) clearDelimiters(bottom *node) {
+	if p.lastDelim == nil {
+		return
+	}
+	for c := p.lastDelim; c != nil && c != bottom; {
+		prev := c.prev
+		if c.kind == delimiterNode {
+			p.removeDelimiter(c)
+		}
+		c = prev
+	}
 }
 
-func (s *Stack) Empty() bool {
-	n := len(s.s)
-	return n == 0
+// consumption returns how many characters of the opener and closer runs
+// make up a span, following the CommonMark "rule of 3".
+func consumption(opener, closer *node) int {
+	if (opener.canClose || closer.canOpen) && (opener.origLength+closer.origLength)%3 == 0 && closer.origLength%3 != 0 {
+		return 0
+	}
+	if opener.length >= 2 && closer.length >= 2 {
+		return 2
+	}
+	return 1
 }
 
-func (s *Stack) Peek() Tag {
-	n := len(s.s) - 1
-	return s.s[n]
-}
+// processDelimiters matches the delimiter runs after bottom into emphasis
+// and strikethrough spans.
+func (p *parser) processDelimiters(bottom *node) {
+	if p.lastDelim == nil {
+		return
+	}
+	var closer *node
+	if bottom != nil {
+		if bottom != p.lastDelim {
+			for c := p.lastDelim.prev; c != nil && c != bottom; c = c.prev {
+				if c.kind == delimiterNode {
+					closer = c
+				}
+			}
+		}
+	} else {
+		closer = p.firstDelim
+	}
+	if closer == nil {
+		p.clearDelimiters(bottom)
+		return
+	}
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.nextDelim
+			continue
+		}
+		consume := 0
+		found := false
+		maybeOpener := false
+		var opener *node
+		for opener = closer.prevDelim; opener != nil && opener != bottom; opener = opener.prevDelim {
+			if opener.canOpen && opener.char == closer.char {
+				maybeOpener = true
+				consume = consumption(opener, closer)
+				if consume > 0 {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			next := closer.nextDelim
+			if !maybeOpener && !closer.canOpen {
+				p.removeDelimiter(closer)
+			}
+			closer = next
+			continue
+		}
+		opener.length -= consume
+		closer.length -= consume
 
-func (s *Stack) Pop() (item Tag) {
-	n := len(s.s) - 1
-	item = s.s[n]
-	s.s = s.s[:n]
+		span := &node{kind: emphasisNode, level: consume}
+		if opener.char == '~' {
+			span.kind = strikethroughNode
+		}
+		parent := opener.parent
+		for child := opener.next; child != nil && child != closer; {
+			next := child.next
+			span.appendChild(child)
+			child = next
+		}
+		parent.insertAfter(opener, span)
 
-	return item
+		for c := opener.nextDelim; c != nil && c != closer; {
+			next := c.nextDelim
+			p.removeDelimiter(c)
+			c = next
+		}
+		if opener.length == 0 {
+			p.removeDelimiter(opener)
+		}
+		if closer.length == 0 {
+			next := closer.nextDelim
+			p.removeDelimiter(closer)
+			closer = next
+		}
+	}
+	p.clearDelimiters(bottom)
 }
@@ -7,56 +7,47 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-// Indented lists
-// links
-// italics
-// strikethrough
-// inline code
-// code block
-// block quote
-// todo
-
 func TestParseHeader1(t *testing.T) {
 	buf := bytes.Buffer{}
 	text := "# The Title\n"
-	want := "<h1>The Title</h1>"
-	_, itemChan := lex("markdown", text)
-	parse(itemChan, &buf)
+	want := "<h1 id=\"the-title\">The Title</h1>\n"
+	err := Convert([]byte(text), &buf)
 	got := buf.String()
 
+	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
 
 func TestParseHeader2(t *testing.T) {
 	buf := bytes.Buffer{}
 	text := "## The Title\n"
-	want := "<h2>The Title</h2>"
-	_, itemChan := lex("markdown", text)
-	parse(itemChan, &buf)
+	want := "<h2 id=\"the-title\">The Title</h2>\n"
+	err := Convert([]byte(text), &buf)
 	got := buf.String()
 
+	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
 
 func TestParseHeader3(t *testing.T) {
 	buf := bytes.Buffer{}
 	text := "##The Title\n"
-	want := "##The Title\n"
-	_, itemChan := lex("markdown", text)
-	parse(itemChan, &buf)
+	want := "<p>##The Title</p>\n"
+	err := Convert([]byte(text), &buf)
 	got := buf.String()
 
+	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
 
 func TestParseHeader4(t *testing.T) {
 	buf := bytes.Buffer{}
 	text := "## The Title"
-	want := "<h2>The Title</h2>"
-	_, itemChan := lex("markdown", text)
-	parse(itemChan, &buf)
+	want := "<h2 id=\"the-title\">The Title</h2>\n"
+	err := Convert([]byte(text), &buf)
 	got := buf.String()
 
+	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
 
@@ -64,10 +55,10 @@ func TestParseList(t *testing.T) {
 	buf := bytes.Buffer{}
 	text := "- list 1\n- list 2\n\n"
 	want := "<ul>\n<li>list 1</li>\n<li>list 2</li>\n</ul>\n"
-	_, itemChan := lex("markdown", text)
-	parse(itemChan, &buf)
+	err := Convert([]byte(text), &buf)
 	got := buf.String()
 
+	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
 
@@ -75,31 +66,31 @@ func TestParseList1(t *testing.T) {
 	buf := bytes.Buffer{}
 	text := "- list 1\n- list 2"
 	want := "<ul>\n<li>list 1</li>\n<li>list 2</li>\n</ul>\n"
-	_, itemChan := lex("markdown", text)
-	parse(itemChan, &buf)
+	err := Convert([]byte(text), &buf)
 	got := buf.String()
 
+	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
 
 func TestParseList2(t *testing.T) {
 	buf := bytes.Buffer{}
 	text := "-list 1"
-	want := "-list 1"
-	_, itemChan := lex("markdown", text)
-	parse(itemChan, &buf)
+	want := "<p>-list 1</p>\n"
+	err := Convert([]byte(text), &buf)
 	got := buf.String()
 
+	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
 
 func TestBold(t *testing.T) {
 	buf := bytes.Buffer{}
 	text := "hello *Tom*"
-	want := "hello <em>Tom</em>"
-	_, itemChan := lex("markdown", text)
-	parse(itemChan, &buf)
+	want := "<p>hello <em>Tom</em></p>\n"
+	err := Convert([]byte(text), &buf)
 	got := buf.String()
 
+	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
@@ -0,0 +1,189 @@
+package notes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/thrgamon/nous/contexts"
+	"github.com/thrgamon/nous/tags"
+	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/web"
+)
+
+const defaultBoardType = "status"
+const allContexts = "all"
+
+var ErrWIPLimitReached = errors.New("that column is at its WIP limit")
+
+type BoardColumn struct {
+	// Value is the tag a note in this column carries, empty for the column of
+	// notes without one
+	Value    string
+	Name     string
+	WIPLimit int
+	Notes    []Note
+}
+
+func (c BoardColumn) Full() bool {
+	return c.WIPLimit > 0 && len(c.Notes) >= c.WIPLimit
+}
+
+func (c BoardColumn) OverLimit() bool {
+	return c.WIPLimit > 0 && len(c.Notes) > c.WIPLimit
+}
+
+// BuildBoard sorts notes into a column per value, in the order the values are
+// defined. Notes without a value, or with one that is no longer allowed, go
+// into a leading "Unsorted" column.
+func BuildBoard(columns []tags.Column, notes []Note, values map[NoteID]string) []BoardColumn {
+	board := []BoardColumn{{Name: "Unsorted"}}
+	index := make(map[string]int)
+	for _, column := range columns {
+		index[column.Value] = len(board)
+		board = append(board, BoardColumn{Value: column.Value, Name: column.Value, WIPLimit: column.WIPLimit})
+	}
+
+	for _, note := range notes {
+		i, ok := index[values[note.ID]]
+		if !ok {
+			i = 0
+		}
+		board[i].Notes = append(board[i].Notes, note)
+	}
+
+	return board
+}
+
+type BoardPageData struct {
+	Type     tags.Type
+	Columns  []BoardColumn
+	Context  string
+	Contexts []string
+	Error    string
+}
+
+func BoardHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	renderBoard(w, r, "board", nil)
+}
+
+// MoveCardHandler moves a note to another column, refusing to go over the
+// column's WIP limit.
+func MoveCardHandler(w http.ResponseWriter, r *http.Request) {
+	id := NoteID(mux.Vars(r)["id"])
+	r.ParseForm()
+
+	board, err := loadBoard(r)
+	if err != nil {
+		renderBoard(w, r, "_board", err)
+		return
+	}
+
+	filter := board.Context
+	if filter == allContexts {
+		filter = ""
+	}
+
+	err = NewNoteRepo().MoveToColumn(r.Context(), id, board.Type.ID, r.FormValue("value"), filter)
+	renderBoard(w, r, "_board", err)
+}
+
+func WIPLimitHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil {
+		limit = 0
+	}
+
+	t, err := tags.NewTypeRepo().GetByName(r.Context(), boardType(r))
+	if err == nil {
+		err = tags.NewTypeRepo().SetWIPLimit(r.Context(), t.ID, r.FormValue("value"), limit)
+	}
+
+	renderBoard(w, r, "_board", err)
+}
+
+func boardType(r *http.Request) string {
+	if t := r.FormValue("type"); t != "" {
+		return t
+	}
+	return defaultBoardType
+}
+
+func loadBoard(r *http.Request) (BoardPageData, error) {
+	pageData := BoardPageData{}
+	contextRepo := contexts.NewContextRepo()
+	pageData.Contexts = contextRepo.GetContexts(r.Context())
+
+	pageData.Context = r.FormValue("context")
+	if pageData.Context == "" {
+		pageData.Context = contextRepo.GetActiveContext(r.Context())
+	}
+
+	typeRepo := tags.NewTypeRepo()
+	t, err := typeRepo.GetByName(r.Context(), boardType(r))
+	if err != nil {
+		return pageData, err
+	}
+	if !t.Exclusive {
+		return pageData, fmt.Errorf("%w: %s", tags.ErrNotExclusive, t.Name)
+	}
+	pageData.Type = t
+
+	columns, err := typeRepo.GetColumns(r.Context(), t.ID)
+	if err != nil {
+		return pageData, err
+	}
+
+	filter := pageData.Context
+	if filter == allContexts {
+		filter = ""
+	}
+
+	noteRepo := NewNoteRepo()
+	notes, err := noteRepo.GetForBoard(r.Context(), t.ID, filter)
+	if err != nil {
+		return pageData, err
+	}
+
+	values, err := noteRepo.GetTagValues(r.Context(), t)
+	if err != nil {
+		return pageData, err
+	}
+
+	pageData.Columns = BuildBoard(columns, notes, values)
+
+	return pageData, nil
+}
+
+func renderBoard(w http.ResponseWriter, r *http.Request, tmpl string, actionErr error) {
+	pageData, err := loadBoard(r)
+
+	if errors.Is(err, tags.ErrUnknownType) || errors.Is(err, tags.ErrNotExclusive) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	if actionErr != nil {
+		switch {
+		case errors.Is(actionErr, ErrWIPLimitReached),
+			errors.Is(actionErr, tags.ErrValueNotAllowed),
+			errors.Is(actionErr, tags.ErrTagTypeTaken),
+			errors.Is(actionErr, tags.ErrInvalidWIPLimit):
+			pageData.Error = actionErr.Error()
+		default:
+			web.HandleUnexpectedError(w, actionErr)
+			return
+		}
+	}
+
+	templates.RenderTemplate(w, tmpl, pageData)
+}
@@ -0,0 +1,25 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thrgamon/nous/tags"
+)
+
+func TestBuildBoard(t *testing.T) {
+	columns := []tags.Column{{Value: "backlog"}, {Value: "doing", WIPLimit: 1}, {Value: "done"}}
+	notes := []Note{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}}
+	values := map[NoteID]string{"1": "doing", "2": "retired", "4": "doing"}
+
+	board := BuildBoard(columns, notes, values)
+
+	assert.Len(t, board, 4)
+	assert.Equal(t, "Unsorted", board[0].Name)
+	assert.Equal(t, []Note{{ID: "2"}, {ID: "3"}}, board[0].Notes)
+	assert.Empty(t, board[1].Notes)
+	assert.Equal(t, []Note{{ID: "1"}, {ID: "4"}}, board[2].Notes)
+	assert.True(t, board[2].Full())
+	assert.True(t, board[2].OverLimit())
+	assert.False(t, board[3].Full())
+}
@@ -1,12 +1,14 @@
 package notes
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/thrgamon/nous/logger"
+	"github.com/thrgamon/nous/tags"
 	"github.com/thrgamon/nous/templates"
 	"github.com/thrgamon/nous/web"
 )
@@ -25,6 +27,30 @@ func ViewNoteHandler(w http.ResponseWriter, r *http.Request) {
 	templates.RenderTemplate(w, "_note", note)
 }
 
+func NotePageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	noteRepo := NewNoteRepo()
+	note, err := noteRepo.Get(r.Context(), NoteID(id))
+
+	if errors.Is(err, ErrNoteNotFound) {
+		// The note may have been merged into another
+		to, err := noteRepo.GetRedirect(r.Context(), NoteID(id))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/notes/"+string(to), http.StatusMovedPermanently)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "note-page", note)
+}
+
 func ToggleHandler(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 
@@ -65,15 +91,23 @@ type StatusNotes struct {
 func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	r.ParseForm()
-	priorityLevel, err := strconv.Atoi(r.FormValue("priority"))
+	level, err := strconv.Atoi(r.FormValue("priority"))
 	if err != nil {
-		panic(err)
+		http.Error(w, "Priority must be a number", http.StatusBadRequest)
+		return
+	}
+
+	priorityLevel, err := GetPriorityLevel(level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	noteRepo := NewNoteRepo()
 
-	if err := noteRepo.SetPriority(r.Context(), NoteID(id), GetPriorityLevel(priorityLevel)); err != nil {
-		panic(err)
+	if err := noteRepo.SetPriority(r.Context(), NoteID(id), priorityLevel); err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
 	}
 
 	pageData := StatusPageData{Statuses: []StatusNotes{}}
@@ -104,6 +138,43 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	templates.RenderTemplate(w, "_todos", pageData)
 }
 
+// SetTagHandler sets the value of an exclusive tag type, such as a status, on
+// a note.
+func SetTagHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	r.ParseForm()
+
+	t, err := tags.NewTypeRepo().GetByName(r.Context(), vars["type"])
+	if errors.Is(err, tags.ErrUnknownType) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	noteRepo := NewNoteRepo()
+	err = noteRepo.SetExclusiveTag(r.Context(), NoteID(id), t.ID, r.FormValue("value"))
+	if errors.Is(err, tags.ErrValueNotAllowed) || errors.Is(err, tags.ErrNotExclusive) || errors.Is(err, tags.ErrEmptyTag) || errors.Is(err, tags.ErrTagTypeTaken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	note, err := noteRepo.Get(r.Context(), NoteID(id))
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "_note", note)
+}
+
 func EditHandler(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 
@@ -116,46 +187,138 @@ func EditHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	templates.RenderTemplate(w, "_edit", note)
+	templates.RenderTemplate(w, "_edit", EditForm{
+		ID:      note.ID,
+		Body:    note.Body,
+		Tags:    note.DisplayTags,
+		Version: note.Version,
+		Base:    note.Body,
+	})
+}
+
+// EditForm holds what the edit form needs to save a note. Base is the body
+// the edit started from, so that if someone else saves in the meantime the
+// two edits can be merged.
+type EditForm struct {
+	ID      NoteID
+	Body    string
+	Tags    string
+	Version int
+	Base    string
 }
 
-func ToggleTodoHandler(w http.ResponseWriter, r *http.Request) {
+// ConflictPageData is an edit form pre-filled with the result of merging a
+// stale edit into the saved note.
+type ConflictPageData struct {
+	EditForm
+	Saved string
+	Clean bool
+}
+
+// ToggleTaskHandler ticks or unticks a checklist item, addressed by the hash
+// of its content. If the note has changed since the page was loaded, and the
+// item can no longer be found or the version sent is out of date, nothing is
+// changed and the fresh note is returned with a 409.
+func ToggleTaskHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	id := vars["id"]
-	todoIndex := vars["todoIndex"]
+	id := NoteID(vars["id"])
+	r.ParseForm()
 
 	noteRepo := NewNoteRepo()
-	note, err := noteRepo.Get(r.Context(), NoteID(id))
-	ti, _ := strconv.Atoi(todoIndex)
-	newBody, _ := ToggleTodo(note.Body, ti)
-	err = noteRepo.Edit(r.Context(), NoteID(id), newBody, strings.Join(note.Tags, ","))
-
+	note, err := noteRepo.Get(r.Context(), id)
+	if errors.Is(err, ErrNoteNotFound) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	version, _ := strconv.Atoi(r.FormValue("version"))
+	if version != 0 && version != note.Version {
+		renderConflict(w, note)
+		return
+	}
+
+	newBody, err := ToggleTask(note.Body, vars["hash"])
+	if errors.Is(err, ErrTaskNotFound) {
+		renderConflict(w, note)
+		return
+	}
+
+	err = noteRepo.Edit(r.Context(), id, newBody, strings.Join(note.Tags, ","), note.Version)
+	if err != nil && !errors.Is(err, ErrStaleNote) {
 		logger.Logger.Println(err.Error())
 		web.HandleUnexpectedError(w, err)
 		return
 	}
 
-	note, _ = noteRepo.Get(r.Context(), NoteID(id))
+	stale := errors.Is(err, ErrStaleNote)
+	note, err = noteRepo.Get(r.Context(), id)
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	if stale {
+		renderConflict(w, note)
+		return
+	}
+
+	templates.RenderTemplate(w, "_note", note)
+}
 
+func renderConflict(w http.ResponseWriter, note Note) {
+	w.WriteHeader(http.StatusConflict)
 	templates.RenderTemplate(w, "_note", note)
 }
 
+// UpdateHandler saves an edit made in the web editor. If the note has been
+// saved elsewhere since the editor was opened, the edit is merged with the
+// saved note and handed back for review with a 409 instead of being saved.
 func UpdateHandler(w http.ResponseWriter, r *http.Request) {
-	id := mux.Vars(r)["id"]
+	id := NoteID(mux.Vars(r)["id"])
 	r.ParseForm()
 
 	body := r.FormValue("body")
 	tags := r.FormValue("tags")
+	version, _ := strconv.Atoi(r.FormValue("version"))
 
 	noteRepo := NewNoteRepo()
-	err := noteRepo.Edit(r.Context(), NoteID(id), body, tags)
+	err := noteRepo.Edit(r.Context(), id, body, tags, version)
+	if errors.Is(err, ErrStaleNote) {
+		saved, err := noteRepo.Get(r.Context(), id)
+		if err != nil {
+			web.HandleUnexpectedError(w, err)
+			return
+		}
+
+		merged, clean := Merge3(r.FormValue("base"), body, saved.Body)
+		w.WriteHeader(http.StatusConflict)
+		templates.RenderTemplate(w, "_conflict", ConflictPageData{
+			EditForm: EditForm{
+				ID:      id,
+				Body:    merged,
+				Tags:    tags,
+				Version: saved.Version,
+				Base:    saved.Body,
+			},
+			Saved: saved.Body,
+			Clean: clean,
+		})
+		return
+	}
+	if errors.Is(err, ErrNoteNotFound) {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
 		web.HandleUnexpectedError(w, err)
 		return
 	}
 
-	note, err := noteRepo.Get(r.Context(), NoteID(id))
+	note, err := noteRepo.Get(r.Context(), id)
 	if err != nil {
 		web.HandleUnexpectedError(w, err)
 		return
@@ -185,7 +348,24 @@ func CreateHandler(w http.ResponseWriter, r *http.Request) {
 	tags := r.FormValue("tags")
 
 	noteRepo := NewNoteRepo()
-	err := noteRepo.Add(r.Context(), body, tags)
+
+	// Unless the user has already said to save it anyway, check the note
+	// hasn't been written before and offer to merge it if it has
+	if r.FormValue("force") == "" {
+		duplicates, err := noteRepo.FindDuplicates(r.Context(), body)
+		if err != nil {
+			web.HandleUnexpectedError(w, err)
+			return
+		}
+
+		if len(duplicates) > 0 {
+			w.WriteHeader(http.StatusConflict)
+			templates.RenderTemplate(w, "_duplicates", duplicates)
+			return
+		}
+	}
+
+	_, err := noteRepo.Add(r.Context(), body, tags)
 
 	if err != nil {
 		web.HandleUnexpectedError(w, err)
@@ -0,0 +1,128 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/thrgamon/nous/web"
+)
+
+// duplicateThreshold is the trigram similarity above which a new note is
+// probably one that has already been written.
+const duplicateThreshold = 0.6
+
+// SimilarNote is an existing note that looks like a new one.
+type SimilarNote struct {
+	Note       Note
+	Similarity float64
+}
+
+// Percent is the similarity for display.
+func (s SimilarNote) Percent() int {
+	return int(s.Similarity * 100)
+}
+
+// FindDuplicates looks for notes whose text is nearly the same as body.
+func (rr NoteRepo) FindDuplicates(ctx context.Context, body string) ([]SimilarNote, error) {
+	rows, err := rr.db.Query(
+		ctx,
+		`SELECT id, similarity(body, $1) AS similarity
+FROM notes
+WHERE body % $1 AND similarity(body, $1) >= $2
+ORDER BY similarity DESC
+LIMIT 3`,
+		body,
+		duplicateThreshold,
+	)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var similar []SimilarNote
+	for rows.Next() {
+		var id int
+		var similarity float32
+		if err := rows.Scan(&id, &similarity); err != nil {
+			rr.logger.Println(err.Error())
+			return nil, err
+		}
+		similar = append(similar, SimilarNote{Note: Note{ID: NoteID(fmt.Sprint(id))}, Similarity: float64(similarity)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range similar {
+		note, err := rr.Get(ctx, similar[i].Note.ID)
+		if err != nil {
+			return nil, err
+		}
+		similar[i].Note = note
+	}
+
+	return similar, nil
+}
+
+// Append adds the body and tags of a draft to an existing note rather than
+// saving it as a note of its own. If the note is edited between reading and
+// saving it, the draft is added to the new version once more.
+func (rr NoteRepo) Append(ctx context.Context, noteId NoteID, body string, tagInput string) error {
+	err := rr.appendOnce(ctx, noteId, body, tagInput)
+	if errors.Is(err, ErrStaleNote) {
+		err = rr.appendOnce(ctx, noteId, body, tagInput)
+	}
+	return err
+}
+
+func (rr NoteRepo) appendOnce(ctx context.Context, noteId NoteID, body string, tagInput string) error {
+	note, err := rr.Get(ctx, noteId)
+	if err != nil {
+		return err
+	}
+
+	return rr.Edit(ctx, noteId, strings.TrimRight(note.Body, "\n")+"\n\n"+body, MergeTagInput(note.DisplayTags, tagInput), note.Version)
+}
+
+// MergeTagInput combines two comma separated tag inputs, dropping repeats.
+func MergeTagInput(a string, b string) string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, tag := range strings.Split(a+","+b, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		merged = append(merged, tag)
+	}
+	return strings.Join(merged, ", ")
+}
+
+// AppendHandler merges a draft from the editor into an existing note and
+// takes the user to it.
+func AppendHandler(w http.ResponseWriter, r *http.Request) {
+	id := NoteID(mux.Vars(r)["id"])
+	r.ParseForm()
+
+	err := NewNoteRepo().Append(r.Context(), id, r.FormValue("body"), r.FormValue("tags"))
+	if errors.Is(err, ErrNoteNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, ErrStaleNote) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	w.Header().Add("HX-Redirect", "/notes/"+string(id))
+}
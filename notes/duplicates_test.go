@@ -0,0 +1,13 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeTagInput(t *testing.T) {
+	assert.Equal(t, "work, project/nous, hannah", MergeTagInput("work, project/nous", "Work, hannah, "))
+	assert.Equal(t, "work", MergeTagInput("", "work"))
+	assert.Equal(t, "", MergeTagInput(" , ", ""))
+}
@@ -0,0 +1,126 @@
+package notes
+
+import (
+	"strings"
+)
+
+const (
+	conflictStart = "<<<<<<< your changes"
+	conflictSep   = "======="
+	conflictEnd   = ">>>>>>> saved note"
+)
+
+// Merge3 does a line based three way merge of two edits of the same base
+// text. Changes made on only one side are kept, and where both sides changed
+// the same lines differently the two versions are wrapped in conflict markers
+// and clean is false.
+func Merge3(base string, yours string, theirs string) (merged string, clean bool) {
+	o := splitLines(base)
+	a := splitLines(yours)
+	b := splitLines(theirs)
+
+	matchA := matchLines(o, a)
+	matchB := matchLines(o, b)
+
+	var out []string
+	clean = true
+	i, j, k := 0, 0, 0
+
+	for i < len(o) || j < len(a) || k < len(b) {
+		// Lines kept unchanged on both sides are copied straight through
+		if i < len(o) && matchA[i] == j && matchB[i] == k {
+			out = append(out, o[i])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+
+		// Otherwise find the next line both sides kept, everything before it
+		// is a changed chunk
+		next := i
+		for next < len(o) && (matchA[next] < 0 || matchB[next] < 0) {
+			next++
+		}
+
+		endA, endB := len(a), len(b)
+		if next < len(o) {
+			endA, endB = matchA[next], matchB[next]
+		}
+
+		chunkO, chunkA, chunkB := o[i:next], a[j:endA], b[k:endB]
+		switch {
+		case equalLines(chunkA, chunkO):
+			out = append(out, chunkB...)
+		case equalLines(chunkB, chunkO), equalLines(chunkA, chunkB):
+			out = append(out, chunkA...)
+		default:
+			clean = false
+			out = append(out, conflictStart)
+			out = append(out, chunkA...)
+			out = append(out, conflictSep)
+			out = append(out, chunkB...)
+			out = append(out, conflictEnd)
+		}
+
+		i, j, k = next, endA, endB
+	}
+
+	return strings.Join(out, "\n"), clean
+}
+
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// matchLines finds the longest common subsequence of lines between base and
+// other, returning for each base line the index of its match in other or -1.
+func matchLines(base []string, other []string) []int {
+	lengths := make([][]int, len(base)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(other)+1)
+	}
+
+	for i := len(base) - 1; i >= 0; i-- {
+		for j := len(other) - 1; j >= 0; j-- {
+			if base[i] == other[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	matches := make([]int, len(base))
+	i, j := 0, 0
+	for i < len(base) {
+		switch {
+		case j < len(other) && base[i] == other[j]:
+			matches[i] = j
+			i, j = i+1, j+1
+		case j < len(other) && lengths[i][j+1] > lengths[i+1][j]:
+			j++
+		default:
+			matches[i] = -1
+			i++
+		}
+	}
+
+	return matches
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge3Clean(t *testing.T) {
+	base := "# Standup\n- [ ] deploy\n- [ ] review PR\nnotes"
+	yours := "# Standup\n- [x] deploy\n- [ ] review PR\nnotes"
+	theirs := "# Standup\n- [ ] deploy\n- [ ] review PR\n- [ ] write docs\nnotes"
+
+	merged, clean := Merge3(base, yours, theirs)
+	assert.True(t, clean)
+	assert.Equal(t, "# Standup\n- [x] deploy\n- [ ] review PR\n- [ ] write docs\nnotes", merged)
+}
+
+func TestMerge3SameChange(t *testing.T) {
+	merged, clean := Merge3("a\nb\nc", "a\nB\nc", "a\nB\nc")
+	assert.True(t, clean)
+	assert.Equal(t, "a\nB\nc", merged)
+}
+
+func TestMerge3Conflict(t *testing.T) {
+	merged, clean := Merge3("a\nb\nc", "a\nmine\nc", "a\ntheirs\nc")
+	assert.False(t, clean)
+	assert.Equal(t, "a\n<<<<<<< your changes\nmine\n=======\ntheirs\n>>>>>>> saved note\nc", merged)
+}
+
+func TestMerge3Deletions(t *testing.T) {
+	merged, clean := Merge3("a\nb\nc\nd", "a\nc\nd", "a\nb\nc\nd\ne")
+	assert.True(t, clean)
+	assert.Equal(t, "a\nc\nd\ne", merged)
+
+	merged, clean = Merge3("", "yours", "theirs")
+	assert.False(t, clean)
+	assert.Equal(t, "<<<<<<< your changes\nyours\n=======\ntheirs\n>>>>>>> saved note", merged)
+}
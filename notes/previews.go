@@ -0,0 +1,47 @@
+package notes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/thrgamon/nous/links"
+	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/url"
+	"github.com/thrgamon/nous/web"
+)
+
+// HasLinks is whether the note's body links anywhere, so it's worth loading
+// previews for.
+func (n Note) HasLinks() bool {
+	return len(url.FindURLs(n.Body)) > 0
+}
+
+// PreviewsHandler renders a preview card for each link in a note that has
+// been fetched.
+func PreviewsHandler(w http.ResponseWriter, r *http.Request) {
+	note, err := NewNoteRepo().Get(r.Context(), NoteID(mux.Vars(r)["id"]))
+	if errors.Is(err, ErrNoteNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	found, err := links.NewLinkRepo().GetByNote(r.Context(), string(note.ID))
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	var previews []links.Link
+	for _, link := range found {
+		if link.Title != "" || link.Description != "" {
+			previews = append(previews, link)
+		}
+	}
+
+	templates.RenderTemplate(w, "_previews", previews)
+}
@@ -0,0 +1,232 @@
+package notes
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/thrgamon/nous/tags"
+	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/web"
+)
+
+// relatedLimit is the most related notes shown for a note.
+const relatedLimit = 10
+
+// Weights given to each kind of connection between two notes. Mentioning the
+// same person is a stronger signal than sharing a broad tag, and text
+// similarity is a ts_rank that rarely goes above one.
+const (
+	sharedTagWeight    = 2
+	sharedPersonWeight = 3
+	sharedDomainWeight = 1.5
+	textRankWeight     = 10
+)
+
+// RelatedNote is a note connected to another one, with the reasons why.
+type RelatedNote struct {
+	Note    Note     `json:"note"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// relatedMatch is what two notes have in common.
+type relatedMatch struct {
+	NoteID   NoteID
+	Tags     []string
+	People   []string
+	Domains  []string
+	TextRank float64
+}
+
+// score weighs up what two notes share and explains it.
+func (m relatedMatch) score() (float64, []string) {
+	var score float64
+	var reasons []string
+
+	if len(m.Tags) > 0 {
+		score += sharedTagWeight * float64(len(m.Tags))
+		reasons = append(reasons, "tagged "+strings.Join(m.Tags, ", "))
+	}
+	if len(m.People) > 0 {
+		score += sharedPersonWeight * float64(len(m.People))
+		reasons = append(reasons, "mentions @"+strings.Join(m.People, ", @"))
+	}
+	if len(m.Domains) > 0 {
+		score += sharedDomainWeight * float64(len(m.Domains))
+		reasons = append(reasons, "links to "+strings.Join(m.Domains, ", "))
+	}
+	if m.TextRank > 0 {
+		score += textRankWeight * m.TextRank
+		reasons = append(reasons, fmt.Sprintf("similar wording (%.0f%%)", math.Min(m.TextRank, 1)*100))
+	}
+
+	return score, reasons
+}
+
+// rankRelated scores matches and keeps the best, strongest first.
+func rankRelated(matches []relatedMatch, limit int) []RelatedNote {
+	related := make([]RelatedNote, 0, len(matches))
+	for _, match := range matches {
+		score, reasons := match.score()
+		if score == 0 {
+			continue
+		}
+		related = append(related, RelatedNote{Note: Note{ID: match.NoteID}, Score: score, Reasons: reasons})
+	}
+
+	sort.SliceStable(related, func(i, j int) bool {
+		return related[i].Score > related[j].Score
+	})
+
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	return related
+}
+
+// lexemeQuery matches any of the lexemes as they are, without stemming them
+// again. Quotes and backslashes are doubled, as tsquery input expects.
+func lexemeQuery(lexemes []string) string {
+	quoted := make([]string, len(lexemes))
+	for i, lexeme := range lexemes {
+		lexeme = strings.ReplaceAll(lexeme, `\`, `\\`)
+		quoted[i] = "'" + strings.ReplaceAll(lexeme, "'", "''") + "'"
+	}
+	return strings.Join(quoted, " | ")
+}
+
+// GetRelated finds the notes most connected to a note through shared tags,
+// mentions of the same people, links to the same sites and similar text.
+func (rr NoteRepo) GetRelated(ctx context.Context, noteId NoteID) ([]RelatedNote, error) {
+	// The lexemes used most in the note, already stemmed
+	var lexemes []string
+	err := rr.db.QueryRow(
+		ctx,
+		`SELECT COALESCE(array_agg(lexeme), '{}')
+FROM (
+	SELECT lexeme FROM note_search, unnest(note_search.doc)
+	WHERE note_search.id = $1
+	ORDER BY array_length(positions, 1) DESC NULLS LAST, lexeme
+	LIMIT 10
+) words`,
+		noteId,
+	).Scan(&lexemes)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return nil, err
+	}
+
+	rows, err := rr.db.Query(
+		ctx,
+		`WITH target_tags AS (
+	SELECT tags.id, tags.tag, tags.type
+	FROM notetags JOIN tags ON tags.id = notetags.tag_id
+	WHERE notetags.note_id = $1
+), shared_tags AS (
+	SELECT
+		notetags.note_id,
+		array_agg(t.tag ORDER BY t.tag) FILTER (WHERE t.type <> $2) AS tags,
+		array_agg(t.tag ORDER BY t.tag) FILTER (WHERE t.type = $2) AS people
+	FROM notetags JOIN target_tags t ON t.id = notetags.tag_id
+	WHERE notetags.note_id <> $1
+	GROUP BY notetags.note_id
+), shared_domains AS (
+	SELECT note_links_urls.note_id, array_agg(DISTINCT note_links_urls.domain ORDER BY note_links_urls.domain) AS domains
+	FROM note_links_urls
+	WHERE note_links_urls.note_id <> $1
+		AND note_links_urls.domain IN (SELECT domain FROM note_links_urls WHERE note_id = $1)
+	GROUP BY note_links_urls.note_id
+), target_words AS (
+	SELECT NULLIF($3, '')::tsquery AS query
+), text_matches AS (
+	SELECT note_search.id AS note_id, ts_rank(note_search.doc, target_words.query) AS rank
+	FROM note_search, target_words
+	WHERE note_search.id <> $1 AND note_search.doc @@ target_words.query
+	ORDER BY rank DESC
+	LIMIT 20
+)
+SELECT
+	candidates.note_id,
+	COALESCE(shared_tags.tags, '{}'),
+	COALESCE(shared_tags.people, '{}'),
+	COALESCE(shared_domains.domains, '{}'),
+	COALESCE(text_matches.rank, 0)
+FROM (
+	SELECT note_id FROM shared_tags
+	UNION SELECT note_id FROM shared_domains
+	UNION SELECT note_id FROM text_matches
+) candidates
+	LEFT JOIN shared_tags ON shared_tags.note_id = candidates.note_id
+	LEFT JOIN shared_domains ON shared_domains.note_id = candidates.note_id
+	LEFT JOIN text_matches ON text_matches.note_id = candidates.note_id`,
+		noteId,
+		tags.Person,
+		lexemeQuery(lexemes),
+	)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []relatedMatch
+	for rows.Next() {
+		var id int
+		var match relatedMatch
+		var rank float32
+		if err := rows.Scan(&id, &match.Tags, &match.People, &match.Domains, &rank); err != nil {
+			rr.logger.Println(err.Error())
+			return nil, err
+		}
+		match.NoteID = NoteID(fmt.Sprint(id))
+		match.TextRank = float64(rank)
+		matches = append(matches, match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	related := rankRelated(matches, relatedLimit)
+	ids := []string{}
+	for _, rn := range related {
+		ids = append(ids, string(rn.Note.ID))
+	}
+
+	notes, err := rr.getAll(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[NoteID]Note)
+	for _, note := range notes {
+		byId[note.ID] = note
+	}
+	found := related[:0]
+	for _, rn := range related {
+		// Skip notes deleted since they were matched
+		if note, ok := byId[rn.Note.ID]; ok {
+			rn.Note = note
+			found = append(found, rn)
+		}
+	}
+
+	return found, nil
+}
+
+// RelatedHandler renders the related notes panel for a note.
+func RelatedHandler(w http.ResponseWriter, r *http.Request) {
+	id := NoteID(mux.Vars(r)["id"])
+
+	related, err := NewNoteRepo().GetRelated(r.Context(), id)
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "_related", related)
+}
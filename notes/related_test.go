@@ -0,0 +1,37 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankRelated(t *testing.T) {
+	matches := []relatedMatch{
+		{NoteID: "1", TextRank: 0.1},
+		{NoteID: "2", Tags: []string{"project/nous"}, People: []string{"hannah"}},
+		{NoteID: "3", Domains: []string{"github.com", "go.dev"}},
+		{NoteID: "4"},
+	}
+
+	related := rankRelated(matches, 2)
+	assert.Len(t, related, 2)
+
+	assert.Equal(t, NoteID("2"), related[0].Note.ID)
+	assert.Equal(t, 5.0, related[0].Score)
+	assert.Equal(t, []string{"tagged project/nous", "mentions @hannah"}, related[0].Reasons)
+
+	assert.Equal(t, NoteID("3"), related[1].Note.ID)
+	assert.Equal(t, []string{"links to github.com, go.dev"}, related[1].Reasons)
+}
+
+func TestRelatedScoreExplainsText(t *testing.T) {
+	score, reasons := relatedMatch{TextRank: 0.25}.score()
+	assert.Equal(t, 2.5, score)
+	assert.Equal(t, []string{"similar wording (25%)"}, reasons)
+}
+
+func TestLexemeQuery(t *testing.T) {
+	assert.Equal(t, `'deploy' | 'o''brien' | 'c:\\temp'`, lexemeQuery([]string{"deploy", "o'brien", `c:\temp`}))
+	assert.Equal(t, "", lexemeQuery(nil))
+}
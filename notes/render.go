@@ -0,0 +1,238 @@
+package notes
+
+import (
+	"bytes"
+	"html/template"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var markdown = goldmark.New(
+	// GFM, with checkboxes that know which task they are
+	goldmark.WithExtensions(extension.Linkify, extension.Table, extension.Strikethrough, taskList{}),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(archivedLinks{}, 1000)),
+	),
+	goldmark.WithRendererOptions(
+		html.WithHardWraps(),
+		html.WithXHTML(),
+	),
+)
+
+// renderCacheSize bounds how many rendered notes are kept in memory.
+const renderCacheSize = 10000
+
+var rendered = renderCache{entries: map[NoteID]renderedNote{}}
+
+type renderedNote struct {
+	version  int
+	archived string
+	html     template.HTML
+}
+
+// renderCache holds the HTML for notes that have been displayed, keyed by
+// note and checked against the version so an edit is never served stale. The
+// archived copies linked to are checked too, as links die without an edit.
+type renderCache struct {
+	mu      sync.RWMutex
+	entries map[NoteID]renderedNote
+}
+
+func (c *renderCache) get(id NoteID, version int, archived string) (template.HTML, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[id]
+	if !ok || entry.version != version || entry.archived != archived {
+		return "", false
+	}
+	return entry.html, true
+}
+
+func (c *renderCache) put(id NoteID, version int, archived string, html template.HTML) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[id]; !ok && len(c.entries) >= renderCacheSize {
+		// Evict an arbitrary note, anything evicted is just rendered again
+		for evict := range c.entries {
+			delete(c.entries, evict)
+			break
+		}
+	}
+	c.entries[id] = renderedNote{version: version, archived: archived, html: html}
+}
+
+func (c *renderCache) invalidate(id NoteID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, id)
+}
+
+// DisplayBody is the note body rendered as HTML. Notes are only rendered when
+// a template asks for them, and the result is cached until the note is edited.
+// Links that have died point to their archived copies.
+func (n Note) DisplayBody() template.HTML {
+	if n.ID == "" {
+		return renderMarkdown(n.Body, n.ArchivedLinks)
+	}
+
+	archived := archivedKey(n.ArchivedLinks)
+	if html, ok := rendered.get(n.ID, n.Version, archived); ok {
+		return html
+	}
+
+	html := renderMarkdown(n.Body, n.ArchivedLinks)
+	rendered.put(n.ID, n.Version, archived, html)
+	return html
+}
+
+// archivedKey describes the archived copies a note links to, for telling
+// whether its cached HTML is out of date.
+func archivedKey(archived map[string]string) string {
+	var pairs []string
+	for url, archiveURL := range archived {
+		pairs = append(pairs, url+" "+archiveURL)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\n")
+}
+
+var archivedLinksKey = parser.NewContextKey()
+
+func renderMarkdown(body string, archived map[string]string) template.HTML {
+	context := parser.NewContext()
+	context.Set(archivedLinksKey, archived)
+
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(body), &buf, parser.WithContext(context)); err != nil {
+		panic(err)
+	}
+	return template.HTML(buf.String())
+}
+
+// archivedLinks points links to dead pages at the archived copies given in
+// the parser context, as they are in link previews. URLs linked by writing
+// them out are swapped for a link with the same text.
+type archivedLinks struct{}
+
+func (archivedLinks) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	archived, _ := pc.Get(archivedLinksKey).(map[string]string)
+	if len(archived) == 0 {
+		return
+	}
+
+	source := reader.Source()
+	var autoLinks []*ast.AutoLink
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch link := n.(type) {
+		case *ast.Link:
+			url := string(link.Destination)
+			if archiveURL, ok := archived[url]; ok {
+				link.Destination = []byte(archiveURL)
+				link.Title = []byte("Archived copy of " + url)
+			}
+		case *ast.AutoLink:
+			if link.AutoLinkType == ast.AutoLinkURL {
+				autoLinks = append(autoLinks, link)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	// Replaced after the walk, which can't carry on past a node taken out
+	for _, autoLink := range autoLinks {
+		url := string(autoLink.URL(source))
+		archiveURL, ok := archived[url]
+		if !ok {
+			continue
+		}
+
+		link := ast.NewLink()
+		link.Destination = []byte(archiveURL)
+		link.Title = []byte("Archived copy of " + url)
+		link.AppendChild(link, ast.NewString(autoLink.Label(source)))
+		autoLink.Parent().ReplaceChild(autoLink.Parent(), autoLink, link)
+	}
+}
+
+// taskList is goldmark's task list extension with each checkbox rendered
+// with the hash of its task, for the page to toggle it by.
+type taskList struct{}
+
+func (taskList) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithInlineParsers(util.Prioritized(taskCheckBoxParser{extension.NewTaskCheckBoxParser()}, 0)),
+		parser.WithASTTransformers(util.Prioritized(taskHashes{}, 1000)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&taskCheckBoxRenderer{Config: html.NewConfig()}, 500),
+	))
+}
+
+var taskHash = []byte("data-task-hash")
+
+// taskHashes records the hash of each checkbox's task on it.
+type taskHashes struct{}
+
+func (taskHashes) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	var boxes []ast.Node
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering && n.Kind() == extast.KindTaskCheckBox {
+			boxes = append(boxes, n)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for i, task := range findTasks(doc, reader.Source()) {
+		boxes[i].SetAttribute(taskHash, []byte(task.Hash))
+	}
+}
+
+type taskCheckBoxRenderer struct {
+	html.Config
+}
+
+func (r *taskCheckBoxRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(extast.KindTaskCheckBox, r.render)
+}
+
+func (r *taskCheckBoxRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	if node.(*extast.TaskCheckBox).IsChecked {
+		w.WriteString(`<input checked="" disabled="" type="checkbox"`)
+	} else {
+		w.WriteString(`<input disabled="" type="checkbox"`)
+	}
+	if hash, ok := node.Attribute(taskHash); ok {
+		w.WriteString(` data-task-hash="`)
+		w.Write(util.EscapeHTML(hash.([]byte)))
+		w.WriteString(`"`)
+	}
+	if r.XHTML {
+		w.WriteString(" /> ")
+	} else {
+		w.WriteString("> ")
+	}
+	return ast.WalkContinue, nil
+}
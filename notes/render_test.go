@@ -0,0 +1,93 @@
+package notes
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+func TestDisplayBodyFollowsVersion(t *testing.T) {
+	note := Note{ID: "render-test", Body: "*old*", Version: 1}
+	assert.Contains(t, string(note.DisplayBody()), "<em>old</em>")
+
+	note.Body = "**new**"
+	note.Version = 2
+	assert.Contains(t, string(note.DisplayBody()), "<strong>new</strong>")
+
+	rendered.invalidate(note.ID)
+	_, ok := rendered.get(note.ID, note.Version, "")
+	assert.False(t, ok)
+}
+
+func TestDisplayBodyLinksArchivedCopies(t *testing.T) {
+	note := Note{
+		ID:      "archived-test",
+		Version: 1,
+		Body:    "[gone](https://example.com/gone) and https://example.com/dead, but https://example.com/alive",
+	}
+	assert.Contains(t, string(note.DisplayBody()), `href="https://example.com/dead"`)
+
+	note.ArchivedLinks = map[string]string{
+		"https://example.com/gone": "/snapshots/1",
+		"https://example.com/dead": "https://web.archive.org/web/2022/https://example.com/dead",
+	}
+	html := string(note.DisplayBody())
+	assert.Contains(t, html, `<a href="/snapshots/1" title="Archived copy of https://example.com/gone">gone</a>`)
+	assert.Contains(t, html, `<a href="https://web.archive.org/web/2022/https://example.com/dead" title="Archived copy of https://example.com/dead">https://example.com/dead</a>,`)
+	assert.Contains(t, html, `<a href="https://example.com/alive">https://example.com/alive</a>`)
+}
+
+const benchmarkNotes = 5000
+
+func listing() []Note {
+	notes := make([]Note, benchmarkNotes)
+	for i := range notes {
+		notes[i] = Note{
+			ID:      NoteID(fmt.Sprint(i)),
+			Version: 1,
+			Body:    fmt.Sprintf("# Note %d\nSome *text* with a [link](https://example.com/%d)\n- [ ] a task\n- [x] another", i, i),
+		}
+	}
+	return notes
+}
+
+// BenchmarkListingEager renders every note on every read, as parseData used to.
+func BenchmarkListingEager(b *testing.B) {
+	notes := listing()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		md := goldmark.New(
+			goldmark.WithExtensions(extension.GFM),
+			goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+			goldmark.WithRendererOptions(html.WithHardWraps(), html.WithXHTML()),
+		)
+		for _, note := range notes {
+			var buf bytes.Buffer
+			if err := md.Convert([]byte(note.Body), &buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkListingCached renders a listing whose notes have been shown before.
+func BenchmarkListingCached(b *testing.B) {
+	notes := listing()
+	for _, note := range notes {
+		note.DisplayBody()
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, note := range notes {
+			note.DisplayBody()
+		}
+	}
+}
@@ -1,10 +1,9 @@
 package notes
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"html/template"
 	"log"
 	"strings"
 	"time"
@@ -12,25 +11,27 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/thrgamon/nous/database"
+	"github.com/thrgamon/nous/links"
 	"github.com/thrgamon/nous/logger"
+	"github.com/thrgamon/nous/tags"
 	"github.com/thrgamon/nous/url"
-	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
 )
 
 type NoteID string
 
 type Note struct {
-	ID       NoteID        `json:"id"`
-	Body     string        `json:"body"`
-	Tags     []string      `json:"tags"`
-	Done     bool          `json:"done"`
-	Priority PriorityLevel `json:"priority"`
+	ID         NoteID        `json:"id"`
+	Body       string        `json:"body"`
+	Tags       []string      `json:"tags"`
+	Done       bool          `json:"done"`
+	Priority   PriorityLevel `json:"priority"`
+	InsertedAt time.Time     `json:"inserted_at"`
+	Version    int           `json:"version"`
 
-	DisplayBody template.HTML
 	DisplayTags string
+	// ArchivedLinks maps the URLs of dead links in the body to their archived
+	// copies, so the rendered note links to those instead.
+	ArchivedLinks map[string]string `json:"-"`
 }
 
 type NoteRepo struct {
@@ -38,13 +39,6 @@ type NoteRepo struct {
 	logger *log.Logger
 }
 
-type TagType int
-
-const (
-	Category TagType = iota + 1
-	TaskPriority
-)
-
 type PriorityLevel string
 
 const (
@@ -55,18 +49,22 @@ const (
 	Unprioritised      PriorityLevel = "Unprioritised"
 )
 
-func GetPriorityLevel(level int) PriorityLevel {
+var ErrInvalidPriority = errors.New("invalid priority level given")
+var ErrNoteNotFound = errors.New("note not found")
+var ErrStaleNote = errors.New("note has been changed since it was loaded")
+
+func GetPriorityLevel(level int) (PriorityLevel, error) {
 	switch level {
 	case 1:
-		return ImportantAndUrgent
+		return ImportantAndUrgent, nil
 	case 2:
-		return Important
+		return Important, nil
 	case 3:
-		return Urgent
+		return Urgent, nil
 	case 4:
-		return Someday
+		return Someday, nil
 	}
-	panic("Invalid priority level given")
+	return Unprioritised, fmt.Errorf("%w: %d", ErrInvalidPriority, level)
 }
 
 func NewNoteRepo() *NoteRepo {
@@ -75,7 +73,35 @@ func NewNoteRepo() *NoteRepo {
 	return &NoteRepo{db: db, logger: logger}
 }
 
+const selectNote = `SELECT
+      notes.id,
+      body,
+      tags,
+      done,
+      inserted_at,
+      version,
+      'Unprioritised'
+    FROM
+      notes
+      join note_search on notes.id = note_search.id
+    WHERE
+      notes.id = $1`
+
 func (rr NoteRepo) Get(ctx context.Context, id NoteID) (Note, error) {
+	rows, err := rr.db.Query(ctx, selectNote, id)
+
+	defer rows.Close()
+
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return Note{}, err
+	}
+
+	return rr.parseOne(ctx, rows)
+}
+
+// getAll reads the notes with the given ids, in no particular order.
+func (rr NoteRepo) getAll(ctx context.Context, ids []string) ([]Note, error) {
 	rows, err := rr.db.Query(
 		ctx,
 		`SELECT
@@ -84,28 +110,48 @@ func (rr NoteRepo) Get(ctx context.Context, id NoteID) (Note, error) {
       tags,
       done,
       inserted_at,
+      version,
       'Unprioritised'
     FROM
       notes
       join note_search on notes.id = note_search.id
     WHERE
-      notes.id = $1;`,
-		id,
+      notes.id::text = ANY($1)`,
+		ids,
 	)
-
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return nil, err
+	}
 	defer rows.Close()
 
+	return rr.parseData(ctx, rows)
+}
+
+// getForUpdate reads a note inside a transaction, locking it until the
+// transaction ends so nothing else can change it in the meantime.
+func (rr NoteRepo) getForUpdate(ctx context.Context, tx pgx.Tx, id NoteID) (Note, error) {
+	rows, err := tx.Query(ctx, selectNote+" FOR UPDATE OF notes", id)
 	if err != nil {
 		rr.logger.Println(err.Error())
 		return Note{}, err
 	}
+	defer rows.Close()
 
-	notes, err := rr.parseData(rows)
+	return rr.parseOne(ctx, rows)
+}
+
+func (rr NoteRepo) parseOne(ctx context.Context, rows pgx.Rows) (Note, error) {
+	notes, err := rr.parseData(ctx, rows)
 	if err != nil {
 		rr.logger.Println(err.Error())
 		return Note{}, err
 	}
 
+	if len(notes) == 0 {
+		return Note{}, ErrNoteNotFound
+	}
+
 	return notes[0], nil
 }
 
@@ -125,6 +171,7 @@ SELECT
 	tags,
 	done,
 	inserted_at,
+	version,
 	(
 		SELECT
 			coalesce((
@@ -146,7 +193,7 @@ WHERE
 		WHERE
 			active = TRUE)::text] <@ tags::text[]
 	AND done = FALSE`,
-		TaskPriority,
+		tags.TaskPriority,
 	)
 
 	defer rows.Close()
@@ -155,7 +202,7 @@ WHERE
 		return notes, err
 	}
 
-	return rr.parseData(rows)
+	return rr.parseData(ctx, rows)
 }
 
 func (rr NoteRepo) GetByTags(ctx context.Context, tags string) ([]Note, error) {
@@ -168,6 +215,7 @@ func (rr NoteRepo) GetByTags(ctx context.Context, tags string) ([]Note, error) {
       tags,
       done,
       inserted_at,
+      version,
       'Unprioritised'
     FROM
       notes
@@ -185,52 +233,193 @@ func (rr NoteRepo) GetByTags(ctx context.Context, tags string) ([]Note, error) {
 		return notes, err
 	}
 
-	return rr.parseData(rows)
+	return rr.parseData(ctx, rows)
+}
+
+// GetForBoard returns the notes that belong on a board for an exclusive tag
+// type: open todos and anything already carrying a value of that type. An
+// empty context includes notes from every context.
+func (rr NoteRepo) GetForBoard(ctx context.Context, tagType tags.TagType, context string) ([]Note, error) {
+	var notes []Note
+	rows, err := rr.db.Query(
+		ctx,
+		`SELECT
+      notes.id,
+      body,
+      tags,
+      done,
+      inserted_at,
+      version,
+      'Unprioritised'
+    FROM
+      notes
+	    JOIN note_search ON notes.id = note_search.id
+  	WHERE
+    ($2 = '' OR $2 = ANY(tags::text[]))
+    AND (
+      ('todo' = ANY(tags::text[]) AND done = false)
+      OR EXISTS (
+        SELECT 1 FROM notetags JOIN tags t ON t.id = notetags.tag_id
+        WHERE notetags.note_id = notes.id AND t.type = $1
+      )
+    )
+    ORDER BY
+      notes.id DESC`,
+		tagType,
+		context,
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		return notes, err
+	}
+
+	return rr.parseData(ctx, rows)
+}
+
+// GetTagValues maps every note carrying a tag of the given type to the value
+// the tag stands for.
+func (rr NoteRepo) GetTagValues(ctx context.Context, t tags.Type) (map[NoteID]string, error) {
+	values := make(map[NoteID]string)
+	rows, err := rr.db.Query(
+		ctx,
+		"SELECT notetags.note_id, tags.tag FROM notetags JOIN tags ON tags.id = notetags.tag_id WHERE tags.type = $1",
+		t.ID,
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return values, err
+	}
+
+	for rows.Next() {
+		var id int
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			rr.logger.Println(err.Error())
+			return values, err
+		}
+		values[NoteID(fmt.Sprint(id))] = t.ValueOf(tag)
+	}
+
+	return values, rows.Err()
+}
+
+// GetByTag returns every note carrying a tag, including the ones that are
+// done.
+func (rr NoteRepo) GetByTag(ctx context.Context, tag string) ([]Note, error) {
+	var notes []Note
+	rows, err := rr.db.Query(
+		ctx,
+		`SELECT
+      notes.id,
+      body,
+      tags,
+      done,
+      inserted_at,
+      version,
+      'Unprioritised'
+    FROM
+      notes
+	    JOIN note_search ON notes.id = note_search.id
+  	WHERE
+    $1 = ANY(tags::text[])
+    ORDER BY
+      notes.id DESC`,
+		tags.Normalise(tag),
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		return notes, err
+	}
+
+	return rr.parseData(ctx, rows)
 }
 
-func (rr NoteRepo) parseData(rows pgx.Rows) ([]Note, error) {
-	md := goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			html.WithHardWraps(),
-			html.WithXHTML(),
-		),
+// GetByTagTree behaves like GetByTags except that each tag also matches its
+// descendants, so filtering by `project/nous` includes `project/nous/api`.
+func (rr NoteRepo) GetByTagTree(ctx context.Context, tagInput string) ([]Note, error) {
+	var notes []Note
+	var wanted []string
+	for _, tag := range strings.Split(tagInput, ",") {
+		if tag = tags.Normalise(tag); tag != "" {
+			wanted = append(wanted, tag)
+		}
+	}
+
+	rows, err := rr.db.Query(
+		ctx,
+		`SELECT
+      notes.id,
+      body,
+      tags,
+      done,
+      inserted_at,
+      version,
+      'Unprioritised'
+    FROM
+      notes
+	    JOIN note_search ON notes.id = note_search.id
+  	WHERE
+    (
+      $1::text[] <@ tags::text[]
+      OR NOT EXISTS (
+        SELECT 1 FROM unnest($1::text[]) AS wanted
+        WHERE NOT EXISTS (
+          SELECT 1 FROM unnest(tags::text[]) AS tag
+          WHERE tag = wanted OR left(tag, length(wanted) + 1) = wanted || '/'
+        )
+      )
+    ) AND done=false
+    ORDER BY
+      notes.id DESC`,
+		wanted,
 	)
+
+	defer rows.Close()
+
+	if err != nil {
+		return notes, err
+	}
+
+	return rr.parseData(ctx, rows)
+}
+
+func (rr NoteRepo) parseData(ctx context.Context, rows pgx.Rows) ([]Note, error) {
 	var notes []Note
 	var err error
 
 	for rows.Next() {
-		var buf bytes.Buffer
 		var id int
 		var body string
 		var tags []string
 		var done bool
 		var insertedAt time.Time
+		var version int
 		var priorityLevel string
-		err := rows.Scan(&id, &body, &tags, &done, &insertedAt, &priorityLevel)
+		err := rows.Scan(&id, &body, &tags, &done, &insertedAt, &version, &priorityLevel)
 
 		if err != nil {
 			rr.logger.Println(err.Error())
 			return notes, err
 		}
 
-		if err := md.Convert([]byte(body), &buf); err != nil {
-			panic(err)
-		}
-
 		notes = append(
 			notes,
 			Note{
-				ID:       NoteID(fmt.Sprint(id)),
-				Body:     body,
-				Tags:     tags,
-				Done:     done,
-				Priority: PriorityLevel(priorityLevel),
+				ID:         NoteID(fmt.Sprint(id)),
+				Body:       body,
+				Tags:       tags,
+				Done:       done,
+				Priority:   PriorityLevel(priorityLevel),
+				InsertedAt: insertedAt,
+				Version:    version,
 
-				DisplayBody: template.HTML(buf.String()),
 				DisplayTags: strings.Join(tags, ", "),
 			},
 		)
@@ -241,6 +430,29 @@ func (rr NoteRepo) parseData(rows pgx.Rows) ([]Note, error) {
 		return notes, err
 	}
 
+	return rr.withArchivedLinks(ctx, notes)
+}
+
+// withArchivedLinks fills in the archived copies of the dead links in each
+// note.
+func (rr NoteRepo) withArchivedLinks(ctx context.Context, notes []Note) ([]Note, error) {
+	if len(notes) == 0 {
+		return notes, nil
+	}
+
+	var ids []string
+	for _, note := range notes {
+		ids = append(ids, string(note.ID))
+	}
+
+	archived, err := links.NewLinkRepo().GetArchivedCopies(ctx, ids)
+	if err != nil {
+		return notes, err
+	}
+
+	for i := range notes {
+		notes[i].ArchivedLinks = archived[string(notes[i].ID)]
+	}
 	return notes, nil
 }
 
@@ -254,6 +466,7 @@ func (rr NoteRepo) GetForReview(ctx context.Context) ([]Note, error) {
       tags,
       done,
       notes.inserted_at,
+      version,
       'Unprioritised'
     FROM
       notes
@@ -270,7 +483,7 @@ func (rr NoteRepo) GetForReview(ctx context.Context) ([]Note, error) {
 		return notes, err
 	}
 
-	return rr.parseData(rows)
+	return rr.parseData(ctx, rows)
 }
 func (rr NoteRepo) GetAllBetween(ctx context.Context, from time.Time, to time.Time) ([]Note, error) {
 	var notes []Note
@@ -282,6 +495,7 @@ func (rr NoteRepo) GetAllBetween(ctx context.Context, from time.Time, to time.Ti
       tags,
       done,
       inserted_at,
+      version,
       'Unprioritised'
     FROM
       notes
@@ -300,7 +514,7 @@ func (rr NoteRepo) GetAllBetween(ctx context.Context, from time.Time, to time.Ti
 		return notes, err
 	}
 
-	return rr.parseData(rows)
+	return rr.parseData(ctx, rows)
 }
 
 func (rr NoteRepo) ToggleDone(ctx context.Context, noteId NoteID) (bool, error) {
@@ -315,127 +529,363 @@ func (rr NoteRepo) MarkReviewed(ctx context.Context, noteId NoteID) error {
 }
 
 func (rr NoteRepo) Delete(ctx context.Context, noteId NoteID) error {
-	error := rr.withTransaction(ctx, func() error {
-		_, err := rr.db.Exec(ctx, "DELETE FROM notetags WHERE note_id = $1", noteId)
+	error := rr.withTransaction(ctx, func(tx pgx.Tx) error {
+		// Forgotten first, so the links it mentioned are recounted
+		if err := rr.saveLinks(ctx, tx, noteId, ""); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, "DELETE FROM notetags WHERE note_id = $1", noteId)
 		if err != nil {
 			rr.logger.Println(err.Error())
 			return err
 		}
 
-		_, err = rr.db.Exec(ctx, "DELETE FROM notes WHERE id = $1", noteId)
+		_, err = tx.Exec(ctx, "DELETE FROM notes WHERE id = $1", noteId)
 		return err
 	})
+
+	if error == nil {
+		rendered.invalidate(noteId)
+	}
 	return error
 }
 
-func (rr NoteRepo) Add(ctx context.Context, body string, tags string) error {
-	error := rr.withTransaction(ctx, func() error {
-		var noteId int
-		err := rr.db.QueryRow(ctx, "INSERT INTO notes (body) VALUES ($1) RETURNING id", body).Scan(&noteId)
+func (rr NoteRepo) Add(ctx context.Context, body string, tagInput string) (NoteID, error) {
+	var noteId NoteID
+	error := rr.withTransaction(ctx, func(tx pgx.Tx) error {
+		var id int
+		err := tx.QueryRow(ctx, "INSERT INTO notes (body) VALUES ($1) RETURNING id", body).Scan(&id)
 
 		if err != nil {
 			rr.logger.Println(err.Error())
 			return err
 		}
 
-		combinedTags := assembleTags(body, tags)
+		noteId = NoteID(fmt.Sprint(id))
+		if err := rr.saveTasks(ctx, tx, noteId, body); err != nil {
+			return err
+		}
+
+		if err := rr.addNoteTags(ctx, tx, noteId, body, tagInput); err != nil {
+			return err
+		}
 
-		if len(combinedTags) > 0 {
-			for _, string := range combinedTags {
-				var tagId int
-				fmtString := strings.TrimSpace(strings.ToLower(string))
+		if err := rr.enqueueURLs(ctx, tx, noteId, body); err != nil {
+			return err
+		}
 
-				err := rr.db.QueryRow(ctx, "INSERT INTO tags (tag) VALUES ($1) ON CONFLICT (tag) DO UPDATE SET updated_at = NOW() RETURNING id", fmtString).Scan(&tagId)
+		if err := rr.saveLinks(ctx, tx, noteId, body); err != nil {
+			return err
+		}
+
+		return rr.index(ctx, tx, noteId)
+	})
+
+	return noteId, error
+}
+
+func (rr NoteRepo) SetPriority(ctx context.Context, noteId NoteID, priorityLevel PriorityLevel) error {
+	return rr.SetExclusiveTag(ctx, noteId, tags.TaskPriority, string(priorityLevel))
+}
+
+// SetExclusiveTag gives a note the tag for value, replacing any other tag of
+// the same type. The type must be exclusive and the value one it allows. An
+// empty value clears the note's tag of that type.
+func (rr NoteRepo) SetExclusiveTag(ctx context.Context, noteId NoteID, tagType tags.TagType, value string) error {
+	t, value, err := exclusiveValue(ctx, tagType, value)
+	if err != nil {
+		return err
+	}
+
+	return rr.withTransaction(ctx, func(tx pgx.Tx) error {
+		return rr.setExclusiveTag(ctx, tx, noteId, tagType, t.TagName(value))
+	})
+}
+
+// MoveToColumn is SetExclusiveTag for the board. It refuses to take a
+// column over its WIP limit, counting the notes in context as the board
+// does. The column's row stays locked until the move commits so that two
+// moves can't both see the last free slot.
+func (rr NoteRepo) MoveToColumn(ctx context.Context, noteId NoteID, tagType tags.TagType, value string, context string) error {
+	t, value, err := exclusiveValue(ctx, tagType, value)
+	if err != nil {
+		return err
+	}
+	tag := t.TagName(value)
+
+	return rr.withTransaction(ctx, func(tx pgx.Tx) error {
+		if value != "" {
+			var limit int
+			err := tx.QueryRow(
+				ctx,
+				"SELECT coalesce(wip_limit, 0) FROM tag_type_values WHERE type_id = $1 AND value = $2 FOR UPDATE",
+				tagType, value,
+			).Scan(&limit)
+			if err != nil && err != pgx.ErrNoRows {
+				rr.logger.Println(err.Error())
+				return err
+			}
+
+			if limit > 0 {
+				var inColumn bool
+				var others int
+				err = tx.QueryRow(
+					ctx,
+					`SELECT
+	bool_or(notetags.note_id = $3),
+	count(*) FILTER (WHERE notetags.note_id <> $3)
+FROM
+	notetags
+	JOIN tags ON tags.id = notetags.tag_id
+	JOIN note_search ON note_search.id = notetags.note_id
+WHERE
+	tags.type = $1
+	AND tags.tag = $2
+	AND ($4 = '' OR $4 = ANY(note_search.tags::text[]))`,
+					tagType, tag, noteId, context,
+				).Scan(&inColumn, &others)
 				if err != nil {
+					rr.logger.Println(err.Error())
 					return err
 				}
 
-				_, err = rr.db.Exec(ctx, "INSERT INTO notetags (tag_id, note_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", tagId, noteId)
-				if err != nil {
-					return err
+				if inColumn {
+					return nil
+				}
+				if others >= limit {
+					return fmt.Errorf("%w: %s", ErrWIPLimitReached, value)
 				}
 			}
-
-			return err
 		}
-		return nil
+
+		return rr.setExclusiveTag(ctx, tx, noteId, tagType, tag)
 	})
+}
 
-	go url.ExtractURLMetadata(body)
+// exclusiveValue checks value against an exclusive type, returning the type
+// and the value as the type spells it.
+func exclusiveValue(ctx context.Context, tagType tags.TagType, value string) (tags.Type, string, error) {
+	t, err := tags.NewTypeRepo().Get(ctx, tagType)
+	if err != nil {
+		return t, "", err
+	}
 
-	return error
+	if !t.Exclusive {
+		return t, "", fmt.Errorf("%w: %s", tags.ErrNotExclusive, t.Name)
+	}
+
+	if value == "" {
+		return t, "", nil
+	}
+	value, err = t.Value(value)
+	return t, value, err
 }
 
-// TODO: Wrap in transaction
-func (rr NoteRepo) SetPriority(ctx context.Context, noteId NoteID, priorityLevel PriorityLevel) error {
-	_, err := rr.db.Exec(ctx, "delete from notetags where note_id = $1 and tag_id in (select id as tag_id from tags where tags.type = $2)", noteId, TaskPriority)
+// setExclusiveTag swaps the note's tag of tagType for tag. A tag of that
+// name with another type is left alone, as retyping it would change every
+// other note carrying it.
+func (rr NoteRepo) setExclusiveTag(ctx context.Context, tx pgx.Tx, noteId NoteID, tagType tags.TagType, tag string) error {
+	_, err := tx.Exec(ctx, "DELETE FROM notetags WHERE note_id = $1 AND tag_id IN (SELECT id FROM tags WHERE type = $2)", noteId, tagType)
 	if err != nil {
-		panic(err)
+		rr.logger.Println(err.Error())
+		return err
 	}
 
-	_, err = rr.db.Exec(ctx, "INSERT INTO notetags (note_id, tag_id) VALUES ($1, (select id from tags where tag=$2))", noteId, priorityLevel)
-	if err != nil {
-		panic(err)
+	if tag != "" {
+		var tagId int
+		var existingType tags.TagType
+		err = tx.QueryRow(ctx, "INSERT INTO tags (tag, type) VALUES ($1, $2) ON CONFLICT (tag) DO UPDATE SET updated_at = NOW() RETURNING id, type", tag, tagType).Scan(&tagId, &existingType)
+		if err != nil {
+			rr.logger.Println(err.Error())
+			return err
+		}
+
+		if existingType != tagType {
+			return fmt.Errorf("%w: %s", tags.ErrTagTypeTaken, tag)
+		}
+
+		_, err = tx.Exec(ctx, "INSERT INTO notetags (note_id, tag_id) VALUES ($1, $2)", noteId, tagId)
+		if err != nil {
+			rr.logger.Println(err.Error())
+			return err
+		}
 	}
 
-	return err
+	return rr.index(ctx, tx, noteId)
 }
 
-func (rr NoteRepo) Edit(ctx context.Context, noteId NoteID, body string, tags string) error {
-	error := rr.withTransaction(ctx, func() error {
-		_, err := rr.db.Exec(ctx, "UPDATE notes SET body=$1 WHERE notes.id = $2", body, noteId)
+// Edit replaces the body and tags of a note. The write only goes ahead if the
+// note is still at version, otherwise ErrStaleNote is returned so the caller
+// can show the newer note. A version of zero skips the check.
+func (rr NoteRepo) Edit(ctx context.Context, noteId NoteID, body string, tagInput string, version int) error {
+	error := rr.withTransaction(ctx, func(tx pgx.Tx) error {
+		var currentVersion int
+		err := tx.QueryRow(ctx, "SELECT version FROM notes WHERE id = $1 FOR UPDATE", noteId).Scan(&currentVersion)
 
+		if err == pgx.ErrNoRows {
+			return ErrNoteNotFound
+		}
 		if err != nil {
 			rr.logger.Println(err.Error())
 			return err
 		}
 
-		_, err = rr.db.Exec(ctx, "DELETE FROM notetags WHERE note_id = $1", noteId)
+		if version != 0 && version != currentVersion {
+			return ErrStaleNote
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE notes SET body=$1, version = version + 1, updated_at = NOW() WHERE notes.id = $2", body, noteId)
 
 		if err != nil {
 			rr.logger.Println(err.Error())
 			return err
 		}
 
-		combinedTags := assembleTags(body, tags)
+		_, err = tx.Exec(ctx, "DELETE FROM notetags WHERE note_id = $1", noteId)
 
-		if len(combinedTags) > 0 {
-			for _, string := range combinedTags {
-				var tagId int
-				fmtString := strings.TrimSpace(strings.ToLower(string))
+		if err != nil {
+			rr.logger.Println(err.Error())
+			return err
+		}
 
-				err := rr.db.QueryRow(ctx, "INSERT INTO tags (tag) VALUES ($1) ON CONFLICT (tag) DO UPDATE SET updated_at = NOW() RETURNING id", fmtString).Scan(&tagId)
-				if err != nil {
-					return err
-				}
+		if err := rr.saveTasks(ctx, tx, noteId, body); err != nil {
+			return err
+		}
 
-				_, err = rr.db.Exec(ctx, "INSERT INTO notetags (tag_id, note_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", tagId, noteId)
-				if err != nil {
-					return err
-				}
-			}
+		if err := rr.addNoteTags(ctx, tx, noteId, body, tagInput); err != nil {
+			return err
+		}
 
+		if err := rr.enqueueURLs(ctx, tx, noteId, body); err != nil {
 			return err
 		}
-		return nil
+
+		if err := rr.saveLinks(ctx, tx, noteId, body); err != nil {
+			return err
+		}
+
+		return rr.index(ctx, tx, noteId)
 	})
 
-	go url.ExtractURLMetadata(body)
+	if error == nil {
+		rendered.invalidate(noteId)
+	}
 
 	return error
 }
 
-func assembleTags(body string, tags string) []string {
-	var mainTags []string
-	if tags != "" {
-		for _, tag := range strings.Split(strings.TrimSpace(tags), ",") {
-			if tag != "" {
-				mainTags = append(mainTags, tag)
+// addNoteTags attaches the tags entered for a note as well as a person tag
+// for everyone @mentioned in its body.
+func (rr NoteRepo) addNoteTags(ctx context.Context, tx pgx.Tx, noteId NoteID, body string, tagInput string) error {
+	if err := rr.addTags(ctx, tx, noteId, splitTags(tagInput), tags.Category); err != nil {
+		return err
+	}
+
+	return rr.addTags(ctx, tx, noteId, ExtractPeople(body), tags.Person)
+}
+
+func (rr NoteRepo) addTags(ctx context.Context, tx pgx.Tx, noteId NoteID, tagList []string, tagType tags.TagType) error {
+	for _, tag := range tagList {
+		var tagId int
+		fmtTag := tags.Normalise(tag)
+		if fmtTag == "" {
+			continue
+		}
+
+		// Aliases are resolved at write time so that notes only ever carry
+		// the canonical tag, and plain tags naming a value of an exclusive
+		// type such as a priority or status/done keep the value's spelling
+		// and take its type, even before any note has had the value.
+		var name string
+		var current tags.TagType
+		var currentExclusive bool
+		err := tx.QueryRow(
+			ctx,
+			`SELECT resolved.tag, coalesce(tags.type, value_types.id, 0), coalesce(tag_types.exclusive, value_types.id IS NOT NULL)
+			FROM (SELECT coalesce(
+				(SELECT tags.tag FROM tag_aliases JOIN tags ON tags.id = tag_aliases.tag_id WHERE alias = $1),
+				(SELECT tags.tag FROM tags JOIN tag_types ON tag_types.id = tags.type WHERE $2 = $3 AND tag_types.exclusive AND lower(tags.tag) = $1 LIMIT 1),
+				(SELECT tag_types.name || '/' || tag_type_values.value FROM tag_types JOIN tag_type_values ON tag_type_values.type_id = tag_types.id
+					WHERE $2 = $3 AND tag_types.exclusive AND tag_types.id <> $4 AND lower(tag_types.name || '/' || tag_type_values.value) = $1 LIMIT 1),
+				$1
+			) AS tag) AS resolved
+				LEFT JOIN tags ON tags.tag = resolved.tag
+				LEFT JOIN tag_types ON tag_types.id = tags.type
+				LEFT JOIN LATERAL (
+					SELECT tag_types.id FROM tag_types JOIN tag_type_values ON tag_type_values.type_id = tag_types.id
+					WHERE $2 = $3 AND tag_types.exclusive AND tag_types.id <> $4 AND tag_types.name || '/' || tag_type_values.value = resolved.tag
+					LIMIT 1
+				) AS value_types ON true`,
+			fmtTag,
+			tagType,
+			tags.Category,
+			tags.TaskPriority,
+		).Scan(&name, &current, &currentExclusive)
+		if err != nil {
+			return err
+		}
+
+		resolvedType, apply := tags.ResolveType(current, currentExclusive, tagType)
+		if !apply {
+			continue
+		}
+
+		// The type is checked again on conflict in case it changed since
+		var exclusive bool
+		err = tx.QueryRow(
+			ctx,
+			`INSERT INTO tags (tag, type) VALUES ($1, $2)
+			ON CONFLICT (tag) DO UPDATE SET
+				updated_at = NOW(),
+				type = CASE WHEN (SELECT exclusive FROM tag_types WHERE tag_types.id = tags.type) THEN tags.type ELSE EXCLUDED.type END
+			RETURNING id, type, (SELECT exclusive FROM tag_types WHERE tag_types.id = tags.type)`,
+			name,
+			resolvedType,
+		).Scan(&tagId, &resolvedType, &exclusive)
+		if err != nil {
+			return err
+		}
+		if _, apply := tags.ResolveType(resolvedType, exclusive, tagType); !apply {
+			continue
+		}
+
+		if exclusive {
+			_, err = tx.Exec(
+				ctx,
+				"DELETE FROM notetags WHERE note_id = $1 AND tag_id <> $2 AND tag_id IN (SELECT id FROM tags WHERE type = (SELECT type FROM tags WHERE id = $2))",
+				noteId,
+				tagId,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, "INSERT INTO notetags (tag_id, note_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", tagId, noteId)
+		if err != nil {
+			return err
+		}
+
+		if resolvedType == tags.Person {
+			_, err = tx.Exec(ctx, "INSERT INTO people (tag_id) VALUES ($1) ON CONFLICT DO NOTHING", tagId)
+			if err != nil {
+				return err
 			}
 		}
 	}
-	peopleTags := ExtractPeople(body)
-	return append(mainTags, peopleTags...)
+
+	return nil
+}
+
+func splitTags(tagInput string) []string {
+	var tagList []string
+	for _, tag := range strings.Split(strings.TrimSpace(tagInput), ",") {
+		if tag != "" {
+			tagList = append(tagList, tag)
+		}
+	}
+	return tagList
 }
 
 func (rr NoteRepo) GetTodos(ctx context.Context) ([]Note, error) {
@@ -449,12 +899,13 @@ func (rr NoteRepo) GetTodos(ctx context.Context) ([]Note, error) {
       tags,
       done,
       inserted_at,
+      version,
       'Unprioritised'
     FROM
       notes
 	JOIN note_search ON notes.id = note_search.id
   	WHERE
-  		('todo' = ANY(tags) OR body LIKE '%- [ ]%') AND done=false
+  		('todo' = ANY(tags) OR EXISTS (SELECT 1 FROM note_tasks WHERE note_tasks.note_id = notes.id AND NOT note_tasks.done)) AND done=false
     ORDER BY
       notes.id DESC`,
 	)
@@ -465,61 +916,125 @@ func (rr NoteRepo) GetTodos(ctx context.Context) ([]Note, error) {
 		return notes, err
 	}
 
-	return rr.parseData(rows)
+	return rr.parseData(ctx, rows)
 }
 
-func (rr NoteRepo) Search(ctx context.Context, searchQuery string) ([]Note, error) {
-	var notes []Note
+// index brings the note's row in note_search up to date with its body and
+// tags. It should be called at the end of any transaction that changes them.
+func (rr NoteRepo) index(ctx context.Context, tx pgx.Tx, noteId NoteID) error {
+	_, err := tx.Exec(ctx, "SELECT index_note($1)", noteId)
+	if err != nil {
+		rr.logger.Println(err.Error())
+	}
+	return err
+}
 
-	tsquery := strings.Join(strings.Split(searchQuery, " "), " | ")
+// archiverPreference orders the archivers from the most private, so a note
+// tagged with several contexts is archived the way the strictest wants.
+var archiverPreference = []string{url.ArchiverLocal, url.ArchiverNone, url.ArchiverArchiveToday, url.ArchiverWayback}
 
-	// Using a subtable so we can order by rank without
-	// returning it
-	rows, err := rr.db.Query(
+// enqueueURLs queues the links in a note body to be archived by the
+// archiver of the note's contexts, or of the active context if it has none.
+func (rr NoteRepo) enqueueURLs(ctx context.Context, tx pgx.Tx, noteId NoteID, body string) error {
+	var archiver string
+	err := tx.QueryRow(
 		ctx,
 		`SELECT
-	id,
-	body,
-	tags,
-	done,
-	inserted_at,
-  'Unprioritised'
-FROM (
-	SELECT
-		notes.id AS id,
-		body,
-		tags,
-		done,
-		inserted_at,
-		ts_rank(note_search.doc, to_tsquery($1)) AS rank
-	FROM
-		notes
-	JOIN note_search ON notes.id = note_search.id
-	WHERE
-		note_search.doc @@ to_tsquery($1) AND done = false
-	ORDER BY
-		rank DESC,
-		inserted_at DESC) subtable`,
-		tsquery,
-	)
-	defer rows.Close()
+	COALESCE((
+		SELECT
+			contexts.archiver
+		FROM
+			contexts
+			JOIN tags ON tags.tag = contexts.context
+			JOIN notetags ON notetags.tag_id = tags.id
+		WHERE
+			notetags.note_id = $1
+		ORDER BY
+			array_position($2::text[], contexts.archiver)
+		LIMIT 1), (
+		SELECT
+			archiver
+		FROM
+			contexts
+		WHERE
+			active = TRUE), $3)`,
+		noteId,
+		archiverPreference,
+		url.ArchiverWayback,
+	).Scan(&archiver)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return err
+	}
+
+	return url.EnqueueURLs(ctx, tx, body, archiver)
+}
 
+// saveLinks records the URLs in a note body against the note, so each link
+// knows the notes that mention it.
+func (rr NoteRepo) saveLinks(ctx context.Context, tx pgx.Tx, noteId NoteID, body string) error {
+	err := links.SaveNoteURLs(ctx, tx, string(noteId), url.FindURLs(body))
 	if err != nil {
 		rr.logger.Println(err.Error())
-		return notes, err
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE notes SET links_saved = true WHERE id = $1 AND NOT links_saved", noteId)
+	if err != nil {
+		rr.logger.Println(err.Error())
+	}
+	return err
+}
+
+// SaveUnsavedLinks records the URLs of notes written before notes kept track
+// of their links, finding them as saveLinks does. Once every note is done the
+// notes of each link are counted, so links no note mentions can be cleaned up.
+func (rr NoteRepo) SaveUnsavedLinks(ctx context.Context) error {
+	var ids []int
+	err := rr.db.QueryRow(ctx, "SELECT COALESCE(array_agg(id), '{}') FROM notes WHERE NOT links_saved").Scan(&ids)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	for _, id := range ids {
+		noteId := NoteID(fmt.Sprint(id))
+		err := rr.withTransaction(ctx, func(tx pgx.Tx) error {
+			// The note may have been saved, and its links with it, since
+			var body string
+			err := tx.QueryRow(ctx, "SELECT body FROM notes WHERE id = $1 AND NOT links_saved FOR UPDATE", noteId).Scan(&body)
+			if err == pgx.ErrNoRows {
+				return nil
+			}
+			if err != nil {
+				rr.logger.Println(err.Error())
+				return err
+			}
+
+			if err := rr.saveLinks(ctx, tx, noteId, body); err != nil {
+				return err
+			}
+			return rr.index(ctx, tx, noteId)
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	return rr.parseData(rows)
+	return links.NewLinkRepo().CountAllNotes(ctx)
 }
 
-func (rr NoteRepo) withTransaction(ctx context.Context, fn func() error) error {
+func (rr NoteRepo) withTransaction(ctx context.Context, fn func(tx pgx.Tx) error) error {
 	tx, err := rr.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
-	err = fn()
+	err = fn(tx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,324 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"github.com/thrgamon/nous/web"
+)
+
+var ErrNothingToMerge = errors.New("no other notes to merge")
+
+// Merge folds other notes into one. The bodies are joined oldest first, the
+// tags are combined and the note takes the earliest inserted_at of them all.
+// The other notes are deleted, leaving redirects to the merged note. Where
+// the notes have different values for an exclusive tag type, the value of
+// the note merged into wins.
+func (rr NoteRepo) Merge(ctx context.Context, into NoteID, from []NoteID) error {
+	var others []NoteID
+	for _, id := range from {
+		if id != into {
+			others = append(others, id)
+		}
+	}
+	if len(others) == 0 {
+		return ErrNothingToMerge
+	}
+
+	ids := []string{}
+	for _, id := range others {
+		ids = append(ids, string(id))
+	}
+
+	// Locked in id order, so merges of overlapping notes wait on each other
+	// rather than deadlock
+	locking := append([]NoteID{into}, others...)
+	sort.Slice(locking, func(i, j int) bool {
+		a, _ := strconv.Atoi(string(locking[i]))
+		b, _ := strconv.Atoi(string(locking[j]))
+		return a < b
+	})
+
+	var notes []Note
+	err := rr.withTransaction(ctx, func(tx pgx.Tx) error {
+		for _, id := range locking {
+			note, err := rr.getForUpdate(ctx, tx, id)
+			if err != nil {
+				return fmt.Errorf("note %s: %w", id, err)
+			}
+			notes = append(notes, note)
+		}
+
+		sort.SliceStable(notes, func(i, j int) bool {
+			return notes[i].InsertedAt.Before(notes[j].InsertedAt)
+		})
+
+		var bodies []string
+		for _, note := range notes {
+			bodies = append(bodies, strings.TrimSpace(note.Body))
+		}
+		body := strings.Join(bodies, "\n\n")
+
+		_, err := tx.Exec(
+			ctx,
+			`UPDATE notes SET
+	body = $1,
+	inserted_at = (SELECT min(inserted_at) FROM notes WHERE id = $2 OR id::text = ANY($3)),
+	version = version + 1,
+	updated_at = NOW()
+WHERE id = $2`,
+			body,
+			into,
+			ids,
+		)
+		if err != nil {
+			rr.logger.Println(err.Error())
+			return err
+		}
+
+		// Only one tag of an exclusive type is taken, and none if the note
+		// already has one
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO notetags (note_id, tag_id)
+SELECT DISTINCT ON (CASE WHEN tag_types.exclusive THEN 'type:' || tags.type ELSE 'tag:' || tags.id END) $1::int, tags.id
+FROM notetags
+	JOIN tags ON tags.id = notetags.tag_id
+	JOIN tag_types ON tag_types.id = tags.type
+WHERE notetags.note_id::text = ANY($2)
+	AND NOT (tag_types.exclusive AND EXISTS (
+		SELECT 1 FROM notetags existing JOIN tags t ON t.id = existing.tag_id
+		WHERE existing.note_id = $1 AND t.type = tags.type
+	))
+ON CONFLICT DO NOTHING`,
+			into,
+			ids,
+		)
+		if err != nil {
+			rr.logger.Println(err.Error())
+			return err
+		}
+
+		for _, id := range ids {
+			if err := rr.saveLinks(ctx, tx, NoteID(id), ""); err != nil {
+				return err
+			}
+		}
+
+		statements := []string{
+			"UPDATE note_redirects SET to_id = $1 WHERE to_id::text = ANY($2)",
+			"INSERT INTO note_redirects (from_id, to_id) SELECT id, $1 FROM notes WHERE id::text = ANY($2)",
+			"DELETE FROM notetags WHERE note_id::text = ANY($2)",
+			"DELETE FROM notes WHERE id::text = ANY($2)",
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(ctx, statement, into, ids); err != nil {
+				rr.logger.Println(err.Error())
+				return err
+			}
+		}
+
+		if err := rr.saveTasks(ctx, tx, into, body); err != nil {
+			return err
+		}
+		if err := rr.saveLinks(ctx, tx, into, body); err != nil {
+			return err
+		}
+
+		return rr.index(ctx, tx, into)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, note := range notes {
+		rendered.invalidate(note.ID)
+	}
+	return nil
+}
+
+// Split moves parts of a note into new notes of their own. Each new note
+// gets the tags of the original and a link back to it, and the original is
+// left with the remainder and links to the new notes. The parts come from
+// the note at version, if it has changed since ErrStaleNote is returned.
+func (rr NoteRepo) Split(ctx context.Context, noteId NoteID, version int, remainder string, parts []string) ([]NoteID, error) {
+	var created []NoteID
+	err := rr.withTransaction(ctx, func(tx pgx.Tx) error {
+		original, err := rr.getForUpdate(ctx, tx, noteId)
+		if err != nil {
+			return err
+		}
+		if original.Version != version {
+			return ErrStaleNote
+		}
+
+		var links []string
+		for _, part := range parts {
+			body := fmt.Sprintf("%s\n\nSplit from [%s](/notes/%s)", part, original.Summary(), noteId)
+
+			var id int
+			err := tx.QueryRow(ctx, "INSERT INTO notes (body, inserted_at) VALUES ($1, $2) RETURNING id", body, original.InsertedAt).Scan(&id)
+			if err != nil {
+				rr.logger.Println(err.Error())
+				return err
+			}
+			partId := NoteID(fmt.Sprint(id))
+			created = append(created, partId)
+
+			_, err = tx.Exec(ctx, "INSERT INTO notetags (note_id, tag_id) SELECT $1::int, tag_id FROM notetags WHERE note_id = $2", partId, noteId)
+			if err != nil {
+				rr.logger.Println(err.Error())
+				return err
+			}
+
+			if err := rr.saveTasks(ctx, tx, partId, body); err != nil {
+				return err
+			}
+			if err := rr.saveLinks(ctx, tx, partId, body); err != nil {
+				return err
+			}
+			if err := rr.index(ctx, tx, partId); err != nil {
+				return err
+			}
+
+			links = append(links, fmt.Sprintf("- [%s](/notes/%s)", Note{ID: partId, Body: part}.Summary(), partId))
+		}
+
+		body := strings.TrimSpace(remainder) + "\n\nSplit into:\n" + strings.Join(links, "\n")
+		_, err = tx.Exec(ctx, "UPDATE notes SET body = $1, version = version + 1, updated_at = NOW() WHERE id = $2", body, noteId)
+		if err != nil {
+			rr.logger.Println(err.Error())
+			return err
+		}
+
+		if err := rr.saveTasks(ctx, tx, noteId, body); err != nil {
+			return err
+		}
+		if err := rr.saveLinks(ctx, tx, noteId, body); err != nil {
+			return err
+		}
+
+		return rr.index(ctx, tx, noteId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rendered.invalidate(noteId)
+	return created, nil
+}
+
+// GetRedirect finds where a merged note went.
+func (rr NoteRepo) GetRedirect(ctx context.Context, noteId NoteID) (NoteID, error) {
+	var id int
+	err := rr.db.QueryRow(ctx, "SELECT to_id FROM note_redirects WHERE from_id = $1", noteId).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return "", ErrNoteNotFound
+	}
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return "", err
+	}
+
+	return NoteID(fmt.Sprint(id)), nil
+}
+
+var noteIdRe = regexp.MustCompile(`\d+`)
+
+// MergeHandler merges the notes listed in the ids field into the note.
+func MergeHandler(w http.ResponseWriter, r *http.Request) {
+	id := NoteID(mux.Vars(r)["id"])
+	r.ParseForm()
+
+	var from []NoteID
+	for _, other := range noteIdRe.FindAllString(r.FormValue("ids"), -1) {
+		from = append(from, NoteID(other))
+	}
+
+	err := NewNoteRepo().Merge(r.Context(), id, from)
+	if errors.Is(err, ErrNothingToMerge) || errors.Is(err, ErrNoteNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	redirectToNote(w, r, id)
+}
+
+// SplitHandler splits a note at its headings, or with range fields of the
+// form start-end, at the ranges selected in the editor. The version field is
+// the version of the note the ranges were selected in.
+func SplitHandler(w http.ResponseWriter, r *http.Request) {
+	id := NoteID(mux.Vars(r)["id"])
+	r.ParseForm()
+
+	noteRepo := NewNoteRepo()
+	note, err := noteRepo.Get(r.Context(), id)
+	if errors.Is(err, ErrNoteNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		version = note.Version
+	}
+
+	var remainder string
+	var parts []string
+	if r.Form.Has("range") {
+		var ranges []Range
+		for _, value := range r.Form["range"] {
+			start, end, _ := strings.Cut(value, "-")
+			s, errStart := strconv.Atoi(start)
+			e, errEnd := strconv.Atoi(end)
+			if errStart != nil || errEnd != nil {
+				http.Error(w, ErrInvalidRange.Error(), http.StatusBadRequest)
+				return
+			}
+			ranges = append(ranges, Range{Start: s, End: e})
+		}
+		remainder, parts, err = SplitRanges(note.Body, ranges)
+	} else {
+		remainder, parts, err = SplitAtHeadings(note.Body)
+	}
+	if errors.Is(err, ErrNothingToSplit) || errors.Is(err, ErrInvalidRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = noteRepo.Split(r.Context(), id, version, remainder, parts)
+	if errors.Is(err, ErrStaleNote) || errors.Is(err, ErrNoteNotFound) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	redirectToNote(w, r, id)
+}
+
+func redirectToNote(w http.ResponseWriter, r *http.Request, id NoteID) {
+	if r.Header.Get("HX-Request") != "" {
+		w.Header().Add("HX-Redirect", "/notes/"+string(id))
+		return
+	}
+	http.Redirect(w, r, "/notes/"+string(id), http.StatusSeeOther)
+}
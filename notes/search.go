@@ -0,0 +1,146 @@
+package notes
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// minSearchResults is how few full text matches a search can find before it
+// falls back to looking for similar text.
+const minSearchResults = 5
+
+// similarityLimit caps the number of notes found by similarity alone.
+const similarityLimit = 20
+
+var searchWordRe = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+
+// TSQuery turns free text into a to_tsquery expression matching any of its
+// words. With prefix set each word also matches longer words it starts, for
+// searching as the user types. Punctuation is dropped so the result always
+// parses.
+func TSQuery(input string, prefix bool) string {
+	var terms []string
+	for _, word := range searchWordRe.FindAllString(input, -1) {
+		term := "'" + word + "'"
+		if prefix {
+			term += ":*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " | ")
+}
+
+// Search finds open notes matching the words of the query, best matches
+// first. If full text search finds too little, notes with text similar to
+// the query are added after, which catches typos and partial identifiers.
+func (rr NoteRepo) Search(ctx context.Context, query string) ([]Note, error) {
+	return rr.search(ctx, query, false)
+}
+
+// SearchPrefix is Search for a query that is still being typed, treating
+// each word as a prefix.
+func (rr NoteRepo) SearchPrefix(ctx context.Context, query string) ([]Note, error) {
+	return rr.search(ctx, query, true)
+}
+
+func (rr NoteRepo) search(ctx context.Context, query string, prefix bool) ([]Note, error) {
+	tsquery := TSQuery(query, prefix)
+	if tsquery == "" {
+		return nil, nil
+	}
+
+	// Using a subtable so we can order by rank without
+	// returning it
+	rows, err := rr.db.Query(
+		ctx,
+		`SELECT
+	id,
+	body,
+	tags,
+	done,
+	inserted_at,
+	version,
+  'Unprioritised'
+FROM (
+	SELECT
+		notes.id AS id,
+		body,
+		tags,
+		done,
+		inserted_at,
+		version,
+		ts_rank(note_search.doc, to_tsquery(search_language(), $1)) AS rank
+	FROM
+		notes
+	JOIN note_search ON notes.id = note_search.id
+	WHERE
+		note_search.doc @@ to_tsquery(search_language(), $1) AND done = false
+	ORDER BY
+		rank DESC,
+		inserted_at DESC) subtable`,
+		tsquery,
+	)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes, err := rr.parseData(ctx, rows)
+	if err != nil || len(notes) >= minSearchResults {
+		return notes, err
+	}
+
+	similar, err := rr.searchSimilar(ctx, query, notes)
+	return append(notes, similar...), err
+}
+
+// searchSimilar finds open notes containing text close to the query using
+// trigram word similarity, leaving out notes already found.
+func (rr NoteRepo) searchSimilar(ctx context.Context, query string, found []Note) ([]Note, error) {
+	exclude := []string{}
+	for _, note := range found {
+		exclude = append(exclude, string(note.ID))
+	}
+
+	rows, err := rr.db.Query(
+		ctx,
+		`SELECT
+	id,
+	body,
+	tags,
+	done,
+	inserted_at,
+	version,
+  'Unprioritised'
+FROM (
+	SELECT
+		notes.id AS id,
+		body,
+		tags,
+		done,
+		inserted_at,
+		version,
+		word_similarity($1, body) AS similarity
+	FROM
+		notes
+	JOIN note_search ON notes.id = note_search.id
+	WHERE
+		$1 <% body AND done = false AND NOT notes.id::text = ANY($2)
+	ORDER BY
+		similarity DESC,
+		inserted_at DESC
+	LIMIT $3) subtable`,
+		query,
+		exclude,
+		similarityLimit,
+	)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rr.parseData(ctx, rows)
+}
@@ -0,0 +1,14 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTSQuery(t *testing.T) {
+	assert.Equal(t, "'deploy' | 'api'", TSQuery("deploy api", false))
+	assert.Equal(t, "'depl':* | 'parse_data':*", TSQuery("depl parse_data", true))
+	assert.Equal(t, "'can' | 't' | 'café'", TSQuery("can't: café & |", false))
+	assert.Equal(t, "", TSQuery(" !& ", true))
+}
@@ -0,0 +1,114 @@
+package notes
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+var ErrNothingToSplit = errors.New("nothing to split the note at")
+var ErrInvalidRange = errors.New("invalid range")
+
+var headingRe = regexp.MustCompile(`^(#{1,6})[ \t]`)
+var fenceRe = regexp.MustCompile("^[ \t]*(```|~~~)")
+
+// Range is a selection in a note body, as the browser reports it in UTF-16
+// code units.
+type Range struct {
+	Start int
+	End   int
+}
+
+// SplitAtHeadings breaks a body into a part for each of its top level
+// headings, ignoring anything that looks like a heading inside a code block.
+// Text before the first heading is the remainder left in the original note,
+// and if there is none the first section stays behind instead.
+func SplitAtHeadings(body string) (remainder string, parts []string, err error) {
+	lines := strings.Split(body, "\n")
+
+	type heading struct {
+		line  int
+		level int
+	}
+	var headings []heading
+	inFence := false
+	level := 7
+	for i, line := range lines {
+		if fenceRe.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if match := headingRe.FindStringSubmatch(line); match != nil {
+			headings = append(headings, heading{line: i, level: len(match[1])})
+			if len(match[1]) < level {
+				level = len(match[1])
+			}
+		}
+	}
+
+	var starts []int
+	for _, h := range headings {
+		if h.level == level {
+			starts = append(starts, h.line)
+		}
+	}
+	if len(starts) == 0 {
+		return body, nil, ErrNothingToSplit
+	}
+
+	remainder = strings.TrimSpace(strings.Join(lines[:starts[0]], "\n"))
+	for i, start := range starts {
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		parts = append(parts, strings.TrimSpace(strings.Join(lines[start:end], "\n")))
+	}
+
+	if remainder == "" {
+		remainder, parts = parts[0], parts[1:]
+	}
+	if len(parts) == 0 {
+		return body, nil, ErrNothingToSplit
+	}
+
+	return remainder, parts, nil
+}
+
+// SplitRanges takes each selected range out of a body as a part of its own,
+// leaving the rest as the remainder.
+func SplitRanges(body string, ranges []Range) (remainder string, parts []string, err error) {
+	if len(ranges) == 0 {
+		return body, nil, ErrNothingToSplit
+	}
+
+	units := utf16.Encode([]rune(body))
+	sorted := append([]Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var kept []uint16
+	last := 0
+	for _, r := range sorted {
+		if r.Start < last || r.End <= r.Start || r.End > len(units) {
+			return body, nil, ErrInvalidRange
+		}
+
+		kept = append(kept, units[last:r.Start]...)
+		if part := strings.TrimSpace(string(utf16.Decode(units[r.Start:r.End]))); part != "" {
+			parts = append(parts, part)
+		}
+		last = r.End
+	}
+	kept = append(kept, units[last:]...)
+
+	if len(parts) == 0 {
+		return body, nil, ErrNothingToSplit
+	}
+
+	return strings.TrimSpace(string(utf16.Decode(kept))), parts, nil
+}
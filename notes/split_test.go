@@ -0,0 +1,48 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitAtHeadings(t *testing.T) {
+	body := "Meeting with @hannah\n\n## Roadmap\nship it\n### Detail\nmore\n```\n## not a heading\n```\n## Hiring\nopen a role"
+
+	remainder, parts, err := SplitAtHeadings(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "Meeting with @hannah", remainder)
+	assert.Equal(t, []string{
+		"## Roadmap\nship it\n### Detail\nmore\n```\n## not a heading\n```",
+		"## Hiring\nopen a role",
+	}, parts)
+}
+
+func TestSplitAtHeadingsWithoutPreamble(t *testing.T) {
+	remainder, parts, err := SplitAtHeadings("# One\na\n# Two\nb")
+	assert.NoError(t, err)
+	assert.Equal(t, "# One\na", remainder)
+	assert.Equal(t, []string{"# Two\nb"}, parts)
+
+	_, _, err = SplitAtHeadings("# Only\none section")
+	assert.ErrorIs(t, err, ErrNothingToSplit)
+
+	_, _, err = SplitAtHeadings("no headings")
+	assert.ErrorIs(t, err, ErrNothingToSplit)
+}
+
+func TestSplitRanges(t *testing.T) {
+	// The emoji is two UTF-16 code units, as the browser counts them
+	body := "keep 😀 this\ntake this\nkeep this too\nand this"
+
+	remainder, parts, err := SplitRanges(body, []Range{{Start: 37, End: 45}, {Start: 13, End: 23}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"take this", "and this"}, parts)
+	assert.Equal(t, "keep 😀 this\nkeep this too", remainder)
+
+	_, _, err = SplitRanges(body, []Range{{Start: 0, End: 100}})
+	assert.ErrorIs(t, err, ErrInvalidRange)
+
+	_, _, err = SplitRanges(body, []Range{{Start: 0, End: 10}, {Start: 5, End: 12}})
+	assert.ErrorIs(t, err, ErrInvalidRange)
+}
@@ -0,0 +1,288 @@
+package notes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/thrgamon/nous/tags"
+	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/web"
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+type TaskID string
+
+// Task is a checklist item such as `- [ ] book flights due:2022-08-01 @tom`.
+// Position is its index amongst the checkboxes of the note, and Hash a stable
+// reference to its content that survives edits elsewhere in the note.
+type Task struct {
+	ID       TaskID     `json:"id"`
+	NoteID   NoteID     `json:"note_id"`
+	Position int        `json:"position"`
+	Hash     string     `json:"hash"`
+	Text     string     `json:"text"`
+	Done     bool       `json:"done"`
+	Due      *time.Time `json:"due"`
+	Assignee string     `json:"assignee"`
+
+	// offset is where the checkbox's "[" is in the body
+	offset int
+}
+
+// Overdue reports whether an open task is past its due date.
+func (t Task) Overdue() bool {
+	return !t.Done && t.Due != nil && t.Due.Before(startOfDay(time.Now()))
+}
+
+var dueRe = regexp.MustCompile(`\bdue:(\d{4}-\d{2}-\d{2})\b`)
+
+// taskOffset is the attribute the parser keeps the offset of a checkbox's
+// "[" in the body under, so it can be toggled in the source. It is never
+// rendered.
+var taskOffset = []byte("task-offset")
+
+// taskCheckBoxParser is goldmark's checkbox parser, noting where in the body
+// each checkbox it finds is.
+type taskCheckBoxParser struct {
+	parser.InlineParser
+}
+
+func (p taskCheckBoxParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	_, segment := block.PeekLine()
+	node := p.InlineParser.Parse(parent, block, pc)
+	if node != nil {
+		node.SetAttribute(taskOffset, segment.Start)
+	}
+	return node
+}
+
+// findTasks lists the checkboxes of a parsed note in the order they are
+// rendered, which is what ties the checkboxes on the page to the tasks.
+func findTasks(doc ast.Node, source []byte) []Task {
+	var tasks []Task
+	occurrences := make(map[string]int)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		box, ok := n.(*extast.TaskCheckBox)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		offset, _ := box.AttributeString(string(taskOffset))
+		start, ok := offset.(int)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		task := Task{Position: len(tasks), Done: box.IsChecked, offset: start}
+
+		// The item's text runs from the checkbox to the end of its line
+		line := source[task.offset:]
+		if end := bytes.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end]
+		}
+		task.Text = strings.TrimSpace(string(line[3:]))
+		task.Hash = TaskHash(task.Text, occurrences[task.Text])
+		occurrences[task.Text]++
+
+		tasks = append(tasks, task)
+		return ast.WalkContinue, nil
+	})
+	return tasks
+}
+
+// ParseTasks finds the checklist items in a note body, exactly as they are
+// rendered as checkboxes, so none in code blocks and any in quotes.
+func ParseTasks(body string) []Task {
+	source := []byte(body)
+	tasks := findTasks(markdown.Parser().Parse(text.NewReader(source)), source)
+	for i, task := range tasks {
+		if due := dueRe.FindStringSubmatch(task.Text); due != nil {
+			if date, err := time.Parse("2006-01-02", due[1]); err == nil {
+				tasks[i].Due = &date
+			}
+		}
+
+		if people := ExtractPeople(task.Text); len(people) > 0 {
+			tasks[i].Assignee = tags.Normalise(people[0])
+		}
+	}
+	return tasks
+}
+
+// TaskHash identifies a checklist item by its text. Items with the same text
+// are told apart by how many came before them.
+func TaskHash(text string, occurrence int) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%d:%s", occurrence, text)))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Tasks returns the checklist items of a note.
+func (n Note) Tasks() []Task {
+	return ParseTasks(n.Body)
+}
+
+type Progress struct {
+	Done  int
+	Total int
+}
+
+// Progress counts the checklist items of a note, returning nil when it has
+// none so templates can skip it.
+func (n Note) Progress() *Progress {
+	tasks := ParseTasks(n.Body)
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	progress := Progress{Total: len(tasks)}
+	for _, task := range tasks {
+		if task.Done {
+			progress.Done++
+		}
+	}
+	return &progress
+}
+
+func (rr NoteRepo) saveTasks(ctx context.Context, tx pgx.Tx, noteId NoteID, body string) error {
+	_, err := tx.Exec(ctx, "DELETE FROM note_tasks WHERE note_id = $1", noteId)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE notes SET tasks_saved = true WHERE id = $1 AND NOT tasks_saved", noteId)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return err
+	}
+
+	for _, task := range ParseTasks(body) {
+		_, err := tx.Exec(
+			ctx,
+			"INSERT INTO note_tasks (note_id, position, hash, body, done, due_date, assignee) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))",
+			noteId,
+			task.Position,
+			task.Hash,
+			task.Text,
+			task.Done,
+			task.Due,
+			task.Assignee,
+		)
+		if err != nil {
+			rr.logger.Println(err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveUnsavedTasks finds the tasks of notes saved before their tasks were
+// found by ParseTasks, so they have the same hashes as if they were saved now.
+func (rr NoteRepo) SaveUnsavedTasks(ctx context.Context) error {
+	var ids []int
+	err := rr.db.QueryRow(ctx, "SELECT COALESCE(array_agg(id), '{}') FROM notes WHERE NOT tasks_saved").Scan(&ids)
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return err
+	}
+
+	for _, id := range ids {
+		noteId := NoteID(fmt.Sprint(id))
+		err := rr.withTransaction(ctx, func(tx pgx.Tx) error {
+			// The note may have been saved, and its tasks with it, since
+			var body string
+			err := tx.QueryRow(ctx, "SELECT body FROM notes WHERE id = $1 AND NOT tasks_saved FOR UPDATE", noteId).Scan(&body)
+			if err == pgx.ErrNoRows {
+				return nil
+			}
+			if err != nil {
+				rr.logger.Println(err.Error())
+				return err
+			}
+
+			return rr.saveTasks(ctx, tx, noteId, body)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetOpenTasks returns the unchecked items of every note that isn't done,
+// soonest due first.
+func (rr NoteRepo) GetOpenTasks(ctx context.Context) ([]Task, error) {
+	var tasks []Task
+	rows, err := rr.db.Query(
+		ctx,
+		`SELECT
+	note_tasks.id,
+	note_tasks.note_id,
+	note_tasks.position,
+	note_tasks.hash,
+	note_tasks.body,
+	note_tasks.done,
+	note_tasks.due_date,
+	coalesce(note_tasks.assignee, '')
+FROM
+	note_tasks
+	JOIN notes ON notes.id = note_tasks.note_id
+WHERE
+	NOT note_tasks.done AND NOT notes.done
+ORDER BY
+	note_tasks.due_date ASC NULLS LAST,
+	note_tasks.note_id DESC,
+	note_tasks.position`,
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		rr.logger.Println(err.Error())
+		return tasks, err
+	}
+
+	for rows.Next() {
+		var id int
+		var noteId int
+		var task Task
+		err := rows.Scan(&id, &noteId, &task.Position, &task.Hash, &task.Text, &task.Done, &task.Due, &task.Assignee)
+		if err != nil {
+			rr.logger.Println(err.Error())
+			return tasks, err
+		}
+
+		task.ID = TaskID(fmt.Sprint(id))
+		task.NoteID = NoteID(fmt.Sprint(noteId))
+		tasks = append(tasks, task)
+	}
+
+	return tasks, rows.Err()
+}
+
+type TasksPageData struct {
+	Tasks []Task
+}
+
+func TasksHandler(w http.ResponseWriter, r *http.Request) {
+	tasks, err := NewNoteRepo().GetOpenTasks(r.Context())
+
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "tasks", TasksPageData{Tasks: tasks})
+}
@@ -2,7 +2,9 @@ package notes
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 )
 
 func ExtractPeople(text string) (people []string) {
@@ -15,22 +17,99 @@ func ExtractPeople(text string) (people []string) {
 	return people
 }
 
+var ErrTaskNotFound = errors.New("checklist item not found")
+
+// ToggleTodo flips the checkbox at index, counting from the top of the note.
 func ToggleTodo(body string, index int) (string, error) {
-	re := regexp.MustCompile(`- \[[ xX]\]`)
-	tasks := re.FindAllStringIndex(body, index+1)
-	if len(tasks) == 0 {
-		return "", errors.New("No todos found")
+	tasks := ParseTasks(body)
+	if index < 0 || index >= len(tasks) {
+		return "", fmt.Errorf("%w: no checkbox at %d", ErrTaskNotFound, index)
+	}
+
+	return toggleAt(body, tasks[index]), nil
+}
+
+// ToggleTask flips the checkbox of the item with the given content hash. As
+// the hash is worked out from the text of the item, it still finds the right
+// item after others have been added or removed around it.
+func ToggleTask(body string, hash string) (string, error) {
+	for _, task := range ParseTasks(body) {
+		if task.Hash == hash {
+			return toggleAt(body, task), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrTaskNotFound, hash)
+}
+
+func toggleAt(body string, task Task) string {
+	// The checkbox's state is the character inside the brackets
+	state := "x"
+	if task.Done {
+		state = " "
+	}
+
+	return body[:task.offset+1] + state + body[task.offset+2:]
+}
+
+// IsOpenTodo reports whether a note still has something left to do, either
+// because it is tagged as a todo or because it has unchecked checkboxes.
+func (n Note) IsOpenTodo() bool {
+	if n.Done {
+		return false
 	}
-	bodyIndexes := tasks[index]
-	pre := body[:bodyIndexes[0]]
-	todo := body[bodyIndexes[0]:bodyIndexes[1]]
-	post := body[bodyIndexes[1]:]
 
-	if todo == "- [x]" || todo == "- [X]" {
-		todo = "- [ ]"
-	} else {
-		todo = "- [x]"
+	for _, tag := range n.Tags {
+		if tag == "todo" {
+			return true
+		}
 	}
 
-	return pre + todo + post, nil
+	progress := n.Progress()
+	return progress != nil && progress.Done < progress.Total
+}
+
+// ETag identifies the saved state of a note for conditional requests.
+func (n Note) ETag() string {
+	return fmt.Sprintf(`"%s-%d"`, n.ID, n.Version)
+}
+
+// MatchETag checks an If-Match header against a note. A missing header or *
+// matches any version, otherwise one of the listed tags must be the note's
+// current ETag. If-Match uses strong comparison, so weak tags never match.
+func MatchETag(header string, note Note) bool {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return true
+	}
+
+	current := note.ETag()
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == current {
+			return true
+		}
+	}
+
+	return false
+}
+
+// summaryLength is the most characters of a note shown as its summary.
+const summaryLength = 80
+
+// Summary is the first line of a note with any markdown heading or list
+// markers removed, for linking to the note in a list.
+func (n Note) Summary() string {
+	for _, line := range strings.Split(n.Body, "\n") {
+		line = strings.TrimLeft(strings.TrimSpace(line), "#>-*+ ")
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(line, "[ ]"), "[x]"))
+		if line == "" {
+			continue
+		}
+
+		if runes := []rune(line); len(runes) > summaryLength {
+			return string(runes[:summaryLength-1]) + "…"
+		}
+		return line
+	}
+	return "Note " + string(n.ID)
 }
@@ -1,6 +1,7 @@
 package notes
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,3 +23,112 @@ func TestToggleTodo(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, want, got)
 }
+
+func TestIsOpenTodo(t *testing.T) {
+	assert.True(t, Note{Tags: []string{"home", "todo"}}.IsOpenTodo())
+	assert.True(t, Note{Body: "prep\n- [x] agenda\n- [ ] slides"}.IsOpenTodo())
+	assert.False(t, Note{Body: "- [ ] slides", Done: true}.IsOpenTodo())
+	assert.False(t, Note{Body: "- [x] agenda", Tags: []string{"tom"}}.IsOpenTodo())
+}
+
+func TestParseTasks(t *testing.T) {
+	body := "Trip\n- [x] book flights @Hannah\n- [ ] pack due:2022-08-01\n  - [ ] passport @tom due:2022-07-30"
+
+	tasks := ParseTasks(body)
+
+	assert.Len(t, tasks, 3)
+	assert.Equal(t, Task{Position: 0, Hash: TaskHash("book flights @Hannah", 0), Text: "book flights @Hannah", Done: true, Assignee: "hannah", offset: 7}, tasks[0])
+	assert.Equal(t, "pack due:2022-08-01", tasks[1].Text)
+	assert.False(t, tasks[1].Done)
+	assert.Equal(t, "2022-08-01", tasks[1].Due.Format("2006-01-02"))
+	assert.Equal(t, 2, tasks[2].Position)
+	assert.Equal(t, "tom", tasks[2].Assignee)
+
+	progress := Note{Body: body}.Progress()
+	assert.Equal(t, &Progress{Done: 1, Total: 3}, progress)
+	assert.Nil(t, Note{Body: "no tasks"}.Progress())
+}
+
+func TestParseTasksSkipsCode(t *testing.T) {
+	body := "- [ ] real\n```md\n- [ ] fenced\n```\n\n    - [ ] indented\n\n- [ ] list\n\n    - [ ] nested\n~~~~\n- [x] tilde\n~~~\n~~~~\n- [x] after"
+
+	var texts []string
+	for _, task := range ParseTasks(body) {
+		texts = append(texts, task.Text)
+	}
+	assert.Equal(t, []string{"real", "list", "nested", "after"}, texts)
+
+	got, err := ToggleTodo(body, 3)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(got, "- [ ] after"))
+}
+
+func TestParseTasksInQuotes(t *testing.T) {
+	body := "> - [ ] quoted\n\n- [ ] listed"
+
+	tasks := ParseTasks(body)
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, "quoted", tasks[0].Text)
+
+	got, err := ToggleTask(body, tasks[0].Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, "> - [x] quoted\n\n- [ ] listed", got)
+
+	html := Note{Body: body}.DisplayBody()
+	for _, task := range tasks {
+		assert.Contains(t, string(html), `data-task-hash="`+task.Hash+`"`)
+	}
+}
+
+func TestToggleTodoOutOfRange(t *testing.T) {
+	_, err := ToggleTodo("- [ ] only one", 1)
+	assert.ErrorIs(t, err, ErrTaskNotFound)
+
+	_, err = ToggleTodo("no todos", 0)
+	assert.ErrorIs(t, err, ErrTaskNotFound)
+}
+
+func TestToggleTask(t *testing.T) {
+	body := "- [ ] milk\n- [x] eggs\n- [ ] milk"
+	tasks := ParseTasks(body)
+	assert.NotEqual(t, tasks[0].Hash, tasks[2].Hash)
+
+	// Adding an item above shouldn't change which item the hash points at
+	edited := "- [ ] bread\n" + body
+	got, err := ToggleTask(edited, tasks[1].Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, "- [ ] bread\n- [ ] milk\n- [ ] eggs\n- [ ] milk", got)
+
+	got, err = ToggleTask(body, tasks[2].Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, "- [ ] milk\n- [x] eggs\n- [x] milk", got)
+
+	_, err = ToggleTask("- [ ] something else", tasks[0].Hash)
+	assert.ErrorIs(t, err, ErrTaskNotFound)
+}
+
+func TestMatchETag(t *testing.T) {
+	note := Note{ID: "12", Version: 3}
+	assert.Equal(t, `"12-3"`, note.ETag())
+
+	assert.True(t, MatchETag(note.ETag(), note))
+	assert.True(t, MatchETag("*", note))
+	assert.True(t, MatchETag("", note))
+
+	// Every listed tag is compared, not just the first for this note
+	assert.True(t, MatchETag(`"12-2", "12-3"`, note))
+	assert.False(t, MatchETag(`"12-2", "12-4"`, note))
+
+	// Weak tags fail the strong comparison If-Match calls for
+	assert.False(t, MatchETag(`W/"12-3"`, note))
+
+	assert.False(t, MatchETag(`"13-3"`, note))
+	assert.False(t, MatchETag(`"garbage"`, note))
+}
+
+func TestSummary(t *testing.T) {
+	assert.Equal(t, "Standup", Note{Body: "\n## Standup\n- [ ] deploy"}.Summary())
+	assert.Equal(t, "deploy", Note{Body: "- [x] deploy"}.Summary())
+	assert.Equal(t, "Note 7", Note{ID: "7", Body: "  \n"}.Summary())
+	assert.Equal(t, 80, len([]rune(Note{Body: strings.Repeat("é", 100)}.Summary())))
+}
@@ -0,0 +1,114 @@
+package people
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"github.com/thrgamon/nous/notes"
+	"github.com/thrgamon/nous/tags"
+	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/web"
+)
+
+type DirectoryPageData struct {
+	People []Person
+}
+
+type PersonPageData struct {
+	Person    Person
+	Notes     []notes.Note
+	OpenTodos []notes.Note
+	Error     string
+}
+
+func DirectoryHandler(w http.ResponseWriter, r *http.Request) {
+	people, err := NewPersonRepo().GetAll(r.Context())
+
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "people", DirectoryPageData{People: people})
+}
+
+func PersonHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	renderPerson(w, r, name, "")
+}
+
+func UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	r.ParseForm()
+
+	personRepo := NewPersonRepo()
+	person, err := personRepo.Get(r.Context(), name)
+	if err != nil {
+		handleLookupError(w, r, err)
+		return
+	}
+
+	err = personRepo.Update(r.Context(), person.ID, r.FormValue("display_name"), r.FormValue("contact_notes"))
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/people/"+person.Name, http.StatusSeeOther)
+}
+
+func AddAliasHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	r.ParseForm()
+
+	person, err := NewPersonRepo().Get(r.Context(), name)
+	if err != nil {
+		handleLookupError(w, r, err)
+		return
+	}
+
+	err = tags.NewTagRepo().AddAlias(r.Context(), person.TagID, r.FormValue("alias"))
+	if errors.Is(err, tags.ErrEmptyTag) {
+		renderPerson(w, r, name, err.Error())
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/people/"+person.Name, http.StatusSeeOther)
+}
+
+func renderPerson(w http.ResponseWriter, r *http.Request, name string, message string) {
+	person, err := NewPersonRepo().Get(r.Context(), name)
+	if err != nil {
+		handleLookupError(w, r, err)
+		return
+	}
+
+	nts, err := notes.NewNoteRepo().GetByTag(r.Context(), person.Name)
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	pageData := PersonPageData{Person: person, Notes: nts, Error: message}
+	for _, note := range nts {
+		if note.IsOpenTodo() {
+			pageData.OpenTodos = append(pageData.OpenTodos, note)
+		}
+	}
+
+	templates.RenderTemplate(w, "person", pageData)
+}
+
+func handleLookupError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	web.HandleUnexpectedError(w, err)
+}
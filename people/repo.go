@@ -0,0 +1,156 @@
+package people
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/thrgamon/nous/database"
+	"github.com/thrgamon/nous/logger"
+	"github.com/thrgamon/nous/tags"
+)
+
+type PersonID string
+
+// Person is someone @mentioned in notes. Their identity is the person tag
+// created for the mention, so renaming or aliasing that tag carries over.
+type Person struct {
+	ID              PersonID   `json:"id"`
+	TagID           tags.TagID `json:"tag_id"`
+	Name            string     `json:"name"`
+	DisplayName     string     `json:"display_name"`
+	ContactNotes    string     `json:"contact_notes"`
+	Aliases         []string   `json:"aliases"`
+	NoteCount       int        `json:"note_count"`
+	LastInteraction *time.Time `json:"last_interaction"`
+}
+
+// Label is how a person is shown, falling back to their mention.
+func (p Person) Label() string {
+	if p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return "@" + p.Name
+}
+
+type PersonRepo struct {
+	db     *pgxpool.Pool
+	logger *log.Logger
+}
+
+func NewPersonRepo() *PersonRepo {
+	db := database.Database
+	logger := logger.Logger
+	return &PersonRepo{db: db, logger: logger}
+}
+
+const selectPeople = `SELECT
+	people.id,
+	tags.id,
+	tags.tag,
+	coalesce(people.display_name, ''),
+	coalesce(people.contact_notes, ''),
+	coalesce((SELECT array_agg(alias ORDER BY alias) FROM tag_aliases WHERE tag_aliases.tag_id = tags.id), '{}'),
+	count(notes.id),
+	max(notes.inserted_at)
+FROM
+	people
+	JOIN tags ON tags.id = people.tag_id
+	LEFT JOIN notetags ON notetags.tag_id = tags.id
+	LEFT JOIN notes ON notes.id = notetags.note_id`
+
+// GetAll returns everyone mentioned, most recently seen first.
+func (pr PersonRepo) GetAll(ctx context.Context) ([]Person, error) {
+	rows, err := pr.db.Query(
+		ctx,
+		selectPeople+`
+GROUP BY
+	people.id, tags.id
+ORDER BY
+	max(notes.inserted_at) DESC NULLS LAST`,
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		pr.logger.Println(err.Error())
+		return []Person{}, err
+	}
+
+	return pr.parseData(rows)
+}
+
+func (pr PersonRepo) Get(ctx context.Context, name string) (Person, error) {
+	rows, err := pr.db.Query(
+		ctx,
+		selectPeople+`
+WHERE
+	tags.tag = $1
+GROUP BY
+	people.id, tags.id`,
+		tags.Normalise(name),
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		pr.logger.Println(err.Error())
+		return Person{}, err
+	}
+
+	people, err := pr.parseData(rows)
+	if err != nil {
+		return Person{}, err
+	}
+
+	if len(people) == 0 {
+		return Person{}, pgx.ErrNoRows
+	}
+
+	return people[0], nil
+}
+
+func (pr PersonRepo) Update(ctx context.Context, id PersonID, displayName string, contactNotes string) error {
+	_, err := pr.db.Exec(
+		ctx,
+		"UPDATE people SET display_name = $1, contact_notes = $2, updated_at = NOW() WHERE id = $3",
+		displayName,
+		contactNotes,
+		id,
+	)
+	return err
+}
+
+func (pr PersonRepo) parseData(rows pgx.Rows) ([]Person, error) {
+	var people []Person
+
+	for rows.Next() {
+		var id int
+		var tagId int
+		var person Person
+		err := rows.Scan(
+			&id,
+			&tagId,
+			&person.Name,
+			&person.DisplayName,
+			&person.ContactNotes,
+			&person.Aliases,
+			&person.NoteCount,
+			&person.LastInteraction,
+		)
+
+		if err != nil {
+			pr.logger.Println(err.Error())
+			return people, err
+		}
+
+		person.ID = PersonID(fmt.Sprint(id))
+		person.TagID = tags.TagID(fmt.Sprint(tagId))
+		people = append(people, person)
+	}
+
+	return people, rows.Err()
+}
@@ -0,0 +1,29 @@
+function hydrateBoard() {
+  document.querySelectorAll('.board-card:not([data-hydrated])').forEach(card => {
+    card.dataset.hydrated = true
+    card.addEventListener('dragstart', e => e.dataTransfer.setData('text/plain', card.dataset.noteId))
+  })
+
+  document.querySelectorAll('.board-column:not([data-hydrated])').forEach(column => {
+    column.dataset.hydrated = true
+    column.addEventListener('dragover', e => {
+      e.preventDefault()
+      column.classList.add('drag-over')
+    })
+    column.addEventListener('dragleave', () => column.classList.remove('drag-over'))
+    column.addEventListener('drop', e => {
+      e.preventDefault()
+      column.classList.remove('drag-over')
+      const board = column.closest('.board')
+      htmx.ajax('PATCH', `/board/note/${e.dataTransfer.getData('text/plain')}`, {
+        target: board,
+        swap: 'outerHTML',
+        values: {type: board.dataset.type, context: board.dataset.context, value: column.dataset.value}
+      })
+    })
+  })
+}
+
+hydrateBoard()
+
+document.addEventListener("htmx:afterSettle", hydrateBoard)
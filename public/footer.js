@@ -1,13 +1,43 @@
 function hydrateCheckboxes() {
-  document.querySelectorAll('.content input').forEach(input => input.closest('.content').querySelectorAll('input').forEach((x, index) => {
-  x.disabled = false;
-  id = x.closest('.note').dataset.noteId;
-  x.setAttribute("hx-put", `/note/${id}/todo/${index}`);
-  x.setAttribute("hx-target", "closest .note")
-  htmx.process(x)
-}))
+  document.querySelectorAll('.note').forEach(note => note.querySelectorAll('.content input[data-task-hash]').forEach(x => {
+    x.disabled = false;
+    x.setAttribute("hx-put", `/note/${note.dataset.noteId}/task/${x.dataset.taskHash}`);
+    x.setAttribute("hx-vals", JSON.stringify({version: note.dataset.version}));
+    x.setAttribute("hx-target", "closest .note")
+    htmx.process(x)
+  }))
 }
 
 hydrateCheckboxes()
 
 document.addEventListener("htmx:afterSettle", hydrateCheckboxes)
+
+// A 409 means the note changed underneath us. The response is the fresh note
+// so swap it in rather than treating it as an error.
+document.addEventListener("htmx:beforeSwap", e => {
+  if (e.detail.xhr.status === 409) {
+    e.detail.shouldSwap = true;
+    e.detail.isError = false;
+  }
+})
+
+// Splitting sends the selected text's position in the saved body, so only
+// offer it when the editor hasn't been changed.
+document.addEventListener("click", e => {
+  const button = e.target.closest(".split-selection");
+  if (!button) return;
+
+  const textarea = button.closest(".note").querySelector("textarea[name=body]");
+  if (textarea.value !== textarea.defaultValue) {
+    alert("Save your changes before splitting the note.");
+    return;
+  }
+  if (textarea.selectionStart === textarea.selectionEnd) {
+    alert("Select the text to move into a new note.");
+    return;
+  }
+
+  const version = button.closest(".note").querySelector("input[name=version]").value;
+  button.setAttribute("hx-vals", JSON.stringify({range: `${textarea.selectionStart}-${textarea.selectionEnd}`, version: version}));
+  htmx.ajax("POST", `/note/${button.dataset.noteId}/split`, {source: button});
+})
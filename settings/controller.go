@@ -0,0 +1,112 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/thrgamon/nous/contexts"
+	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/url"
+	"github.com/thrgamon/nous/web"
+)
+
+type PageData struct {
+	Language         string
+	Languages        []string
+	ContextArchivers []contexts.Archiver
+	Archivers        []string
+	Error            string
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	renderSettings(w, r, "")
+}
+
+func UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	err := NewSettingsRepo().SetLanguage(r.Context(), r.FormValue("language"))
+	if errors.Is(err, ErrUnknownLanguage) {
+		w.WriteHeader(http.StatusBadRequest)
+		renderSettings(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// ArchiversHandler sets the archiver of each context from the form, which
+// has a field per context named after it.
+func ArchiversHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	contextRepo := contexts.NewContextRepo()
+	current, err := contextRepo.GetArchivers(r.Context())
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	for _, c := range current {
+		archiver := r.FormValue(c.Context)
+		if archiver == "" || archiver == c.Archiver {
+			continue
+		}
+
+		if !knownArchiver(archiver) {
+			w.WriteHeader(http.StatusBadRequest)
+			renderSettings(w, r, fmt.Errorf("%w: %s", url.ErrUnknownArchiver, archiver).Error())
+			return
+		}
+
+		if err := contextRepo.SetArchiver(r.Context(), c.Context, archiver); err != nil {
+			web.HandleUnexpectedError(w, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func knownArchiver(name string) bool {
+	for _, archiver := range url.Archivers {
+		if archiver == name {
+			return true
+		}
+	}
+	return false
+}
+
+func renderSettings(w http.ResponseWriter, r *http.Request, message string) {
+	settingsRepo := NewSettingsRepo()
+	language, err := settingsRepo.GetLanguage(r.Context())
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	languages, err := settingsRepo.Languages(r.Context())
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	contextArchivers, err := contexts.NewContextRepo().GetArchivers(r.Context())
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "settings", PageData{
+		Language:         language,
+		Languages:        languages,
+		ContextArchivers: contextArchivers,
+		Archivers:        url.Archivers,
+		Error:            message,
+	})
+}
@@ -0,0 +1,93 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/thrgamon/nous/database"
+	"github.com/thrgamon/nous/logger"
+)
+
+var ErrUnknownLanguage = errors.New("unknown search language")
+
+type SettingsRepo struct {
+	db     *pgxpool.Pool
+	logger *log.Logger
+}
+
+func NewSettingsRepo() *SettingsRepo {
+	db := database.Database
+	logger := logger.Logger
+	return &SettingsRepo{db: db, logger: logger}
+}
+
+// GetLanguage is the text search configuration the search index is built
+// with. The language was asked for as a setting of each user, but note_search
+// holds one document per note that everyone searches, so a note can only be
+// stemmed one way. It is a setting of the app instead, which anyone can change.
+func (sr SettingsRepo) GetLanguage(ctx context.Context) (string, error) {
+	var language string
+	err := sr.db.QueryRow(ctx, "SELECT search_language()::text").Scan(&language)
+	if err != nil {
+		sr.logger.Println(err.Error())
+		return "", err
+	}
+
+	return language, nil
+}
+
+// Languages lists the text search configurations installed in Postgres.
+func (sr SettingsRepo) Languages(ctx context.Context) ([]string, error) {
+	rows, err := sr.db.Query(ctx, "SELECT cfgname::text FROM pg_ts_config ORDER BY cfgname")
+	if err != nil {
+		sr.logger.Println(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var languages []string
+	for rows.Next() {
+		var language string
+		if err := rows.Scan(&language); err != nil {
+			return languages, err
+		}
+		languages = append(languages, language)
+	}
+
+	return languages, rows.Err()
+}
+
+// SetLanguage changes the text search configuration. The search index is
+// stemmed for one language, so every note is indexed again to match.
+func (sr SettingsRepo) SetLanguage(ctx context.Context, language string) error {
+	tx, err := sr.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var known bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM pg_ts_config WHERE cfgname = $1)", language).Scan(&known)
+	if err != nil {
+		return err
+	}
+	if !known {
+		return ErrUnknownLanguage
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE search_settings SET language = $1::regconfig, updated_at = NOW()", language)
+	if err != nil {
+		sr.logger.Println(err.Error())
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "SELECT index_note(id) FROM notes")
+	if err != nil {
+		sr.logger.Println(err.Error())
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,51 @@
+package snapshots
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/thrgamon/nous/web"
+)
+
+// Snapshots are someone else's HTML, so they are served without scripts and
+// can only load their own saved assets.
+const snapshotPolicy = "default-src 'none'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; font-src 'self' data:; sandbox"
+
+func SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := NewSnapshotRepo().Get(r.Context(), SnapshotID(mux.Vars(r)["id"]))
+	if errors.Is(err, ErrSnapshotNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Security-Policy", snapshotPolicy)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(snapshot.HTML))
+}
+
+func AssetHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	position, _ := strconv.Atoi(vars["asset"])
+
+	asset, err := NewSnapshotRepo().GetAsset(r.Context(), SnapshotID(vars["id"]), position)
+	if errors.Is(err, ErrSnapshotNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Security-Policy", snapshotPolicy)
+	w.Header().Set("Content-Type", asset.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Write(asset.Body)
+}
@@ -0,0 +1,119 @@
+package snapshots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/thrgamon/nous/database"
+	"github.com/thrgamon/nous/logger"
+)
+
+type SnapshotID string
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Snapshot is a copy of a page kept in our own database.
+type Snapshot struct {
+	ID   SnapshotID
+	URL  string
+	HTML string
+}
+
+// Asset is an image or stylesheet a snapshot needs to display.
+type Asset struct {
+	URL         string
+	ContentType string
+	Body        []byte
+}
+
+type SnapshotRepo struct {
+	db     *pgxpool.Pool
+	logger *log.Logger
+}
+
+func NewSnapshotRepo() *SnapshotRepo {
+	db := database.Database
+	logger := logger.Logger
+	return &SnapshotRepo{db: db, logger: logger}
+}
+
+// Save stores a page and its assets. The HTML refers to the assets by
+// their position, through AssetPath, so the snapshot is created first and
+// rewrite fills in its id.
+func (sr SnapshotRepo) Save(ctx context.Context, url string, rewrite func(SnapshotID) string, assets []Asset) (SnapshotID, error) {
+	tx, err := sr.db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx, "INSERT INTO snapshots (url, html) VALUES ($1, '') RETURNING id", url).Scan(&id)
+	if err != nil {
+		sr.logger.Println(err.Error())
+		return "", err
+	}
+	snapshotId := SnapshotID(fmt.Sprint(id))
+
+	_, err = tx.Exec(ctx, "UPDATE snapshots SET html = $1 WHERE id = $2", rewrite(snapshotId), id)
+	if err != nil {
+		sr.logger.Println(err.Error())
+		return "", err
+	}
+
+	for _, asset := range assets {
+		_, err = tx.Exec(
+			ctx,
+			"INSERT INTO snapshot_assets (snapshot_id, url, content_type, body) VALUES ($1, $2, $3, $4)",
+			id,
+			asset.URL,
+			asset.ContentType,
+			asset.Body,
+		)
+		if err != nil {
+			sr.logger.Println(err.Error())
+			return "", err
+		}
+	}
+
+	return snapshotId, tx.Commit(ctx)
+}
+
+func (sr SnapshotRepo) Get(ctx context.Context, id SnapshotID) (Snapshot, error) {
+	snapshot := Snapshot{ID: id}
+	err := sr.db.QueryRow(ctx, "SELECT url, html FROM snapshots WHERE id = $1", id).Scan(&snapshot.URL, &snapshot.HTML)
+	if err == pgx.ErrNoRows {
+		return snapshot, ErrSnapshotNotFound
+	}
+	return snapshot, err
+}
+
+// GetAsset returns the asset of a snapshot at the given position, counting
+// from zero in the order they were saved.
+func (sr SnapshotRepo) GetAsset(ctx context.Context, id SnapshotID, position int) (Asset, error) {
+	var asset Asset
+	err := sr.db.QueryRow(
+		ctx,
+		"SELECT url, content_type, body FROM snapshot_assets WHERE snapshot_id = $1 ORDER BY id OFFSET $2 LIMIT 1",
+		id,
+		position,
+	).Scan(&asset.URL, &asset.ContentType, &asset.Body)
+	if err == pgx.ErrNoRows {
+		return asset, ErrSnapshotNotFound
+	}
+	return asset, err
+}
+
+// Path is where a snapshot is served from.
+func Path(id SnapshotID) string {
+	return "/snapshots/" + string(id)
+}
+
+// AssetPath is where the asset of a snapshot at position is served from.
+func AssetPath(id SnapshotID, position int) string {
+	return fmt.Sprintf("/snapshots/%s/assets/%d", id, position)
+}
@@ -0,0 +1,203 @@
+package tags
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/thrgamon/nous/templates"
+	"github.com/thrgamon/nous/web"
+)
+
+type TreePageData struct {
+	Tree []*Node
+}
+
+func TreeHandler(w http.ResponseWriter, r *http.Request) {
+	tagRepo := NewTagRepo()
+	tags, err := tagRepo.GetAll(r.Context())
+
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	templates.RenderTemplate(w, "tag-tree", TreePageData{Tree: BuildTree(tags)})
+}
+
+type Option struct {
+	Value  string
+	Label  string
+	Reason string
+}
+
+// SuggestHandler suggests tags for the editor. By default it renders datalist
+// options for the tags input; as the input holds comma separated values each
+// option carries the tags already entered so that picking one only replaces
+// the tag being typed. Clients asking for JSON get the ranked suggestions.
+func SuggestHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	entered, current := SplitInput(r.FormValue("tags"))
+	if prefix := r.FormValue("prefix"); prefix != "" {
+		current = prefix
+	}
+
+	tagRepo := NewTagRepo()
+	suggestions, err := tagRepo.Suggest(r.Context(), current, entered, r.FormValue("body"))
+
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(suggestions)
+		return
+	}
+
+	var options []Option
+	for _, suggestion := range suggestions {
+		value := strings.Join(append(append([]string{}, entered...), suggestion.Tag), ", ")
+		options = append(options, Option{Value: value, Label: suggestion.Tag, Reason: suggestion.Reason})
+	}
+
+	templates.RenderTemplate(w, "_tag-options", options)
+}
+
+type ManagePageData struct {
+	Tags  []Tag
+	Error string
+}
+
+func ManageHandler(w http.ResponseWriter, r *http.Request) {
+	renderManage(w, r, "tags", nil)
+}
+
+func RenameHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	r.ParseForm()
+
+	err := NewTagRepo().Rename(r.Context(), TagID(id), r.FormValue("name"))
+	renderManage(w, r, "_tags", err)
+}
+
+func MergeHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	r.ParseForm()
+
+	err := NewTagRepo().MergeByName(r.Context(), TagID(id), r.FormValue("into"), r.FormValue("alias") != "")
+	renderManage(w, r, "_tags", err)
+}
+
+func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := NewTagRepo().Delete(r.Context(), TagID(id))
+	renderManage(w, r, "_tags", err)
+}
+
+func AddAliasHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	r.ParseForm()
+
+	err := NewTagRepo().AddAlias(r.Context(), TagID(id), r.FormValue("alias"))
+	renderManage(w, r, "_tags", err)
+}
+
+func DeleteAliasHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	err := NewTagRepo().DeleteAlias(r.Context(), r.FormValue("alias"))
+	renderManage(w, r, "_tags", err)
+}
+
+// renderManage re-renders the tag list after an action. Mistakes such as
+// deleting a tag that is still in use are shown inline rather than failing
+// the request so that htmx still swaps the list in.
+func renderManage(w http.ResponseWriter, r *http.Request, tmpl string, actionErr error) {
+	pageData := ManagePageData{}
+
+	if actionErr != nil {
+		if !errors.Is(actionErr, ErrTagInUse) && !errors.Is(actionErr, ErrEmptyTag) {
+			web.HandleUnexpectedError(w, actionErr)
+			return
+		}
+		pageData.Error = actionErr.Error()
+	}
+
+	tags, err := NewTagRepo().GetUsage(r.Context())
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+	pageData.Tags = tags
+
+	templates.RenderTemplate(w, tmpl, pageData)
+}
+
+type TypesPageData struct {
+	Types []Type
+	Error string
+}
+
+func TypesHandler(w http.ResponseWriter, r *http.Request) {
+	renderTypes(w, r, nil)
+}
+
+func CreateTypeHandler(w http.ResponseWriter, r *http.Request) {
+	saveType(w, r, 0)
+}
+
+func UpdateTypeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	saveType(w, r, TagType(id))
+}
+
+func saveType(w http.ResponseWriter, r *http.Request, id TagType) {
+	r.ParseForm()
+
+	t, err := NewType(r.FormValue("name"), r.FormValue("colour"), r.FormValue("exclusive") != "", r.FormValue("values"))
+	if err == nil {
+		t.ID = id
+		_, err = NewTypeRepo().Save(r.Context(), t)
+	}
+
+	renderTypes(w, r, err)
+}
+
+func renderTypes(w http.ResponseWriter, r *http.Request, actionErr error) {
+	pageData := TypesPageData{}
+
+	if actionErr != nil {
+		switch {
+		case errors.Is(actionErr, ErrInvalidTypeName),
+			errors.Is(actionErr, ErrInvalidColour),
+			errors.Is(actionErr, ErrDuplicateTagType),
+			errors.Is(actionErr, ErrBuiltInType):
+			pageData.Error = actionErr.Error()
+		default:
+			web.HandleUnexpectedError(w, actionErr)
+			return
+		}
+	}
+
+	types, err := NewTypeRepo().GetAll(r.Context())
+	if err != nil {
+		web.HandleUnexpectedError(w, err)
+		return
+	}
+	pageData.Types = types
+
+	templates.RenderTemplate(w, "tag-types", pageData)
+}
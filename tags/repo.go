@@ -0,0 +1,405 @@
+package tags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/thrgamon/nous/database"
+	"github.com/thrgamon/nous/logger"
+)
+
+type TagID string
+
+type TagType int
+
+const (
+	Category TagType = iota + 1
+	TaskPriority
+	Person
+)
+
+func (t TagType) String() string {
+	switch t {
+	case Category:
+		return "Category"
+	case TaskPriority:
+		return "Task priority"
+	case Person:
+		return "Person"
+	}
+	return fmt.Sprintf("Unknown (%d)", int(t))
+}
+
+type Tag struct {
+	ID       TagID    `json:"id"`
+	Name     string   `json:"tag"`
+	Count    int      `json:"count"`
+	Type     TagType  `json:"type"`
+	TypeName string   `json:"type_name"`
+	Colour   string   `json:"colour"`
+	Aliases  []string `json:"aliases"`
+}
+
+var ErrTagInUse = errors.New("tag is still used by notes")
+var ErrEmptyTag = errors.New("tag cannot be empty")
+
+type TagRepo struct {
+	db     *pgxpool.Pool
+	logger *log.Logger
+}
+
+func NewTagRepo() *TagRepo {
+	db := database.Database
+	logger := logger.Logger
+	return &TagRepo{db: db, logger: logger}
+}
+
+// GetAll returns every tag that is attached to at least one note along with
+// the number of notes using it.
+func (tr TagRepo) GetAll(ctx context.Context) ([]Tag, error) {
+	rows, err := tr.db.Query(
+		ctx,
+		`SELECT
+	tags.id,
+	tags.tag,
+	count(notetags.note_id)
+FROM
+	tags
+	JOIN notetags ON tags.id = notetags.tag_id
+GROUP BY
+	tags.id
+ORDER BY
+	tags.tag`,
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		tr.logger.Println(err.Error())
+		return []Tag{}, err
+	}
+
+	return tr.parseData(rows)
+}
+
+// Suggest returns tags to offer in the editor. Tags starting with prefix are
+// ranked by how often and how recently they were used. Without a prefix only
+// tags that co-occur with the words in body or with the entered tags are
+// suggested.
+func (tr TagRepo) Suggest(ctx context.Context, prefix string, entered []string, body string) ([]Suggestion, error) {
+	enteredTags := []string{}
+	for _, tag := range entered {
+		if tag = Normalise(tag); tag != "" {
+			enteredTags = append(enteredTags, tag)
+		}
+	}
+	prefix = Normalise(prefix)
+
+	rows, err := tr.db.Query(
+		ctx,
+		`WITH word_matches AS (
+	SELECT id FROM note_search
+	WHERE $3 <> '' AND doc @@ to_tsquery(search_language(), $3)
+	ORDER BY ts_rank(doc, to_tsquery(search_language(), $3)) DESC
+	LIMIT 50
+), tag_matches AS (
+	SELECT DISTINCT notetags.note_id AS id
+	FROM notetags JOIN tags ON tags.id = notetags.tag_id
+	WHERE tags.tag = ANY($2)
+)
+SELECT
+	tags.tag,
+	count(notetags.note_id),
+	tags.updated_at,
+	count(notetags.note_id) FILTER (WHERE notetags.note_id IN (SELECT id FROM word_matches)),
+	count(notetags.note_id) FILTER (WHERE notetags.note_id IN (SELECT id FROM tag_matches))
+FROM
+	tags
+	JOIN notetags ON tags.id = notetags.tag_id
+WHERE
+	tags.type = $4
+	AND NOT tags.tag = ANY($2)
+	AND left(tags.tag, length($1)) = $1
+GROUP BY
+	tags.id
+HAVING
+	$1 <> ''
+	OR count(notetags.note_id) FILTER (WHERE notetags.note_id IN (SELECT id FROM word_matches)) > 0
+	OR count(notetags.note_id) FILTER (WHERE notetags.note_id IN (SELECT id FROM tag_matches)) > 0`,
+		prefix,
+		enteredTags,
+		BodyQuery(body),
+		Category,
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		tr.logger.Println(err.Error())
+		return []Suggestion{}, err
+	}
+
+	var candidates []Candidate
+	for rows.Next() {
+		var c Candidate
+		if err := rows.Scan(&c.Tag, &c.Count, &c.UpdatedAt, &c.WordHits, &c.TagHits); err != nil {
+			tr.logger.Println(err.Error())
+			return []Suggestion{}, err
+		}
+		candidates = append(candidates, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []Suggestion{}, err
+	}
+
+	return Rank(candidates, time.Now()), nil
+}
+
+// GetUsage returns every tag, including the unused ones, with its type,
+// aliases and the number of notes using it.
+func (tr TagRepo) GetUsage(ctx context.Context) ([]Tag, error) {
+	var tags []Tag
+	rows, err := tr.db.Query(
+		ctx,
+		`SELECT
+	tags.id,
+	tags.tag,
+	tags.type,
+	tag_types.name,
+	tag_types.colour,
+	(SELECT count(*) FROM notetags WHERE notetags.tag_id = tags.id),
+	coalesce((SELECT array_agg(alias ORDER BY alias) FROM tag_aliases WHERE tag_aliases.tag_id = tags.id), '{}')
+FROM
+	tags
+	JOIN tag_types ON tag_types.id = tags.type
+ORDER BY
+	tags.tag`,
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		tr.logger.Println(err.Error())
+		return tags, err
+	}
+
+	for rows.Next() {
+		var id int
+		var tag Tag
+		err := rows.Scan(&id, &tag.Name, &tag.Type, &tag.TypeName, &tag.Colour, &tag.Count, &tag.Aliases)
+
+		if err != nil {
+			tr.logger.Println(err.Error())
+			return tags, err
+		}
+
+		tag.ID = TagID(fmt.Sprint(id))
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// Rename changes the name of a tag. Renaming onto a tag that already exists
+// merges the two.
+func (tr TagRepo) Rename(ctx context.Context, id TagID, name string) error {
+	name = Normalise(name)
+	if name == "" {
+		return ErrEmptyTag
+	}
+
+	return tr.withTransaction(ctx, func(tx pgx.Tx) error {
+		var existingId int
+		err := tx.QueryRow(ctx, "SELECT id FROM tags WHERE tag = $1", name).Scan(&existingId)
+
+		switch {
+		case err == pgx.ErrNoRows:
+			_, err = tx.Exec(ctx, "UPDATE tags SET tag = $1, updated_at = NOW() WHERE id = $2", name, id)
+			if err != nil {
+				return err
+			}
+			return tr.reindex(ctx, tx, id)
+		case err != nil:
+			return err
+		case TagID(fmt.Sprint(existingId)) == id:
+			return nil
+		}
+
+		return tr.merge(ctx, tx, id, TagID(fmt.Sprint(existingId)), false)
+	})
+}
+
+// Merge moves every note from one tag onto another and removes the old tag.
+// When keepAlias is set the old name keeps pointing at the new tag so that
+// it is normalised whenever it is used again.
+func (tr TagRepo) Merge(ctx context.Context, from TagID, into TagID, keepAlias bool) error {
+	if from == into {
+		return nil
+	}
+
+	return tr.withTransaction(ctx, func(tx pgx.Tx) error {
+		return tr.merge(ctx, tx, from, into, keepAlias)
+	})
+}
+
+// MergeByName merges a tag into the tag with the given name, creating it if it
+// doesn't exist yet.
+func (tr TagRepo) MergeByName(ctx context.Context, from TagID, into string, keepAlias bool) error {
+	into = Normalise(into)
+	if into == "" {
+		return ErrEmptyTag
+	}
+
+	return tr.withTransaction(ctx, func(tx pgx.Tx) error {
+		var intoId int
+		err := tx.QueryRow(ctx, "INSERT INTO tags (tag) VALUES ($1) ON CONFLICT (tag) DO UPDATE SET updated_at = NOW() RETURNING id", into).Scan(&intoId)
+		if err != nil {
+			return err
+		}
+
+		if TagID(fmt.Sprint(intoId)) == from {
+			return nil
+		}
+
+		return tr.merge(ctx, tx, from, TagID(fmt.Sprint(intoId)), keepAlias)
+	})
+}
+
+func (tr TagRepo) merge(ctx context.Context, tx pgx.Tx, from TagID, into TagID, keepAlias bool) error {
+	statements := []string{
+		"INSERT INTO notetags (note_id, tag_id) SELECT note_id, $2 FROM notetags WHERE tag_id = $1 ON CONFLICT DO NOTHING",
+		"DELETE FROM notetags WHERE tag_id = $1",
+		"UPDATE tag_aliases SET tag_id = $2 WHERE tag_id = $1",
+	}
+
+	if keepAlias {
+		statements = append(statements, "INSERT INTO tag_aliases (alias, tag_id) SELECT tag, $2 FROM tags WHERE id = $1 ON CONFLICT (alias) DO UPDATE SET tag_id = EXCLUDED.tag_id")
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, from, into); err != nil {
+			tr.logger.Println(err.Error())
+			return err
+		}
+	}
+
+	_, err := tx.Exec(ctx, "DELETE FROM tags WHERE id = $1", from)
+	if err != nil {
+		return err
+	}
+
+	return tr.reindex(ctx, tx, into)
+}
+
+// reindex rebuilds the search rows of every note with the tag, for when the
+// tag itself changes rather than a note.
+func (tr TagRepo) reindex(ctx context.Context, tx pgx.Tx, id TagID) error {
+	_, err := tx.Exec(ctx, "SELECT index_note(note_id) FROM notetags WHERE tag_id = $1", id)
+	if err != nil {
+		tr.logger.Println(err.Error())
+	}
+	return err
+}
+
+// Delete removes a tag as long as no note is using it.
+func (tr TagRepo) Delete(ctx context.Context, id TagID) error {
+	return tr.withTransaction(ctx, func(tx pgx.Tx) error {
+		var inUse bool
+		err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM notetags WHERE tag_id = $1)", id).Scan(&inUse)
+		if err != nil {
+			return err
+		}
+
+		if inUse {
+			return ErrTagInUse
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM tags WHERE id = $1", id)
+		return err
+	})
+}
+
+// AddAlias makes alias normalise to the given tag. If alias is already a tag
+// in its own right it is merged into the target.
+func (tr TagRepo) AddAlias(ctx context.Context, id TagID, alias string) error {
+	alias = Normalise(alias)
+	if alias == "" {
+		return ErrEmptyTag
+	}
+
+	return tr.withTransaction(ctx, func(tx pgx.Tx) error {
+		var existingId int
+		err := tx.QueryRow(ctx, "SELECT id FROM tags WHERE tag = $1", alias).Scan(&existingId)
+
+		switch {
+		case err == pgx.ErrNoRows:
+			_, err = tx.Exec(ctx, "INSERT INTO tag_aliases (alias, tag_id) VALUES ($1, $2) ON CONFLICT (alias) DO UPDATE SET tag_id = EXCLUDED.tag_id", alias, id)
+			return err
+		case err != nil:
+			return err
+		case TagID(fmt.Sprint(existingId)) == id:
+			return nil
+		}
+
+		return tr.merge(ctx, tx, TagID(fmt.Sprint(existingId)), id, true)
+	})
+}
+
+func (tr TagRepo) DeleteAlias(ctx context.Context, alias string) error {
+	_, err := tr.db.Exec(ctx, "DELETE FROM tag_aliases WHERE alias = $1", alias)
+	return err
+}
+
+func (tr TagRepo) withTransaction(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := tr.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (tr TagRepo) parseData(rows pgx.Rows) ([]Tag, error) {
+	var tags []Tag
+
+	for rows.Next() {
+		var id int
+		var name string
+		var count int
+		err := rows.Scan(&id, &name, &count)
+
+		if err != nil {
+			tr.logger.Println(err.Error())
+			return tags, err
+		}
+
+		tags = append(tags, Tag{ID: TagID(fmt.Sprint(id)), Name: name, Count: count})
+	}
+
+	return tags, rows.Err()
+}
+
+// SplitInput breaks the free-text tags field of the editor into the tags
+// already entered and the one currently being typed.
+func SplitInput(input string) (entered []string, current string) {
+	parts := strings.Split(input, ",")
+	for _, part := range parts[:len(parts)-1] {
+		if tag := strings.TrimSpace(part); tag != "" {
+			entered = append(entered, tag)
+		}
+	}
+	return entered, strings.TrimSpace(parts[len(parts)-1])
+}
@@ -0,0 +1,97 @@
+package tags
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Candidate is a tag that could be suggested along with the signals used to
+// rank it.
+type Candidate struct {
+	Tag       string
+	Count     int
+	UpdatedAt time.Time
+	// WordHits is the number of notes sharing words with the body being
+	// written that carry this tag
+	WordHits int
+	// TagHits is the number of notes carrying both this tag and one of the
+	// tags already entered
+	TagHits int
+}
+
+type Suggestion struct {
+	Tag    string  `json:"tag"`
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+const (
+	recencyHalfLife = 30 * 24 * time.Hour
+	maxSuggestions  = 20
+	maxBodyWords    = 30
+)
+
+// Rank orders candidates so that tags used often and recently come first,
+// with a boost for tags that co-occur with the body or the entered tags.
+func Rank(candidates []Candidate, now time.Time) []Suggestion {
+	var suggestions []Suggestion
+	for _, c := range candidates {
+		age := now.Sub(c.UpdatedAt)
+		if age < 0 {
+			age = 0
+		}
+		recency := 1 / (1 + float64(age)/float64(recencyHalfLife))
+		frequency := math.Log1p(float64(c.Count))
+
+		score := frequency + 2*recency + float64(c.WordHits) + 1.5*float64(c.TagHits)
+
+		reason := "used recently"
+		switch {
+		case c.TagHits > 0 && c.TagHits >= c.WordHits:
+			reason = "used with the tags entered"
+		case c.WordHits > 0:
+			reason = "used on notes with similar words"
+		case c.Count > 0 && frequency > 2*recency:
+			reason = "used often"
+		}
+
+		suggestions = append(suggestions, Suggestion{Tag: c.Tag, Score: score, Reason: reason})
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Score == suggestions[j].Score {
+			return suggestions[i].Tag < suggestions[j].Tag
+		}
+		return suggestions[i].Score > suggestions[j].Score
+	})
+
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+
+	return suggestions
+}
+
+var wordRe = regexp.MustCompile(`[\p{L}\p{N}]{3,}`)
+
+// BodyQuery turns the body of a note into a tsquery matching any of its
+// distinct words. Only letters and digits survive so the result is always
+// valid tsquery syntax.
+func BodyQuery(body string) string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, word := range wordRe.FindAllString(strings.ToLower(body), -1) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+		if len(words) == maxBodyWords {
+			break
+		}
+	}
+	return strings.Join(words, " | ")
+}
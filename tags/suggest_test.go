@@ -0,0 +1,29 @@
+package tags
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRank(t *testing.T) {
+	now := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	suggestions := Rank([]Candidate{
+		{Tag: "old", Count: 40, UpdatedAt: now.AddDate(-1, 0, 0)},
+		{Tag: "fresh", Count: 2, UpdatedAt: now.Add(-time.Hour)},
+		{Tag: "related", Count: 2, UpdatedAt: now.AddDate(0, -2, 0), TagHits: 3},
+	}, now)
+
+	assert.Equal(t, "related", suggestions[0].Tag)
+	assert.Equal(t, "used with the tags entered", suggestions[0].Reason)
+	assert.Equal(t, "old", suggestions[1].Tag)
+	assert.Equal(t, "used often", suggestions[1].Reason)
+	assert.Equal(t, "fresh", suggestions[2].Tag)
+	assert.Equal(t, "used recently", suggestions[2].Reason)
+}
+
+func TestBodyQuery(t *testing.T) {
+	assert.Equal(t, "meeting | with | tom | about | the | api", BodyQuery("Meeting with @tom about the API: the (api) & it!"))
+	assert.Equal(t, "", BodyQuery("a ! b"))
+}
@@ -0,0 +1,73 @@
+package tags
+
+import (
+	"sort"
+	"strings"
+)
+
+// Separator splits a hierarchical tag such as `project/nous/api` into its
+// segments.
+const Separator = "/"
+
+// Normalise lower-cases a tag and tidies up the whitespace and separators
+// around each segment so that `Project / Nous/` and `project/nous` are the
+// same tag.
+func Normalise(tag string) string {
+	var segments []string
+	for _, segment := range strings.Split(strings.ToLower(tag), Separator) {
+		segment = strings.TrimSpace(segment)
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, Separator)
+}
+
+// Node is a single segment in the tag tree. Count is the number of notes
+// tagged with exactly this path, Total includes every descendant as well.
+type Node struct {
+	Name     string
+	Path     string
+	Count    int
+	Total    int
+	Children []*Node
+}
+
+// BuildTree arranges flat tags into a tree keyed on their segments. Parents
+// that have never been used as a tag themselves are still created so that
+// the tree can be browsed.
+func BuildTree(tags []Tag) []*Node {
+	root := &Node{}
+	for _, tag := range tags {
+		node := root
+		var path []string
+		for _, segment := range strings.Split(tag.Name, Separator) {
+			path = append(path, segment)
+			node = node.child(segment, strings.Join(path, Separator))
+			node.Total += tag.Count
+		}
+		node.Count += tag.Count
+	}
+	root.sort()
+	return root.Children
+}
+
+func (n *Node) child(name string, path string) *Node {
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	child := &Node{Name: name, Path: path}
+	n.Children = append(n.Children, child)
+	return child
+}
+
+func (n *Node) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, child := range n.Children {
+		child.sort()
+	}
+}
@@ -0,0 +1,47 @@
+package tags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalise(t *testing.T) {
+	assert.Equal(t, "project/nous/api", Normalise(" Project / Nous//API/ "))
+	assert.Equal(t, "to read", Normalise("To Read"))
+	assert.Equal(t, "", Normalise(" / "))
+}
+
+func TestBuildTree(t *testing.T) {
+	tree := BuildTree([]Tag{
+		{Name: "project/nous/api", Count: 2},
+		{Name: "home", Count: 1},
+		{Name: "project/nous", Count: 1},
+		{Name: "project/garden", Count: 3},
+	})
+
+	assert.Len(t, tree, 2)
+	assert.Equal(t, "home", tree[0].Path)
+
+	project := tree[1]
+	assert.Equal(t, "project", project.Path)
+	assert.Equal(t, 0, project.Count)
+	assert.Equal(t, 6, project.Total)
+	assert.Equal(t, "project/garden", project.Children[0].Path)
+
+	nous := project.Children[1]
+	assert.Equal(t, 1, nous.Count)
+	assert.Equal(t, 3, nous.Total)
+	assert.Equal(t, "api", nous.Children[0].Name)
+	assert.Equal(t, "project/nous/api", nous.Children[0].Path)
+}
+
+func TestSplitInput(t *testing.T) {
+	entered, current := SplitInput("home, to read, proj")
+	assert.Equal(t, []string{"home", "to read"}, entered)
+	assert.Equal(t, "proj", current)
+
+	entered, current = SplitInput("")
+	assert.Empty(t, entered)
+	assert.Equal(t, "", current)
+}
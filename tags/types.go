@@ -0,0 +1,388 @@
+package tags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/thrgamon/nous/database"
+	"github.com/thrgamon/nous/logger"
+)
+
+var (
+	ErrUnknownType      = errors.New("unknown tag type")
+	ErrNotExclusive     = errors.New("tag type is not exclusive")
+	ErrValueNotAllowed  = errors.New("value is not allowed for this tag type")
+	ErrInvalidTypeName  = errors.New("tag type names must be a single word")
+	ErrInvalidColour    = errors.New("colours must look like #a1b2c3")
+	ErrDuplicateTagType = errors.New("a tag type with that name already exists")
+	ErrBuiltInType      = errors.New("only the colour of a built-in tag type can be changed")
+	ErrTagTypeTaken     = errors.New("a tag with that name already has another type")
+)
+
+// Type describes a kind of tag such as a priority or a status. Exclusive
+// types allow a single value per note. When Values is empty any value is
+// allowed.
+type Type struct {
+	ID        TagType  `json:"id"`
+	Name      string   `json:"name"`
+	Colour    string   `json:"colour"`
+	Exclusive bool     `json:"exclusive"`
+	Values    []string `json:"values"`
+}
+
+// BuiltIn reports whether t is one of the types the app relies on, such as
+// the task priorities the kanban board is built from.
+func (t Type) BuiltIn() bool {
+	return t.ID >= Category && t.ID <= Person
+}
+
+// sameDefinition reports whether t and other differ in nothing but colour.
+func (t Type) sameDefinition(other Type) bool {
+	if t.Name != other.Name || t.Exclusive != other.Exclusive || len(t.Values) != len(other.Values) {
+		return false
+	}
+	for i := range t.Values {
+		if t.Values[i] != other.Values[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveType decides the type a tag ends up with when a note is given it as
+// adding, where current is the type it already has, if any. It also reports
+// whether the note should be given the tag at all. A tag of an exclusive type
+// such as a priority keeps its type, and only a plain tag can pick it, so
+// mentioning @urgent never sets a priority. A plain tag never takes away a
+// more specific type.
+func ResolveType(current TagType, currentExclusive bool, adding TagType) (TagType, bool) {
+	switch {
+	case current == 0:
+		return adding, true
+	case currentExclusive:
+		return current, adding == Category
+	case adding == Category:
+		return current, true
+	default:
+		return adding, true
+	}
+}
+
+// TagName is the tag a note carries for one of the type's values. Values are
+// kept under the type's name, as in status/done, so they never take the name
+// of an ordinary tag. Priorities predate this and are tags of their own.
+func (t Type) TagName(value string) string {
+	if t.ID == TaskPriority || value == "" {
+		return value
+	}
+	return t.Name + Separator + value
+}
+
+// ValueOf is the value a tag made by TagName stands for.
+func (t Type) ValueOf(tag string) string {
+	if t.ID == TaskPriority {
+		return tag
+	}
+	return strings.TrimPrefix(tag, t.Name+Separator)
+}
+
+// Value checks a value against the allowed set, returning the value as it is
+// spelt in the set.
+func (t Type) Value(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if len(t.Values) == 0 {
+		if value = Normalise(value); value == "" {
+			return "", ErrEmptyTag
+		}
+		return value, nil
+	}
+
+	for _, allowed := range t.Values {
+		if strings.EqualFold(allowed, value) {
+			return allowed, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %q is not one of %s", ErrValueNotAllowed, value, strings.Join(t.Values, ", "))
+}
+
+var typeNameRe = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+var colourRe = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// NewType validates and tidies up a tag type entered by a user.
+func NewType(name string, colour string, exclusive bool, values string) (Type, error) {
+	t := Type{
+		Name:      strings.ToLower(strings.TrimSpace(name)),
+		Colour:    strings.TrimSpace(colour),
+		Exclusive: exclusive,
+	}
+
+	if !typeNameRe.MatchString(t.Name) {
+		return t, ErrInvalidTypeName
+	}
+
+	if !colourRe.MatchString(t.Colour) {
+		return t, ErrInvalidColour
+	}
+
+	seen := make(map[string]bool)
+	for _, value := range strings.Split(values, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" || seen[strings.ToLower(value)] {
+			continue
+		}
+		seen[strings.ToLower(value)] = true
+		t.Values = append(t.Values, value)
+	}
+
+	return t, nil
+}
+
+// Column is an allowed value of a type along with how many notes may carry
+// it at once. A WIPLimit of zero means there is no limit.
+type Column struct {
+	Value    string `json:"value"`
+	WIPLimit int    `json:"wip_limit"`
+}
+
+var ErrInvalidWIPLimit = errors.New("WIP limits cannot be negative")
+
+type TypeRepo struct {
+	db     *pgxpool.Pool
+	logger *log.Logger
+}
+
+func NewTypeRepo() *TypeRepo {
+	db := database.Database
+	logger := logger.Logger
+	return &TypeRepo{db: db, logger: logger}
+}
+
+const selectTypes = `SELECT
+	tag_types.id,
+	tag_types.name,
+	tag_types.colour,
+	tag_types.exclusive,
+	coalesce((SELECT array_agg(value ORDER BY position, id) FROM tag_type_values WHERE type_id = tag_types.id), '{}')
+FROM
+	tag_types`
+
+func (tr TypeRepo) GetAll(ctx context.Context) ([]Type, error) {
+	rows, err := tr.db.Query(ctx, selectTypes+" ORDER BY tag_types.id")
+
+	defer rows.Close()
+
+	if err != nil {
+		tr.logger.Println(err.Error())
+		return []Type{}, err
+	}
+
+	return tr.parseData(rows)
+}
+
+func (tr TypeRepo) Get(ctx context.Context, id TagType) (Type, error) {
+	rows, err := tr.db.Query(ctx, selectTypes+" WHERE tag_types.id = $1", id)
+
+	defer rows.Close()
+
+	if err != nil {
+		tr.logger.Println(err.Error())
+		return Type{}, err
+	}
+
+	return tr.parseOne(rows)
+}
+
+func (tr TypeRepo) GetByName(ctx context.Context, name string) (Type, error) {
+	rows, err := tr.db.Query(ctx, selectTypes+" WHERE tag_types.name = $1", strings.ToLower(name))
+
+	defer rows.Close()
+
+	if err != nil {
+		tr.logger.Println(err.Error())
+		return Type{}, err
+	}
+
+	return tr.parseOne(rows)
+}
+
+// Save creates a tag type, or updates it when it has an ID. The allowed
+// values are replaced wholesale and kept in the order given. Built-in types
+// may only have their colour changed.
+func (tr TypeRepo) Save(ctx context.Context, t Type) (TagType, error) {
+	tx, err := tr.db.Begin(ctx)
+	if err != nil {
+		return t.ID, err
+	}
+	defer tx.Rollback(ctx)
+
+	if t.BuiltIn() {
+		rows, err := tx.Query(ctx, selectTypes+" WHERE tag_types.id = $1 FOR UPDATE", t.ID)
+		if err != nil {
+			return t.ID, err
+		}
+		current, err := tr.parseOne(rows)
+		rows.Close()
+		if err != nil {
+			return t.ID, err
+		}
+		if !t.sameDefinition(current) {
+			return t.ID, ErrBuiltInType
+		}
+	}
+
+	var exists bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM tag_types WHERE name = $1 AND id <> $2)", t.Name, t.ID).Scan(&exists)
+	if err != nil {
+		return t.ID, err
+	}
+	if exists {
+		return t.ID, ErrDuplicateTagType
+	}
+
+	if t.ID != 0 {
+		// The tags of the type's values are named after it
+		renamed, err := tx.Exec(
+			ctx,
+			`UPDATE tags SET tag = $1 || substr(tags.tag, length(tag_types.name) + 1), updated_at = NOW()
+			FROM tag_types
+			WHERE tag_types.id = $2 AND tags.type = tag_types.id AND tag_types.name <> $1
+				AND left(tags.tag, length(tag_types.name) + 1) = tag_types.name || '/'`,
+			t.Name, t.ID,
+		)
+		if err != nil {
+			tr.logger.Println(err.Error())
+			return t.ID, err
+		}
+
+		if renamed.RowsAffected() > 0 {
+			_, err = tx.Exec(ctx, "SELECT index_note(note_id) FROM notetags JOIN tags ON tags.id = notetags.tag_id WHERE tags.type = $1", t.ID)
+			if err != nil {
+				tr.logger.Println(err.Error())
+				return t.ID, err
+			}
+		}
+	}
+
+	if t.ID == 0 {
+		err = tx.QueryRow(
+			ctx,
+			"INSERT INTO tag_types (name, colour, exclusive) VALUES ($1, $2, $3) RETURNING id",
+			t.Name, t.Colour, t.Exclusive,
+		).Scan(&t.ID)
+	} else {
+		_, err = tx.Exec(
+			ctx,
+			"UPDATE tag_types SET name = $1, colour = $2, exclusive = $3, updated_at = NOW() WHERE id = $4",
+			t.Name, t.Colour, t.Exclusive, t.ID,
+		)
+	}
+	if err != nil {
+		tr.logger.Println(err.Error())
+		return t.ID, err
+	}
+
+	values := t.Values
+	if values == nil {
+		values = []string{}
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM tag_type_values WHERE type_id = $1 AND NOT value = ANY($2)", t.ID, values)
+	if err != nil {
+		return t.ID, err
+	}
+
+	for position, value := range t.Values {
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO tag_type_values (type_id, value, position) VALUES ($1, $2, $3)
+			ON CONFLICT (type_id, value) DO UPDATE SET position = EXCLUDED.position`,
+			t.ID, value, position,
+		)
+		if err != nil {
+			return t.ID, err
+		}
+	}
+
+	return t.ID, tx.Commit(ctx)
+}
+
+// GetColumns returns the allowed values of a type in order, with their WIP
+// limits.
+func (tr TypeRepo) GetColumns(ctx context.Context, id TagType) ([]Column, error) {
+	var columns []Column
+	rows, err := tr.db.Query(
+		ctx,
+		"SELECT value, coalesce(wip_limit, 0) FROM tag_type_values WHERE type_id = $1 ORDER BY position, id",
+		id,
+	)
+
+	defer rows.Close()
+
+	if err != nil {
+		tr.logger.Println(err.Error())
+		return columns, err
+	}
+
+	for rows.Next() {
+		var column Column
+		if err := rows.Scan(&column.Value, &column.WIPLimit); err != nil {
+			tr.logger.Println(err.Error())
+			return columns, err
+		}
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
+
+func (tr TypeRepo) SetWIPLimit(ctx context.Context, id TagType, value string, limit int) error {
+	if limit < 0 {
+		return ErrInvalidWIPLimit
+	}
+
+	_, err := tr.db.Exec(
+		ctx,
+		"UPDATE tag_type_values SET wip_limit = NULLIF($1, 0) WHERE type_id = $2 AND value = $3",
+		limit,
+		id,
+		value,
+	)
+	return err
+}
+
+func (tr TypeRepo) parseOne(rows pgx.Rows) (Type, error) {
+	types, err := tr.parseData(rows)
+	if err != nil {
+		return Type{}, err
+	}
+
+	if len(types) == 0 {
+		return Type{}, ErrUnknownType
+	}
+
+	return types[0], nil
+}
+
+func (tr TypeRepo) parseData(rows pgx.Rows) ([]Type, error) {
+	var types []Type
+
+	for rows.Next() {
+		var t Type
+		err := rows.Scan(&t.ID, &t.Name, &t.Colour, &t.Exclusive, &t.Values)
+
+		if err != nil {
+			tr.logger.Println(err.Error())
+			return types, err
+		}
+
+		types = append(types, t)
+	}
+
+	return types, rows.Err()
+}
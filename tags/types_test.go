@@ -0,0 +1,91 @@
+package tags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypeValue(t *testing.T) {
+	status := Type{Name: "status", Exclusive: true, Values: []string{"Backlog", "Doing", "Done"}}
+
+	value, err := status.Value(" doing ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Doing", value)
+
+	_, err = status.Value("archived")
+	assert.ErrorIs(t, err, ErrValueNotAllowed)
+
+	area := Type{Name: "area"}
+	value, err = area.Value("Home / Garden")
+	assert.NoError(t, err)
+	assert.Equal(t, "home/garden", value)
+
+	_, err = area.Value(" ")
+	assert.ErrorIs(t, err, ErrEmptyTag)
+}
+
+func TestNewType(t *testing.T) {
+	status, err := NewType(" Status ", "#AA3311", true, "backlog, doing,, Doing, done")
+	assert.NoError(t, err)
+	assert.Equal(t, "status", status.Name)
+	assert.Equal(t, []string{"backlog", "doing", "done"}, status.Values)
+
+	_, err = NewType("two words", "#aa3311", false, "")
+	assert.ErrorIs(t, err, ErrInvalidTypeName)
+
+	_, err = NewType("area", "red", false, "")
+	assert.ErrorIs(t, err, ErrInvalidColour)
+}
+
+func TestResolveType(t *testing.T) {
+	// A mention of a priority value is not a priority or a person
+	tagType, apply := ResolveType(TaskPriority, true, Person)
+	assert.Equal(t, TaskPriority, tagType)
+	assert.False(t, apply)
+
+	tagType, apply = ResolveType(TaskPriority, true, Category)
+	assert.Equal(t, TaskPriority, tagType)
+	assert.True(t, apply)
+
+	tagType, apply = ResolveType(Category, false, Person)
+	assert.Equal(t, Person, tagType)
+	assert.True(t, apply)
+
+	tagType, apply = ResolveType(Person, false, Category)
+	assert.Equal(t, Person, tagType)
+	assert.True(t, apply)
+
+	tagType, apply = ResolveType(0, false, Person)
+	assert.Equal(t, Person, tagType)
+	assert.True(t, apply)
+}
+
+func TestTypeTagName(t *testing.T) {
+	status := Type{ID: Person + 1, Name: "status", Exclusive: true, Values: []string{"done"}}
+	assert.Equal(t, "status/done", status.TagName("done"))
+	assert.Equal(t, "done", status.ValueOf("status/done"))
+	assert.Equal(t, "", status.TagName(""))
+
+	priority := Type{ID: TaskPriority, Name: "priority", Exclusive: true}
+	assert.Equal(t, "Urgent", priority.TagName("Urgent"))
+	assert.Equal(t, "Urgent", priority.ValueOf("Urgent"))
+}
+
+func TestTypeSameDefinition(t *testing.T) {
+	priority := Type{ID: TaskPriority, Name: "priority", Colour: "#aa3311", Exclusive: true, Values: []string{"high", "low"}}
+	assert.True(t, priority.BuiltIn())
+	assert.False(t, Type{ID: Person + 1}.BuiltIn())
+
+	recoloured := priority
+	recoloured.Colour = "#112233"
+	assert.True(t, recoloured.sameDefinition(priority))
+
+	trimmed := priority
+	trimmed.Values = []string{"high"}
+	assert.False(t, trimmed.sameDefinition(priority))
+
+	shared := priority
+	shared.Exclusive = false
+	assert.False(t, shared.sameDefinition(priority))
+}
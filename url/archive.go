@@ -0,0 +1,148 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thrgamon/nous/links"
+	"github.com/thrgamon/nous/logger"
+)
+
+const (
+	archiveStatusURL = "https://web.archive.org/save/status/"
+	// How often to look for pending archive jobs
+	archivePollInterval = time.Minute
+	// The gap left between status requests, to stay well inside the
+	// archive's rate limits
+	archiveRequestSpacing = 5 * time.Second
+	// How long to back off when rate limited without being told how long
+	archiveDefaultBackoff = 5 * time.Minute
+	archiveBatchSize      = 20
+)
+
+// archiveStore is the part of LinkRepo the poller needs.
+type archiveStore interface {
+	GetPendingArchives(ctx context.Context, limit int) ([]links.Link, error)
+	EditArchiveURL(ctx context.Context, id links.LinkID, archiveURL string) error
+	EditArchiveStatus(ctx context.Context, id links.LinkID, status links.ArchiveStatus, jobID string, exception string) error
+	MarkArchiveChecked(ctx context.Context, id links.LinkID) error
+}
+
+// ArchivePoller follows up links submitted to archivers that work in the
+// background until their jobs finish, recording the snapshot or the failure.
+type ArchivePoller struct {
+	store     archiveStore
+	checkers  map[string]JobChecker
+	interval  time.Duration
+	spacing   time.Duration
+	batchSize int
+
+	pausedUntil time.Time
+}
+
+func NewArchivePoller() *ArchivePoller {
+	return &ArchivePoller{
+		store:     links.NewLinkRepo(),
+		checkers:  map[string]JobChecker{ArchiverWayback: NewWaybackArchiver()},
+		interval:  archivePollInterval,
+		spacing:   archiveRequestSpacing,
+		batchSize: archiveBatchSize,
+	}
+}
+
+// Run polls until ctx is cancelled.
+func (p *ArchivePoller) Run(ctx context.Context) {
+	for {
+		if err := p.Poll(ctx); err != nil && ctx.Err() == nil {
+			logger.Logger.Println(err.Error())
+		}
+
+		wait := p.interval
+		if until := time.Until(p.pausedUntil); until > wait {
+			wait = until
+		}
+		if !sleep(ctx, wait) {
+			return
+		}
+	}
+}
+
+// Poll checks a batch of pending archive jobs. If the archive rate limits
+// us it stops, and further polls do nothing until the archive said to try
+// again.
+func (p *ArchivePoller) Poll(ctx context.Context) error {
+	if time.Now().Before(p.pausedUntil) {
+		return nil
+	}
+
+	pending, err := p.store.GetPendingArchives(ctx, p.batchSize)
+	if err != nil {
+		return err
+	}
+
+	for i, link := range pending {
+		if i > 0 && !sleep(ctx, p.spacing) {
+			return ctx.Err()
+		}
+
+		checker, ok := p.checkers[link.Archiver]
+		if !ok {
+			err = fmt.Errorf("%w: %s can't be checked", ErrUnknownArchiver, link.Archiver)
+			if err = p.store.EditArchiveStatus(ctx, link.LinkID, links.Error, link.ArchiveJobID, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		archiveURL, err := checker.CheckJob(ctx, link.ArchiveJobID)
+
+		var rateLimit *RateLimitError
+		switch {
+		case errors.As(err, &rateLimit):
+			backoff := rateLimit.RetryAfter
+			if backoff == 0 {
+				backoff = archiveDefaultBackoff
+			}
+			p.pausedUntil = time.Now().Add(backoff)
+			return err
+		case errors.Is(err, ErrArchiveFailed):
+			err = p.store.EditArchiveStatus(ctx, link.LinkID, links.Error, link.ArchiveJobID, err.Error())
+		case err != nil:
+			// Probably temporary, try again next time
+			logger.Logger.Println(err.Error())
+			err = p.store.MarkArchiveChecked(ctx, link.LinkID)
+		case archiveURL == "":
+			err = p.store.MarkArchiveChecked(ctx, link.LinkID)
+		default:
+			err = p.store.EditArchiveURL(ctx, link.LinkID, archiveURL)
+			if err == nil {
+				err = p.store.EditArchiveStatus(ctx, link.LinkID, links.Success, link.ArchiveJobID, "")
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sleep waits for d, returning false if ctx was cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
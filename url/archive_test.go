@@ -0,0 +1,145 @@
+package url
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thrgamon/nous/links"
+	"github.com/thrgamon/nous/logger"
+)
+
+type memoryLinks struct {
+	links   []links.Link
+	checked map[links.LinkID]int
+}
+
+func (m *memoryLinks) GetPendingArchives(ctx context.Context, limit int) ([]links.Link, error) {
+	var pending []links.Link
+	for _, link := range m.links {
+		if link.ArchiveStatus == int(links.Pending) {
+			pending = append(pending, link)
+		}
+	}
+	return pending, nil
+}
+
+func (m *memoryLinks) find(id links.LinkID) *links.Link {
+	for i := range m.links {
+		if m.links[i].LinkID == id {
+			return &m.links[i]
+		}
+	}
+	return nil
+}
+
+func (m *memoryLinks) EditArchiveURL(ctx context.Context, id links.LinkID, archiveURL string) error {
+	m.find(id).ArchiveURL = archiveURL
+	return nil
+}
+
+func (m *memoryLinks) EditArchiveStatus(ctx context.Context, id links.LinkID, status links.ArchiveStatus, jobID string, exception string) error {
+	link := m.find(id)
+	link.ArchiveStatus = int(status)
+	link.ArchiveException = exception
+	return nil
+}
+
+func (m *memoryLinks) MarkArchiveChecked(ctx context.Context, id links.LinkID) error {
+	m.checked[id]++
+	return nil
+}
+
+func pendingLink(id string) links.Link {
+	return links.Link{LinkID: links.LinkID(id), ArchiveStatus: int(links.Pending), ArchiveJobID: "job-" + id, Archiver: ArchiverWayback}
+}
+
+func testPoller(store archiveStore, statusURL string) *ArchivePoller {
+	checkers := map[string]JobChecker{ArchiverWayback: WaybackArchiver{statusURL: statusURL}}
+	return &ArchivePoller{store: store, checkers: checkers, batchSize: 10}
+}
+
+func TestArchivePoller(t *testing.T) {
+	logger.Init()
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/json")
+		switch strings.TrimPrefix(req.URL.Path, "/status/") {
+		case "job-1":
+			res.Write([]byte(`{"status":"success", "timestamp":"20220102005040", "original_url":"https://example.com"}`))
+		case "job-2":
+			res.Write([]byte(`{"status":"pending"}`))
+		case "job-3":
+			res.Write([]byte(`{"status":"error", "exception":"blocked by robots.txt"}`))
+		default:
+			res.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer testServer.Close()
+
+	store := &memoryLinks{
+		links:   []links.Link{pendingLink("1"), pendingLink("2"), pendingLink("3"), pendingLink("4"), pendingLink("5")},
+		checked: map[links.LinkID]int{},
+	}
+	store.find("5").Archiver = "retired"
+
+	err := testPoller(store, testServer.URL+"/status/").Poll(context.Background())
+	assert.NoError(t, err)
+
+	success := store.find("1")
+	assert.Equal(t, int(links.Success), success.ArchiveStatus)
+	assert.Equal(t, "https://web.archive.org/web/20220102005040/https://example.com", success.ArchiveURL)
+
+	assert.Equal(t, int(links.Pending), store.find("2").ArchiveStatus)
+	assert.Equal(t, 1, store.checked["2"])
+
+	failed := store.find("3")
+	assert.Equal(t, int(links.Error), failed.ArchiveStatus)
+	assert.Contains(t, failed.ArchiveException, "blocked by robots.txt")
+
+	// A server error is retried on the next poll
+	assert.Equal(t, int(links.Pending), store.find("4").ArchiveStatus)
+	assert.Equal(t, 1, store.checked["4"])
+
+	// Nothing can check a job from an archiver we don't know
+	assert.Equal(t, int(links.Error), store.find("5").ArchiveStatus)
+}
+
+func TestArchivePollerRateLimited(t *testing.T) {
+	requests := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests++
+		res.Header().Set("Retry-After", "120")
+		res.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer testServer.Close()
+
+	store := &memoryLinks{
+		links:   []links.Link{pendingLink("1"), pendingLink("2")},
+		checked: map[links.LinkID]int{},
+	}
+	poller := testPoller(store, testServer.URL+"/status/")
+
+	err := poller.Poll(context.Background())
+	var rateLimit *RateLimitError
+	assert.ErrorAs(t, err, &rateLimit)
+	assert.Equal(t, 2*time.Minute, rateLimit.RetryAfter)
+	assert.Equal(t, 1, requests)
+
+	// Paused until the archive said to come back
+	assert.NoError(t, poller.Poll(context.Background()))
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, int(links.Pending), store.find("1").ArchiveStatus)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(t, time.Minute, parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
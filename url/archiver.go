@@ -0,0 +1,263 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	gurl "net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/thrgamon/nous/logger"
+	"github.com/thrgamon/nous/snapshots"
+)
+
+// The archivers a context can choose from
+const (
+	ArchiverWayback      = "wayback"
+	ArchiverArchiveToday = "archive.today"
+	ArchiverLocal        = "local"
+	ArchiverNone         = "none"
+)
+
+// Archivers lists the archiver names in the order they are offered.
+var Archivers = []string{ArchiverWayback, ArchiverArchiveToday, ArchiverLocal, ArchiverNone}
+
+var ErrUnknownArchiver = errors.New("unknown archiver")
+
+// Snapshot is the result of submitting a page to an archiver. Archivers that
+// work in the background return a JobID to check later instead of a URL.
+type Snapshot struct {
+	URL   string
+	JobID string
+}
+
+// Archiver keeps a copy of a page.
+type Archiver interface {
+	Archive(ctx context.Context, pageURL string) (Snapshot, error)
+}
+
+// JobChecker is implemented by archivers that finish their snapshots in the
+// background. An empty URL means the job is still running.
+type JobChecker interface {
+	CheckJob(ctx context.Context, jobID string) (archiveURL string, err error)
+}
+
+// NewArchiver returns the archiver with the given name. The none archiver
+// is nil, as there is nothing to do.
+func NewArchiver(name string) (Archiver, error) {
+	switch name {
+	case ArchiverWayback:
+		return NewWaybackArchiver(), nil
+	case ArchiverArchiveToday:
+		return NewArchiveTodayArchiver(), nil
+	case ArchiverLocal:
+		return NewLocalArchiver(), nil
+	case ArchiverNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownArchiver, name)
+}
+
+// WaybackArchiver submits pages to the Wayback Machine, which takes a while
+// to make the snapshot.
+type WaybackArchiver struct {
+	saveURL   string
+	statusURL string
+}
+
+func NewWaybackArchiver() WaybackArchiver {
+	return WaybackArchiver{saveURL: "https://web.archive.org/save", statusURL: archiveStatusURL}
+}
+
+func (a WaybackArchiver) Archive(ctx context.Context, pageURL string) (Snapshot, error) {
+	archiveResponse, err := SubmitToArchive(pageURL, a.saveURL)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{JobID: archiveResponse.JobID}, nil
+}
+
+func (a WaybackArchiver) CheckJob(ctx context.Context, jobID string) (string, error) {
+	return CheckArchiveJobStatus(jobID, a.statusURL+gurl.PathEscape(jobID))
+}
+
+// ArchiveTodayArchiver submits pages to archive.today, or any service that
+// answers a submission by redirecting to the snapshot.
+type ArchiveTodayArchiver struct {
+	submitURL string
+	fetcher   *Fetcher
+}
+
+func NewArchiveTodayArchiver() ArchiveTodayArchiver {
+	return ArchiveTodayArchiver{submitURL: "https://archive.ph/submit/", fetcher: defaultFetcher}
+}
+
+func (a ArchiveTodayArchiver) Archive(ctx context.Context, pageURL string) (Snapshot, error) {
+	data := gurl.Values{}
+	data.Set("url", pageURL)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.submitURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return Snapshot{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := a.fetcher.Do(req)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return Snapshot{}, &RateLimitError{RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now())}
+	}
+
+	// New snapshots come back as a refresh to the page, existing ones as a
+	// redirect
+	if snapshotURL := refreshURL(res.Header.Get("Refresh")); snapshotURL != "" {
+		return Snapshot{URL: snapshotURL}, nil
+	}
+	if location := res.Header.Get("Location"); location != "" && res.StatusCode >= 300 && res.StatusCode < 400 {
+		snapshotURL, err := res.Request.URL.Parse(location)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		return Snapshot{URL: snapshotURL.String()}, nil
+	}
+
+	return Snapshot{}, errors.New("There was a problem with the request. Status code: " + res.Status)
+}
+
+// refreshURL reads the URL out of a Refresh header like "0;url=https://...".
+func refreshURL(header string) string {
+	_, after, found := strings.Cut(strings.ToLower(header), "url=")
+	if !found {
+		return ""
+	}
+	// Keep the original case of the URL
+	return strings.TrimSpace(header[len(header)-len(after):])
+}
+
+const (
+	maxSnapshotAssets    = 50
+	maxSnapshotPageSize  = 5 << 20
+	maxSnapshotAssetSize = 5 << 20
+)
+
+// snapshotStore is the part of SnapshotRepo the local archiver needs.
+type snapshotStore interface {
+	Save(ctx context.Context, url string, rewrite func(snapshots.SnapshotID) string, assets []snapshots.Asset) (snapshots.SnapshotID, error)
+}
+
+// LocalArchiver keeps snapshots in our own database, so pages never leave
+// our infrastructure. Scripts and embedded frames are dropped; images and
+// stylesheets are saved with the page.
+type LocalArchiver struct {
+	store   snapshotStore
+	fetcher *Fetcher
+}
+
+func NewLocalArchiver() LocalArchiver {
+	return LocalArchiver{store: snapshots.NewSnapshotRepo(), fetcher: defaultFetcher}
+}
+
+func (a LocalArchiver) Archive(ctx context.Context, pageURL string) (Snapshot, error) {
+	body, _, err := a.fetch(ctx, pageURL, maxSnapshotPageSize, HTMLTypes...)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(body)))
+	if err != nil {
+		return Snapshot{}, err
+	}
+	base, _ := gurl.Parse(pageURL)
+
+	doc.Find("script, noscript, iframe, frame, object, embed, base, meta[http-equiv]").Remove()
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		var handlers []string
+		for _, attr := range s.Nodes[0].Attr {
+			if strings.HasPrefix(strings.ToLower(attr.Key), "on") {
+				handlers = append(handlers, attr.Key)
+			}
+		}
+		for _, handler := range handlers {
+			s.RemoveAttr(handler)
+		}
+	})
+	doc.Find("img[srcset], source[srcset]").RemoveAttr("srcset")
+
+	type reference struct {
+		selection *goquery.Selection
+		attr      string
+	}
+	var refs []reference
+	var assets []snapshots.Asset
+
+	doc.Find(`img[src], link[rel="stylesheet"][href]`).Each(func(_ int, s *goquery.Selection) {
+		attr := "src"
+		if goquery.NodeName(s) == "link" {
+			attr = "href"
+		}
+		if len(assets) >= maxSnapshotAssets {
+			s.RemoveAttr(attr)
+			return
+		}
+
+		value, _ := s.Attr(attr)
+		assetURL, err := base.Parse(value)
+		if err != nil || (assetURL.Scheme != "http" && assetURL.Scheme != "https") {
+			s.RemoveAttr(attr)
+			return
+		}
+
+		assetBody, contentType, err := a.fetch(ctx, assetURL.String(), maxSnapshotAssetSize)
+		if err != nil || !allowedAsset(contentType) {
+			if err != nil {
+				logger.Logger.Println(err.Error())
+			}
+			s.RemoveAttr(attr)
+			return
+		}
+
+		refs = append(refs, reference{selection: s, attr: attr})
+		assets = append(assets, snapshots.Asset{URL: assetURL.String(), ContentType: contentType, Body: assetBody})
+	})
+
+	id, err := a.store.Save(ctx, pageURL, func(id snapshots.SnapshotID) string {
+		for i, ref := range refs {
+			ref.selection.SetAttr(ref.attr, snapshots.AssetPath(id, i))
+		}
+		html, err := doc.Html()
+		if err != nil {
+			logger.Logger.Println(err.Error())
+		}
+		return html
+	}, assets)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{URL: snapshots.Path(id)}, nil
+}
+
+// allowedAsset keeps anything that could run in our origin out of the
+// snapshot assets.
+func allowedAsset(contentType string) bool {
+	return contentType == "text/css" || (strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml")
+}
+
+// fetch GETs url, giving up on bodies larger than limit.
+func (a LocalArchiver) fetch(ctx context.Context, url string, limit int, accept ...string) ([]byte, string, error) {
+	res, err := a.fetcher.Get(ctx, url, accept...)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(res.Body) > limit {
+		return nil, "", fmt.Errorf("%w: %s is over %d bytes", ErrTooLarge, url, limit)
+	}
+	return res.Body, res.ContentType, nil
+}
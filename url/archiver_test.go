@@ -0,0 +1,117 @@
+package url
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thrgamon/nous/logger"
+	"github.com/thrgamon/nous/snapshots"
+)
+
+type memorySnapshots struct {
+	url    string
+	html   string
+	assets []snapshots.Asset
+}
+
+func (m *memorySnapshots) Save(ctx context.Context, url string, rewrite func(snapshots.SnapshotID) string, assets []snapshots.Asset) (snapshots.SnapshotID, error) {
+	m.url = url
+	m.html = rewrite("7")
+	m.assets = assets
+	return "7", nil
+}
+
+func TestLocalArchiver(t *testing.T) {
+	logger.Init()
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/page":
+			res.Header().Set("Content-Type", "text/html")
+			res.Write([]byte(`<html><head>
+<link rel="stylesheet" href="/style.css">
+<script src="/tracker.js"></script>
+</head><body onload="track()">
+<img src="/cat.png"><img src="/missing.png"><img src="/page">
+<iframe src="https://example.com"></iframe>
+</body></html>`))
+		case "/style.css":
+			res.Header().Set("Content-Type", "text/css; charset=utf-8")
+			res.Write([]byte("body { color: red }"))
+		case "/cat.png":
+			res.Header().Set("Content-Type", "image/png")
+			res.Write([]byte("not really a png"))
+		default:
+			res.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	store := &memorySnapshots{}
+	archiver := LocalArchiver{store: store, fetcher: testFetcher()}
+
+	snapshot, err := archiver.Archive(context.Background(), testServer.URL+"/page")
+	assert.NoError(t, err)
+	assert.Equal(t, Snapshot{URL: "/snapshots/7"}, snapshot)
+
+	assert.Len(t, store.assets, 2)
+	assert.Equal(t, "text/css", store.assets[0].ContentType)
+	assert.Equal(t, testServer.URL+"/cat.png", store.assets[1].URL)
+
+	assert.Contains(t, store.html, `href="/snapshots/7/assets/0"`)
+	assert.Contains(t, store.html, `src="/snapshots/7/assets/1"`)
+	assert.NotContains(t, store.html, "script")
+	assert.NotContains(t, store.html, "iframe")
+	assert.NotContains(t, store.html, "onload")
+	// Assets that couldn't be saved, or aren't images, aren't loaded from
+	// the original site either
+	assert.NotContains(t, store.html, testServer.URL)
+}
+
+func TestArchiveTodayArchiver(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.FormValue("url") {
+		case "https://example.com/new":
+			res.Header().Set("Refresh", "0;url=https://archive.ph/AbCdE")
+			res.WriteHeader(http.StatusOK)
+		case "https://example.com/old":
+			res.Header().Set("Location", "/XyZ")
+			res.WriteHeader(http.StatusFound)
+		default:
+			res.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer testServer.Close()
+
+	archiver := ArchiveTodayArchiver{submitURL: testServer.URL + "/submit/", fetcher: testFetcher()}
+
+	snapshot, err := archiver.Archive(context.Background(), "https://example.com/new")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://archive.ph/AbCdE", snapshot.URL)
+
+	snapshot, err = archiver.Archive(context.Background(), "https://example.com/old")
+	assert.NoError(t, err)
+	assert.Equal(t, testServer.URL+"/XyZ", snapshot.URL)
+
+	_, err = archiver.Archive(context.Background(), "https://example.com/busy")
+	var rateLimit *RateLimitError
+	assert.ErrorAs(t, err, &rateLimit)
+}
+
+func TestNewArchiver(t *testing.T) {
+	for _, name := range Archivers {
+		_, err := NewArchiver(name)
+		assert.NoError(t, err)
+	}
+
+	archiver, err := NewArchiver(ArchiverNone)
+	assert.NoError(t, err)
+	assert.Nil(t, archiver)
+
+	_, err = NewArchiver("dropbox")
+	assert.ErrorIs(t, err, ErrUnknownArchiver)
+}
@@ -0,0 +1,66 @@
+package url
+
+import (
+	gurl "net/url"
+	"sort"
+	"strings"
+)
+
+// trackingParams are query parameters that only record where a link was
+// shared from, so two links differing only by them are the same page.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"igshid":  true,
+	"ref_src": true,
+	"si":      true,
+}
+
+// Canonicalise reduces a URL to a form where trivially different links to
+// the same page compare equal. The scheme is always https, the host is lower
+// cased without www or a default port, tracking parameters and fragments are
+// dropped, the remaining query is sorted and a trailing slash is removed.
+// It is only meant for comparing links, the original should still be kept.
+func Canonicalise(raw string) (string, error) {
+	u, err := gurl.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	u.Host = host
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(strings.ToLower(key), "utm_") || trackingParams[strings.ToLower(key)] {
+			query.Del(key)
+		}
+	}
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	// Encode sorts by key
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
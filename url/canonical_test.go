@@ -0,0 +1,31 @@
+package url
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalise(t *testing.T) {
+	cases := map[string]string{
+		"https://example.com/post":                               "https://example.com/post",
+		"http://example.com/post/":                               "https://example.com/post",
+		"HTTPS://WWW.Example.com:443/post#comments":              "https://example.com/post",
+		"https://example.com/post?utm_source=x&utm_medium=email": "https://example.com/post",
+		"https://example.com/search?q=go&fbclid=abc&a=1":         "https://example.com/search?a=1&q=go",
+		"https://example.com:8080/":                              "https://example.com:8080",
+		"https://example.com":                                    "https://example.com",
+	}
+
+	for raw, want := range cases {
+		got, err := Canonicalise(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, want, got, raw)
+	}
+}
+
+func TestCanonicaliseMatchesVariants(t *testing.T) {
+	a, _ := Canonicalise("http://www.example.com/a/?utm_campaign=launch")
+	b, _ := Canonicalise("https://example.com/a")
+	assert.Equal(t, a, b)
+}
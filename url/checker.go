@@ -0,0 +1,248 @@
+package url
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	gurl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thrgamon/nous/links"
+	"github.com/thrgamon/nous/logger"
+)
+
+const (
+	// How often to look for links due a check
+	linkCheckInterval = time.Hour
+	// How long a check holds before the link is checked again
+	linkRecheckAfter = 30 * 24 * time.Hour
+	linkCheckBatch   = 100
+	linkCheckWorkers = 8
+	// Requests made to one host at a time, and the gap left after each
+	linkCheckPerHost = 1
+	linkCheckDelay   = 10 * time.Second
+	// Failed checks in a row before a link counts as dead
+	linkDeadAfter = 2
+	// How long a link no note mentions is kept, in case it comes back
+	linkOrphanedFor = 7 * 24 * time.Hour
+	maxRedirects    = 10
+)
+
+// checkStore is the part of LinkRepo the checker needs.
+type checkStore interface {
+	GetDueChecks(ctx context.Context, before time.Time, limit int) ([]links.Link, error)
+	RecordCheck(ctx context.Context, id links.LinkID, check links.Check, deadAfter int) error
+	DeleteOrphans(ctx context.Context, before time.Time) (int, error)
+}
+
+// LinkChecker requests saved links every so often to find the ones that
+// have rotted. It keeps to a few requests per host at a time, with a pause
+// between them. Links no note has mentioned for a while are deleted rather
+// than checked.
+type LinkChecker struct {
+	store        checkStore
+	fetcher      *Fetcher
+	interval     time.Duration
+	recheckAfter time.Duration
+	delay        time.Duration
+	batchSize    int
+	workers      int
+	perHost      int
+	deadAfter    int
+	orphanedFor  time.Duration
+}
+
+func NewLinkChecker() *LinkChecker {
+	return &LinkChecker{
+		store:        links.NewLinkRepo(),
+		fetcher:      defaultFetcher,
+		interval:     linkCheckInterval,
+		recheckAfter: linkRecheckAfter,
+		delay:        linkCheckDelay,
+		batchSize:    linkCheckBatch,
+		workers:      linkCheckWorkers,
+		perHost:      linkCheckPerHost,
+		deadAfter:    linkDeadAfter,
+		orphanedFor:  linkOrphanedFor,
+	}
+}
+
+// Run checks links until ctx is cancelled.
+func (c *LinkChecker) Run(ctx context.Context) {
+	for {
+		if err := c.DeleteOrphans(ctx); err != nil && ctx.Err() == nil {
+			logger.Logger.Println(err.Error())
+		}
+		if err := c.CheckDue(ctx); err != nil && ctx.Err() == nil {
+			logger.Logger.Println(err.Error())
+		}
+
+		if !sleep(ctx, c.interval) {
+			return
+		}
+	}
+}
+
+// DeleteOrphans deletes the links no note has mentioned for orphanedFor.
+func (c *LinkChecker) DeleteOrphans(ctx context.Context) error {
+	deleted, err := c.store.DeleteOrphans(ctx, time.Now().Add(-c.orphanedFor))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		logger.Logger.Printf("Deleted %d links no note mentions\n", deleted)
+	}
+	return nil
+}
+
+// CheckDue checks a batch of the links that haven't been checked recently.
+func (c *LinkChecker) CheckDue(ctx context.Context) error {
+	due, err := c.store.GetDueChecks(ctx, time.Now().Add(-c.recheckAfter), c.batchSize)
+	if err != nil {
+		return err
+	}
+
+	workers := make(chan struct{}, c.workers)
+	hosts := make(map[string]chan struct{})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	for _, link := range due {
+		host := hostOf(link.Url)
+		hostSlots, ok := hosts[host]
+		if !ok {
+			hostSlots = make(chan struct{}, c.perHost)
+			hosts[host] = hostSlots
+		}
+
+		wg.Add(1)
+		go func(link links.Link, hostSlots chan struct{}) {
+			defer wg.Done()
+
+			// Wait for the host before taking a worker, so links queued
+			// behind a slow host don't hold up the others
+			if !acquire(ctx, hostSlots) {
+				return
+			}
+			defer func() { <-hostSlots }()
+			if !acquire(ctx, workers) {
+				return
+			}
+			check := c.Check(ctx, link.Url)
+			<-workers
+
+			if ctx.Err() != nil {
+				return
+			}
+			if err := c.store.RecordCheck(ctx, link.LinkID, check, c.deadAfter); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+
+			// Give the host a rest before its next request
+			sleep(ctx, c.delay)
+		}(link, hostSlots)
+	}
+
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+// Check requests a link, following its redirects. HEAD is tried first, and
+// GET if the server won't answer HEAD properly.
+func (c *LinkChecker) Check(ctx context.Context, url string) links.Check {
+	check := links.Check{RedirectChain: []string{}}
+	method := http.MethodHead
+	current := url
+
+	for {
+		res, err := c.request(ctx, method, current)
+		if err != nil {
+			check.Error = err.Error()
+			check.Outcome = links.Failed
+			if ctx.Err() != nil {
+				check.Outcome = links.Inconclusive
+			}
+			return check
+		}
+		res.Body.Close()
+
+		// Plenty of servers get HEAD wrong, so give them a second chance
+		if method == http.MethodHead && res.StatusCode >= 400 {
+			method = http.MethodGet
+			continue
+		}
+
+		location := res.Header.Get("Location")
+		if res.StatusCode >= 300 && res.StatusCode < 400 && location != "" {
+			next, err := res.Request.URL.Parse(location)
+			if err != nil {
+				check.Status = res.StatusCode
+				check.Error = err.Error()
+				check.Outcome = links.Failed
+				return check
+			}
+
+			check.RedirectChain = append(check.RedirectChain, next.String())
+			if len(check.RedirectChain) > maxRedirects {
+				check.Status = res.StatusCode
+				check.Error = fmt.Sprintf("more than %d redirects", maxRedirects)
+				check.Outcome = links.Failed
+				return check
+			}
+			current = next.String()
+			continue
+		}
+
+		check.Status = res.StatusCode
+		check.Outcome = checkOutcome(res.StatusCode)
+		return check
+	}
+}
+
+func (c *LinkChecker) request(ctx context.Context, method string, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.fetcher.Do(req)
+}
+
+// checkOutcome decides what a final status code says about a link. Being
+// refused as a bot or rate limited says nothing either way.
+func checkOutcome(status int) links.CheckOutcome {
+	switch {
+	case status < 400:
+		return links.Alive
+	case status == http.StatusUnauthorized, status == http.StatusForbidden, status == http.StatusProxyAuthRequired, status == http.StatusTooManyRequests:
+		return links.Inconclusive
+	}
+	return links.Failed
+}
+
+func acquire(ctx context.Context, slots chan struct{}) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func hostOf(url string) string {
+	parsed, err := gurl.Parse(url)
+	if err != nil {
+		return url
+	}
+	return strings.ToLower(parsed.Hostname())
+}
@@ -0,0 +1,133 @@
+package url
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thrgamon/nous/links"
+)
+
+type memoryChecks struct {
+	mu             sync.Mutex
+	due            []links.Link
+	checks         map[links.LinkID]links.Check
+	orphanedBefore time.Time
+}
+
+func (m *memoryChecks) GetDueChecks(ctx context.Context, before time.Time, limit int) ([]links.Link, error) {
+	return m.due, nil
+}
+
+func (m *memoryChecks) RecordCheck(ctx context.Context, id links.LinkID, check links.Check, deadAfter int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checks[id] = check
+	return nil
+}
+
+func (m *memoryChecks) DeleteOrphans(ctx context.Context, before time.Time) (int, error) {
+	m.orphanedBefore = before
+	return 1, nil
+}
+
+func testChecker(store checkStore) *LinkChecker {
+	return &LinkChecker{store: store, fetcher: testFetcher(), batchSize: 10, workers: 4, perHost: 1, orphanedFor: time.Hour}
+}
+
+func TestLinkCheckerCheck(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/moved":
+			http.Redirect(res, req, "/moved-again", http.StatusMovedPermanently)
+		case "/moved-again":
+			http.Redirect(res, req, "/ok", http.StatusFound)
+		case "/ok":
+			res.WriteHeader(http.StatusOK)
+		case "/no-head":
+			if req.Method == http.MethodHead {
+				res.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			res.WriteHeader(http.StatusOK)
+		case "/forbidden":
+			res.WriteHeader(http.StatusForbidden)
+		case "/loop":
+			http.Redirect(res, req, "/loop", http.StatusFound)
+		default:
+			res.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	checker := testChecker(nil)
+	ctx := context.Background()
+
+	check := checker.Check(ctx, testServer.URL+"/moved")
+	assert.Equal(t, links.Alive, check.Outcome)
+	assert.Equal(t, http.StatusOK, check.Status)
+	assert.Equal(t, []string{testServer.URL + "/moved-again", testServer.URL + "/ok"}, check.RedirectChain)
+
+	check = checker.Check(ctx, testServer.URL+"/no-head")
+	assert.Equal(t, links.Alive, check.Outcome)
+
+	check = checker.Check(ctx, testServer.URL+"/gone")
+	assert.Equal(t, links.Failed, check.Outcome)
+	assert.Equal(t, http.StatusNotFound, check.Status)
+
+	check = checker.Check(ctx, testServer.URL+"/forbidden")
+	assert.Equal(t, links.Inconclusive, check.Outcome)
+
+	check = checker.Check(ctx, testServer.URL+"/loop")
+	assert.Equal(t, links.Failed, check.Outcome)
+	assert.Contains(t, check.Error, "redirects")
+
+	check = checker.Check(ctx, "http://127.0.0.1:1/unreachable")
+	assert.Equal(t, links.Failed, check.Outcome)
+	assert.NotEmpty(t, check.Error)
+}
+
+func TestLinkCheckerCheckDue(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, mostInFlight := 0, 0
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > mostInFlight {
+			mostInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	store := &memoryChecks{checks: map[links.LinkID]links.Check{}}
+	for _, id := range []string{"1", "2", "3", "4"} {
+		store.due = append(store.due, links.Link{LinkID: links.LinkID(id), Url: testServer.URL + "/" + id})
+	}
+
+	err := testChecker(store).CheckDue(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, store.checks, 4)
+	assert.Equal(t, links.Alive, store.checks["3"].Outcome)
+	// Every link is on the same host, so they were requested one at a time
+	assert.Equal(t, 1, mostInFlight)
+}
+
+func TestLinkCheckerDeleteOrphans(t *testing.T) {
+	store := &memoryChecks{}
+	err := testChecker(store).DeleteOrphans(context.Background())
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), store.orphanedBefore, time.Minute)
+}
@@ -0,0 +1,313 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	gurl "net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/thrgamon/go-utils/env"
+)
+
+var (
+	// ErrBlockedAddress is returned for URLs that point inside our network.
+	// Note bodies are user input, so links to them mustn't be fetched.
+	ErrBlockedAddress = errors.New("address not allowed")
+	ErrTooLarge       = errors.New("response too large")
+	ErrContentType    = errors.New("unexpected content type")
+	ErrRobots         = errors.New("disallowed by robots.txt")
+	ErrScheme         = errors.New("only http and https URLs can be fetched")
+)
+
+const (
+	defaultUserAgent    = "Nous/1.1"
+	defaultFetchTimeout = 30 * time.Second
+	defaultMaxBody      = 5 << 20
+)
+
+// Content types accepted by Get
+var (
+	HTMLTypes  = []string{"text/html", "application/xhtml+xml"}
+	JSONTypes  = []string{"application/json", "text/json", "text/javascript"}
+	ImageTypes = []string{"image/"}
+)
+
+// Fetcher makes every request for a URL found in a note. It refuses
+// private, loopback and link-local addresses, checked after DNS resolution
+// so a hostname can't be pointed at them, and it bounds how long a request
+// takes and how much of a response is read.
+type Fetcher struct {
+	client       *http.Client
+	userAgent    string
+	maxBody      int64
+	obeyRobots   bool
+	allowPrivate bool
+	robots       *robotsCache
+}
+
+// NewFetcher configures a fetcher from the environment:
+// FETCH_USER_AGENT, FETCH_TIMEOUT (seconds), FETCH_MAX_BYTES and
+// FETCH_OBEY_ROBOTS.
+func NewFetcher() *Fetcher {
+	timeout := defaultFetchTimeout
+	if seconds, err := strconv.Atoi(env.GetEnvWithFallback("FETCH_TIMEOUT", "")); err == nil && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	maxBody := int64(defaultMaxBody)
+	if bytes, err := strconv.ParseInt(env.GetEnvWithFallback("FETCH_MAX_BYTES", ""), 10, 64); err == nil && bytes > 0 {
+		maxBody = bytes
+	}
+
+	obeyRobots, _ := strconv.ParseBool(env.GetEnvWithFallback("FETCH_OBEY_ROBOTS", "false"))
+
+	return newFetcher(env.GetEnvWithFallback("FETCH_USER_AGENT", defaultUserAgent), timeout, maxBody, obeyRobots, false)
+}
+
+func newFetcher(userAgent string, timeout time.Duration, maxBody int64, obeyRobots bool, allowPrivate bool) *Fetcher {
+	f := &Fetcher{
+		userAgent:    userAgent,
+		maxBody:      maxBody,
+		obeyRobots:   obeyRobots,
+		allowPrivate: allowPrivate,
+		robots:       newRobotsCache(),
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: f.checkAddress}
+	transport := &http.Transport{
+		// A proxy would be dialled instead of the host, skipping the
+		// address check
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          20,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	f.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// Callers follow redirects themselves when they want to
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return f
+}
+
+// defaultFetcher is shared by everything in the package that fetches a URL.
+var defaultFetcher = NewFetcher()
+
+// checkAddress runs as each connection is made, once the address is
+// resolved.
+func (f *Fetcher) checkAddress(network string, address string, _ syscall.RawConn) error {
+	if f.allowPrivate {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// Ranges that aren't private by net.IP's reckoning but aren't the public
+// internet either
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+)
+
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// Do sends a request without following redirects, after checking the URL
+// may be fetched. The caller must close the response body.
+func (f *Fetcher) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %s", ErrScheme, req.URL)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+
+	if f.obeyRobots && !strings.HasSuffix(req.URL.Path, "/robots.txt") {
+		allowed, err := f.robots.allowed(req.Context(), f, req.URL)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, fmt.Errorf("%w: %s", ErrRobots, req.URL)
+		}
+	}
+
+	return f.client.Do(req)
+}
+
+// Response is a fetched page with its body read.
+type Response struct {
+	URL         *gurl.URL
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// Get fetches url, following up to maxRedirects redirects, and reads the
+// body if the status is 200. Only the content types listed are accepted,
+// matched on their prefix; none means anything goes. Bodies bigger than the
+// fetcher's limit are an error.
+func (f *Fetcher) Get(ctx context.Context, url string, accept ...string) (Response, error) {
+	res, err := f.follow(ctx, http.MethodGet, url)
+	if err != nil {
+		return Response{}, err
+	}
+	defer res.Body.Close()
+
+	response := Response{URL: res.Request.URL, StatusCode: res.StatusCode}
+	if res.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("fetching %s: %s", url, res.Status)
+	}
+
+	if res.ContentLength > f.maxBody {
+		return response, fmt.Errorf("%w: %s is %d bytes", ErrTooLarge, url, res.ContentLength)
+	}
+	response.Body, err = io.ReadAll(io.LimitReader(res.Body, f.maxBody+1))
+	if err != nil {
+		return response, err
+	}
+	if int64(len(response.Body)) > f.maxBody {
+		return response, fmt.Errorf("%w: %s is over %d bytes", ErrTooLarge, url, f.maxBody)
+	}
+
+	response.ContentType = mediaType(res.Header.Get("Content-Type"), response.Body)
+	if len(accept) > 0 && !acceptable(response.ContentType, accept) {
+		return response, fmt.Errorf("%w: %s is %s", ErrContentType, url, response.ContentType)
+	}
+
+	return response, nil
+}
+
+// Resolve follows a URL's redirects with HEAD requests and returns where
+// they end up. If a later hop can't be reached, the last address found is
+// still the best answer, so it is returned without an error.
+func (f *Fetcher) Resolve(ctx context.Context, url string) (string, error) {
+	current := url
+	for i := 0; i <= maxRedirects; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, current, nil)
+		if err != nil {
+			return current, err
+		}
+
+		res, err := f.Do(req)
+		if err != nil {
+			if i == 0 || errors.Is(err, ErrBlockedAddress) || errors.Is(err, ErrScheme) {
+				return current, err
+			}
+			return current, nil
+		}
+		res.Body.Close()
+
+		location := res.Header.Get("Location")
+		if res.StatusCode < 300 || res.StatusCode >= 400 || location == "" {
+			return current, nil
+		}
+
+		next, err := res.Request.URL.Parse(location)
+		if err != nil {
+			return current, nil
+		}
+		current = next.String()
+	}
+
+	return current, fmt.Errorf("more than %d redirects from %s", maxRedirects, url)
+}
+
+// follow makes a request, following redirects itself so every hop is
+// checked like the first.
+func (f *Fetcher) follow(ctx context.Context, method string, url string) (*http.Response, error) {
+	current := url
+	for i := 0; i <= maxRedirects; i++ {
+		req, err := http.NewRequestWithContext(ctx, method, current, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := f.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		location := res.Header.Get("Location")
+		if res.StatusCode < 300 || res.StatusCode >= 400 || location == "" {
+			return res, nil
+		}
+		res.Body.Close()
+
+		next, err := res.Request.URL.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		current = next.String()
+	}
+
+	return nil, fmt.Errorf("more than %d redirects from %s", maxRedirects, url)
+}
+
+// mediaType is the type a server says it sent, or failing that, what the
+// body looks like.
+func mediaType(header string, body []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(header); err == nil {
+		return strings.ToLower(mediaType)
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(body))
+	return mediaType
+}
+
+func acceptable(contentType string, accept []string) bool {
+	for _, prefix := range accept {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
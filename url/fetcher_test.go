@@ -0,0 +1,163 @@
+package url
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thrgamon/nous/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init()
+	// Test servers listen on loopback, which the real fetcher refuses
+	defaultFetcher = testFetcher()
+	os.Exit(m.Run())
+}
+
+func testFetcher() *Fetcher {
+	return newFetcher(defaultUserAgent, 5*time.Second, defaultMaxBody, false, true)
+}
+
+func TestFetcherBlocksPrivateAddresses(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("secret"))
+	}))
+	defer testServer.Close()
+
+	fetcher := newFetcher(defaultUserAgent, time.Second, defaultMaxBody, false, false)
+	ctx := context.Background()
+
+	_, err := fetcher.Get(ctx, testServer.URL)
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+
+	_, err = fetcher.Resolve(ctx, testServer.URL)
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+
+	_, err = fetcher.Get(ctx, "file:///etc/passwd")
+	assert.ErrorIs(t, err, ErrScheme)
+
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "169.254.169.254", "100.64.0.1", "::1", "fd00::1"} {
+		assert.Error(t, fetcher.checkAddress("tcp", ip+":80", nil), ip)
+	}
+	assert.NoError(t, fetcher.checkAddress("tcp", "93.184.216.34:443", nil))
+}
+
+func TestFetcherGet(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/moved":
+			http.Redirect(res, req, "/page", http.StatusMovedPermanently)
+		case "/page":
+			res.Header().Set("Content-Type", "text/html; charset=utf-8")
+			res.Write([]byte("<html>" + req.UserAgent() + "</html>"))
+		case "/big":
+			res.Header().Set("Content-Type", "text/html")
+			res.Write([]byte(strings.Repeat("a", 2048)))
+		case "/data.json":
+			res.Header().Set("Content-Type", "application/json")
+			res.Write([]byte("{}"))
+		default:
+			res.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	fetcher := newFetcher("TestAgent/1.0", time.Second, 1024, false, true)
+	ctx := context.Background()
+
+	res, err := fetcher.Get(ctx, testServer.URL+"/moved", HTMLTypes...)
+	assert.NoError(t, err)
+	assert.Equal(t, testServer.URL+"/page", res.URL.String())
+	assert.Equal(t, "text/html", res.ContentType)
+	assert.Equal(t, "<html>TestAgent/1.0</html>", string(res.Body))
+
+	_, err = fetcher.Get(ctx, testServer.URL+"/big", HTMLTypes...)
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, err = fetcher.Get(ctx, testServer.URL+"/data.json", HTMLTypes...)
+	assert.ErrorIs(t, err, ErrContentType)
+
+	res, err = fetcher.Get(ctx, testServer.URL+"/missing")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestFetcherResolve(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/short":
+			http.Redirect(res, req, "/long", http.StatusFound)
+		case "/long":
+			http.Redirect(res, req, "http://127.0.0.1:1/gone", http.StatusFound)
+		case "/loop":
+			http.Redirect(res, req, "/loop", http.StatusFound)
+		}
+	}))
+	defer testServer.Close()
+
+	fetcher := testFetcher()
+	ctx := context.Background()
+
+	// The last hop can't be reached, but it's still where the link goes
+	resolved, err := fetcher.Resolve(ctx, testServer.URL+"/short")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:1/gone", resolved)
+
+	_, err = fetcher.Resolve(ctx, "http://127.0.0.1:1/gone")
+	assert.Error(t, err)
+
+	_, err = fetcher.Resolve(ctx, testServer.URL+"/loop")
+	assert.Error(t, err)
+}
+
+func TestFetcherObeysRobots(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/robots.txt" {
+			res.Header().Set("Content-Type", "text/plain")
+			res.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			return
+		}
+		res.Write([]byte("<html></html>"))
+	}))
+	defer testServer.Close()
+
+	fetcher := newFetcher(defaultUserAgent, time.Second, defaultMaxBody, true, true)
+	ctx := context.Background()
+
+	_, err := fetcher.Get(ctx, testServer.URL+"/public")
+	assert.NoError(t, err)
+
+	_, err = fetcher.Get(ctx, testServer.URL+"/private/page")
+	assert.ErrorIs(t, err, ErrRobots)
+}
+
+func TestParseRobots(t *testing.T) {
+	robots := []byte(`# Example
+User-agent: *
+Disallow: /
+
+User-agent: Googlebot
+User-agent: Nous
+Disallow: /search
+Disallow: /*.pdf$
+Allow: /search/about
+Disallow:
+`)
+
+	rules := parseRobots(robots, "Nous/1.1")
+	assert.True(t, rules.allows("/"))
+	assert.True(t, rules.allows("/page"))
+	assert.False(t, rules.allows("/search?q=1"))
+	assert.True(t, rules.allows("/search/about"))
+	assert.False(t, rules.allows("/files/paper.pdf"))
+	assert.True(t, rules.allows("/files/paper.pdf.html"))
+
+	rules = parseRobots(robots, "OtherBot/2.0")
+	assert.False(t, rules.allows("/page"))
+}
@@ -0,0 +1,169 @@
+package url
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	gurl "net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/thrgamon/nous/links"
+)
+
+const (
+	maxOEmbedSize       = 1 << 20
+	maxPreviewImageSize = 2 << 20
+	maxDescription      = 300
+)
+
+// ExtractPreview reads the OpenGraph and Twitter card metadata of a page. It
+// also returns the page's oEmbed endpoint, if it advertises one, for filling
+// in anything the metadata left out.
+func ExtractPreview(r io.Reader, pageURL *gurl.URL) (preview links.Preview, oEmbedURL string, err error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return preview, "", err
+	}
+
+	meta := func(names ...string) string {
+		for _, name := range names {
+			selector := fmt.Sprintf(`meta[property=%q], meta[name=%q]`, name, name)
+			if content, ok := doc.Find(selector).First().Attr("content"); ok && strings.TrimSpace(content) != "" {
+				return strings.TrimSpace(spaces.ReplaceAllString(content, " "))
+			}
+		}
+		return ""
+	}
+
+	preview.Title = meta("og:title", "twitter:title")
+	preview.Description = truncate(meta("og:description", "twitter:description", "description"), maxDescription)
+	preview.SiteName = meta("og:site_name", "application-name")
+	preview.Image = resolveHTTP(pageURL, meta("og:image:secure_url", "og:image", "twitter:image", "twitter:image:src"))
+
+	if href, ok := doc.Find(`link[type="application/json+oembed"]`).First().Attr("href"); ok {
+		oEmbedURL = resolveHTTP(pageURL, href)
+	}
+
+	return preview, oEmbedURL, nil
+}
+
+// oEmbed is the part of an oEmbed response used for previews. Embedded
+// players are left out, as they would load from the provider.
+type oEmbed struct {
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// fillFromOEmbed fetches a page's oEmbed and uses it for anything missing
+// from its preview.
+func fillFromOEmbed(ctx context.Context, fetcher *Fetcher, preview links.Preview, endpoint string) (links.Preview, error) {
+	res, err := fetcher.Get(ctx, endpoint, JSONTypes...)
+	if err != nil {
+		return preview, err
+	}
+	if len(res.Body) > maxOEmbedSize {
+		return preview, fmt.Errorf("%w: oEmbed %s is over %d bytes", ErrTooLarge, endpoint, maxOEmbedSize)
+	}
+
+	var embed oEmbed
+	if err := json.Unmarshal(res.Body, &embed); err != nil {
+		return preview, err
+	}
+
+	if preview.Title == "" {
+		preview.Title = embed.Title
+	}
+	if preview.SiteName == "" {
+		preview.SiteName = embed.ProviderName
+	}
+	if preview.Description == "" && embed.AuthorName != "" {
+		preview.Description = "By " + embed.AuthorName
+	}
+	if preview.Image == "" {
+		preview.Image = resolveHTTP(res.URL, embed.ThumbnailURL)
+	}
+
+	return preview, nil
+}
+
+// fetchPreviewImage downloads a preview image to keep with the link.
+func fetchPreviewImage(ctx context.Context, fetcher *Fetcher, imageURL string) (links.Image, error) {
+	image := links.Image{SourceURL: imageURL}
+
+	res, err := fetcher.Get(ctx, imageURL)
+	if err != nil {
+		return image, err
+	}
+	if len(res.Body) > maxPreviewImageSize {
+		return image, fmt.Errorf("%w: image %s is over %d bytes", ErrTooLarge, imageURL, maxPreviewImageSize)
+	}
+	image.Body = res.Body
+
+	// Trust the bytes rather than the server, so only images are kept
+	image.ContentType = http.DetectContentType(image.Body)
+	if !strings.HasPrefix(image.ContentType, "image/") {
+		return image, errors.New("not an image: " + imageURL)
+	}
+
+	return image, nil
+}
+
+// savePreview gathers a link's preview from its page and keeps its image.
+// Problems with oEmbed or the image only cost the preview those parts.
+func savePreview(ctx context.Context, linkRepo *links.LinkRepo, id links.LinkID, page []byte, pageURL *gurl.URL) error {
+	preview, oEmbedURL, err := ExtractPreview(bytes.NewReader(page), pageURL)
+	if err != nil {
+		return err
+	}
+
+	if oEmbedURL != "" {
+		if filled, err := fillFromOEmbed(ctx, defaultFetcher, preview, oEmbedURL); err == nil {
+			preview = filled
+		}
+	}
+
+	if err := linkRepo.EditPreview(ctx, id, preview); err != nil {
+		return err
+	}
+
+	if preview.Image == "" {
+		return nil
+	}
+	image, err := fetchPreviewImage(ctx, defaultFetcher, preview.Image)
+	if err != nil {
+		return err
+	}
+	return linkRepo.SaveImage(ctx, id, image)
+}
+
+// resolveHTTP resolves ref against base, keeping only http and https URLs.
+func resolveHTTP(base *gurl.URL, ref string) string {
+	if base == nil || strings.TrimSpace(ref) == "" {
+		return ""
+	}
+	resolved, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil || (resolved.Scheme != "http" && resolved.Scheme != "https") {
+		return ""
+	}
+	return resolved.String()
+}
+
+// truncate shortens s to at most n runes, on a word boundary if it can.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	cut := string(runes[:n])
+	if space := strings.LastIndex(cut, " "); space > n/2 {
+		cut = cut[:space]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,86 @@
+package url
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	gurl "net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thrgamon/nous/links"
+)
+
+func TestExtractPreview(t *testing.T) {
+	pageURL, _ := gurl.Parse("https://video.example/watch/42")
+	page := `<html><head>
+<title>Watch: Bees | Video Example</title>
+<meta property="og:title" content="Bees">
+<meta property="og:site_name" content="Video Example">
+<meta name="description" content="  A short film
+  about bees.">
+<meta name="twitter:image" content="/thumbs/42.jpg">
+<link rel="alternate" type="application/json+oembed" href="/oembed?id=42">
+</head></html>`
+
+	preview, oEmbedURL, err := ExtractPreview(strings.NewReader(page), pageURL)
+	assert.NoError(t, err)
+	assert.Equal(t, links.Preview{
+		Title:       "Bees",
+		Description: "A short film about bees.",
+		SiteName:    "Video Example",
+		Image:       "https://video.example/thumbs/42.jpg",
+	}, preview)
+	assert.Equal(t, "https://video.example/oembed?id=42", oEmbedURL)
+}
+
+func TestFillFromOEmbed(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/json")
+		res.Write([]byte(`{"title":"Bees","author_name":"Ada","provider_name":"Video Example","thumbnail_url":"/thumbs/42.jpg","html":"<iframe></iframe>"}`))
+	}))
+	defer testServer.Close()
+
+	preview, err := fillFromOEmbed(context.Background(), testFetcher(), links.Preview{Title: "Kept"}, testServer.URL+"/oembed")
+	assert.NoError(t, err)
+	assert.Equal(t, links.Preview{
+		Title:       "Kept",
+		Description: "By Ada",
+		SiteName:    "Video Example",
+		Image:       testServer.URL + "/thumbs/42.jpg",
+	}, preview)
+}
+
+func TestFetchPreviewImage(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		// Servers can't be trusted to say what they send
+		res.Header().Set("Content-Type", "image/png")
+		if req.URL.Path == "/image.png" {
+			res.Write([]byte(png))
+			return
+		}
+		res.Write([]byte("<script>alert(1)</script>"))
+	}))
+	defer testServer.Close()
+
+	image, err := fetchPreviewImage(context.Background(), testFetcher(), testServer.URL+"/image.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", image.ContentType)
+	assert.Equal(t, []byte(png), image.Body)
+
+	_, err = fetchPreviewImage(context.Background(), testFetcher(), testServer.URL+"/page.html")
+	assert.Error(t, err)
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "a few words…", truncate("a few words here", 13))
+	assert.Equal(t, "ééééé…", truncate("éééééééééé", 5))
+}
+
+func TestFindURLs(t *testing.T) {
+	body := "See https://example.com and https://example.org/a, then https://example.com again"
+	assert.Equal(t, []string{"https://example.com", "https://example.org/a"}, FindURLs(body))
+}
@@ -0,0 +1,207 @@
+package url
+
+import (
+	"io"
+	gurl "net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/thrgamon/nous/links"
+)
+
+// Long enough for the search index to find an article by any phrase in it,
+// well short of the limit on the size of a tsvector.
+const maxArticleText = 100000
+
+var (
+	// Parts of a page that are rarely the article
+	unlikelyContent = regexp.MustCompile(`(?i)comment|sidebar|footer|masthead|menu|nav|share|social|promo|related|sponsor|advert|\bads?\b|banner|cookie|popup|subscribe`)
+	likelyContent   = regexp.MustCompile(`(?i)article|body|content|entry|main|post|story|text`)
+	spaces          = regexp.MustCompile(`\s+`)
+)
+
+// ExtractArticle picks out the main text of a page the way reader views do:
+// paragraphs are scored by their length and punctuation, and the element
+// holding the best scoring paragraphs is taken to be the article.
+func ExtractArticle(r io.Reader, pageURL *gurl.URL) (links.Article, error) {
+	var article links.Article
+
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return article, err
+	}
+
+	article.Title = strings.TrimSpace(doc.Find("title").First().Text())
+	article.Byline = byline(doc)
+	article.PublishedAt = publishedAt(doc)
+	article.LeadImage = leadImage(doc, pageURL)
+
+	doc.Find("script, style, noscript, template, nav, header, footer, aside, form, iframe, svg").Remove()
+
+	if root := articleRoot(doc); root != nil {
+		article.Text = articleText(root)
+	}
+	if len(article.Text) > maxArticleText {
+		article.Text = strings.ToValidUTF8(article.Text[:maxArticleText], "")
+	}
+
+	return article, nil
+}
+
+// articleRoot finds the element that best contains the article.
+func articleRoot(doc *goquery.Document) *goquery.Selection {
+	type candidate struct {
+		selection *goquery.Selection
+		score     float64
+	}
+	var candidates []*candidate
+	// Keyed by the element's node, as selections of the same element differ
+	seen := make(map[interface{}]*candidate)
+
+	add := func(s *goquery.Selection, score float64) {
+		if s.Length() == 0 {
+			return
+		}
+		c, ok := seen[s.Nodes[0]]
+		if !ok {
+			c = &candidate{selection: s, score: classWeight(s)}
+			seen[s.Nodes[0]] = c
+			candidates = append(candidates, c)
+		}
+		c.score += score
+	}
+
+	doc.Find("p, pre, td, blockquote").Each(func(_ int, p *goquery.Selection) {
+		text := strings.TrimSpace(p.Text())
+		if len(text) < 25 {
+			return
+		}
+
+		score := 1 + float64(strings.Count(text, ","))
+		if length := float64(len(text)) / 100; length < 3 {
+			score += length
+		} else {
+			score += 3
+		}
+		add(p.Parent(), score)
+		add(p.Parent().Parent(), score/2)
+	})
+
+	var best *candidate
+	for _, c := range candidates {
+		// Lots of link text means a list of links rather than an article
+		if text := len(c.selection.Text()); text > 0 {
+			c.score *= 1 - float64(len(c.selection.Find("a").Text()))/float64(text)
+		}
+		if best == nil || c.score > best.score {
+			best = c
+		}
+	}
+
+	if best == nil {
+		if article := doc.Find("article, main").First(); article.Length() > 0 {
+			return article
+		}
+		return nil
+	}
+	return best.selection
+}
+
+func classWeight(s *goquery.Selection) float64 {
+	var weight float64
+	for _, attr := range []string{"class", "id"} {
+		value, _ := s.Attr(attr)
+		if value == "" {
+			continue
+		}
+		if unlikelyContent.MatchString(value) {
+			weight -= 25
+		}
+		if likelyContent.MatchString(value) {
+			weight += 25
+		}
+	}
+	if goquery.NodeName(s) == "article" || goquery.NodeName(s) == "main" {
+		weight += 25
+	}
+	return weight
+}
+
+// articleText is the text of each block in root, a paragraph apiece.
+func articleText(root *goquery.Selection) string {
+	var paragraphs []string
+	blocks := root.Find("h1, h2, h3, h4, h5, h6, p, pre, li, blockquote, td")
+	if blocks.Length() == 0 {
+		blocks = root
+	}
+
+	blocks.Each(func(_ int, s *goquery.Selection) {
+		// Nested blocks are part of their parent's text
+		if s.ParentsUntilSelection(root).Filter("p, pre, li, blockquote, td").Length() > 0 {
+			return
+		}
+		text := strings.TrimSpace(spaces.ReplaceAllString(s.Text(), " "))
+		if text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	})
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func byline(doc *goquery.Document) string {
+	for _, selector := range []string{`meta[name="author"]`, `meta[property="article:author"]`, `meta[name="twitter:creator"]`} {
+		if content, ok := doc.Find(selector).First().Attr("content"); ok && strings.TrimSpace(content) != "" {
+			return strings.TrimSpace(content)
+		}
+	}
+
+	author := doc.Find(`[rel="author"], [itemprop="author"], .byline, .author`).First().Text()
+	return strings.TrimSpace(spaces.ReplaceAllString(author, " "))
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	time.RFC1123,
+	time.RFC1123Z,
+}
+
+func publishedAt(doc *goquery.Document) *time.Time {
+	var candidates []string
+	for _, selector := range []string{`meta[property="article:published_time"]`, `meta[name="date"]`, `meta[itemprop="datePublished"]`, `meta[name="DC.date.issued"]`} {
+		if content, ok := doc.Find(selector).First().Attr("content"); ok {
+			candidates = append(candidates, content)
+		}
+	}
+	if datetime, ok := doc.Find("time[datetime]").First().Attr("datetime"); ok {
+		candidates = append(candidates, datetime)
+	}
+
+	for _, candidate := range candidates {
+		for _, layout := range dateLayouts {
+			if date, err := time.Parse(layout, strings.TrimSpace(candidate)); err == nil {
+				return &date
+			}
+		}
+	}
+	return nil
+}
+
+func leadImage(doc *goquery.Document, pageURL *gurl.URL) string {
+	image, ok := doc.Find(`meta[property="og:image"]`).First().Attr("content")
+	if !ok {
+		image, ok = doc.Find(`meta[name="twitter:image"]`).First().Attr("content")
+	}
+	if !ok {
+		image, ok = doc.Find("article img[src], main img[src]").First().Attr("src")
+	}
+	if !ok {
+		return ""
+	}
+	return resolveHTTP(pageURL, image)
+}
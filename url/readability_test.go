@@ -0,0 +1,67 @@
+package url
+
+import (
+	gurl "net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const articlePage = `<html>
+<head>
+  <title>Why the bees are leaving</title>
+  <meta name="author" content="Ada Apiarist">
+  <meta property="article:published_time" content="2022-05-01T09:30:00Z">
+  <meta property="og:image" content="/images/hive.jpg">
+  <script>track("pageview")</script>
+</head>
+<body>
+  <nav><a href="/">Home</a> <a href="/news">News</a></nav>
+  <div class="sidebar">
+    <p>Subscribe to our newsletter, for weekly updates, offers and more news.</p>
+  </div>
+  <div class="post-content">
+    <h2>A quiet spring</h2>
+    <p>Beekeepers across the valley report that colonies are absconding, leaving honey, brood and comb behind.</p>
+    <p>Researchers suspect a combination of mites, pesticides and an unusually warm winter, though nobody is certain.</p>
+    <p>The <a href="/mites">varroa mite</a> has been found in every hive surveyed this year, a first for the region.</p>
+  </div>
+  <div class="comments">
+    <p>Great article, thanks for writing it, I have shared it with my friends.</p>
+  </div>
+  <footer><p>Copyright, all rights reserved, The Valley Gazette, since 1901.</p></footer>
+</body>
+</html>`
+
+func TestExtractArticle(t *testing.T) {
+	pageURL, _ := gurl.Parse("https://gazette.example/2022/bees")
+
+	article, err := ExtractArticle(strings.NewReader(articlePage), pageURL)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Why the bees are leaving", article.Title)
+	assert.Equal(t, "Ada Apiarist", article.Byline)
+	assert.Equal(t, "https://gazette.example/images/hive.jpg", article.LeadImage)
+	if assert.NotNil(t, article.PublishedAt) {
+		assert.Equal(t, time.Date(2022, 5, 1, 9, 30, 0, 0, time.UTC), *article.PublishedAt)
+	}
+
+	assert.Equal(t, strings.Join([]string{
+		"A quiet spring",
+		"Beekeepers across the valley report that colonies are absconding, leaving honey, brood and comb behind.",
+		"Researchers suspect a combination of mites, pesticides and an unusually warm winter, though nobody is certain.",
+		"The varroa mite has been found in every hive surveyed this year, a first for the region.",
+	}, "\n\n"), article.Text)
+}
+
+func TestExtractArticleWithoutParagraphs(t *testing.T) {
+	pageURL, _ := gurl.Parse("https://example.com")
+
+	article, err := ExtractArticle(strings.NewReader(`<html><body><main>Short <b>note</b></main></body></html>`), pageURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "Short note", article.Text)
+	assert.Nil(t, article.PublishedAt)
+	assert.Empty(t, article.LeadImage)
+}
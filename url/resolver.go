@@ -1,6 +1,7 @@
 package url
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,44 +10,111 @@ import (
 	"net/http"
 	gurl "net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/PuerkitoBio/goquery"
+	"github.com/thrgamon/nous/jobs"
 	"github.com/thrgamon/nous/links"
 	"github.com/thrgamon/nous/logger"
 	"mvdan.cc/xurls/v2"
 )
 
-func ExtractURLMetadata(body string) {
-	ctx := context.Background()
-	rxStrict := xurls.Strict()
-	urls := rxStrict.FindAllString(body, -1)
-	for _, v := range urls {
-		go ProcessURL(ctx, v)
+// ProcessURLJob is the kind of job that fetches the details of a link and
+// submits it to the archive.
+const ProcessURLJob = "process_url"
+
+type processURLPayload struct {
+	URL      string `json:"url"`
+	Archiver string `json:"archiver"`
+}
+
+// EnqueueURLs queues a job for each link in a note body, to be archived with
+// the named archiver. It takes the note's transaction so the jobs are only
+// queued if the note is saved.
+func EnqueueURLs(ctx context.Context, q jobs.Execer, body string, archiver string) error {
+	for _, url := range FindURLs(body) {
+		if err := jobs.Enqueue(ctx, q, ProcessURLJob, processURLPayload{URL: url, Archiver: archiver}); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func ProcessURL(ctx context.Context, url string) error {
-	linkRepo := links.NewLinkRepo()
-	exists, err := linkRepo.Exists(ctx, url)
+// FindURLs returns each URL in a note body once, in the order they appear.
+func FindURLs(body string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, url := range xurls.Strict().FindAllString(body, -1) {
+		if !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
 
-	if err != nil {
-		logger.Logger.Println(err.Error())
-		return err
+// HandleProcessURL runs a ProcessURLJob.
+func HandleProcessURL(ctx context.Context, payload json.RawMessage) error {
+	var p processURLPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return fmt.Errorf("%w: %s", jobs.ErrPermanent, err)
+	}
+
+	// Jobs queued before archivers could be chosen went to the Wayback Machine
+	if p.Archiver == "" {
+		p.Archiver = ArchiverWayback
 	}
 
-	if exists {
-		logger.Logger.Println("Url already exits: ", url)
-		return nil
+	return ProcessURL(ctx, p.URL, p.Archiver)
+}
+
+// ProcessURL saves a link, resolves it, extracts its article for the search
+// index and archives it with the named archiver. A link that has already been submitted is left
+// alone, so it is safe to run again after a failure part way through.
+func ProcessURL(ctx context.Context, url string, archiverName string) error {
+	archiver, err := NewArchiver(archiverName)
+	if err != nil {
+		return fmt.Errorf("%w: %s", jobs.ErrPermanent, err)
 	}
 
-	linkID, err := linkRepo.AddLink(ctx, url)
+	canonicalURL, err := Canonicalise(url)
 	if err != nil {
+		return fmt.Errorf("%w: %s", jobs.ErrPermanent, err)
+	}
+
+	linkRepo := links.NewLinkRepo()
+	link, err := linkRepo.GetByCanonical(ctx, canonicalURL)
+
+	switch {
+	case errors.Is(err, links.ErrLinkNotFound):
+		link.LinkID, err = linkRepo.AddLink(ctx, url, canonicalURL)
+		if err != nil {
+			logger.Logger.Println(err.Error())
+			return err
+		}
+	case err != nil:
 		logger.Logger.Println(err.Error())
 		return err
+	case link.ArchiveStatus == int(links.Skipped) && archiver != nil:
+		// Linked again from a context that does archive
+		if _, err := linkRepo.AddSourceURL(ctx, link.LinkID, url); err != nil {
+			logger.Logger.Println(err.Error())
+			return err
+		}
+	case link.ArchiveStatus != int(links.Unsubmitted):
+		logger.Logger.Println("Url already processed: ", url)
+		return indexLinkingNotes(ctx, linkRepo, link.LinkID, url)
+	default:
+		// Saved before but never got as far as the archive
+		if _, err := linkRepo.AddSourceURL(ctx, link.LinkID, url); err != nil {
+			logger.Logger.Println(err.Error())
+			return err
+		}
 	}
 
-	resolvedURL, err := Resolve(url)
+	linkID := link.LinkID
+	resolvedURL, err := defaultFetcher.Resolve(ctx, url)
 	if err != nil {
 		logger.Logger.Println(err.Error())
 		return err
@@ -57,22 +125,49 @@ func ProcessURL(ctx context.Context, url string) error {
 		return err
 	}
 
-	title, err := GetTitle(resolvedURL)
+	page, pageURL, err := fetchPage(ctx, resolvedURL)
 	if err != nil {
 		logger.Logger.Println(err.Error())
 	} else {
-		err = linkRepo.EditLinkTitle(ctx, linkID, title)
-		if err != nil {
+		if err := saveArticle(ctx, linkRepo, linkID, page, pageURL); err != nil {
+			logger.Logger.Println(err.Error())
+		}
+		if err := savePreview(ctx, linkRepo, linkID, page, pageURL); err != nil {
 			logger.Logger.Println(err.Error())
 		}
 	}
 
-	archiveResponse, err := SubmitToArchive(resolvedURL, "https://web.archive.org/save")
+	return archiveLink(ctx, linkRepo, linkID, resolvedURL, archiverName, archiver)
+}
+
+// archiveLink archives a link's page and records the snapshot, or the job
+// to check for it. A nil archiver marks the link as skipped.
+func archiveLink(ctx context.Context, linkRepo *links.LinkRepo, linkID links.LinkID, pageURL string, archiverName string, archiver Archiver) error {
+	// Recorded first, so archiving again never uses a less private archiver
+	err := linkRepo.EditArchiver(ctx, linkID, archiverName)
 	if err != nil {
 		logger.Logger.Println(err.Error())
 		return err
 	}
-	err = linkRepo.EditArchiveStatus(ctx, linkID, links.Pending, archiveResponse.JobID, "")
+
+	if archiver == nil {
+		return linkRepo.EditArchiveStatus(ctx, linkID, links.Skipped, "", "")
+	}
+
+	snapshot, err := archiver.Archive(ctx, pageURL)
+	if err != nil {
+		logger.Logger.Println(err.Error())
+		return err
+	}
+
+	if snapshot.URL == "" {
+		err = linkRepo.EditArchiveStatus(ctx, linkID, links.Pending, snapshot.JobID, "")
+	} else {
+		err = linkRepo.EditArchiveURL(ctx, linkID, snapshot.URL)
+		if err == nil {
+			err = linkRepo.EditArchiveStatus(ctx, linkID, links.Success, snapshot.JobID, "")
+		}
+	}
 	if err != nil {
 		logger.Logger.Println(err.Error())
 		return err
@@ -81,44 +176,139 @@ func ProcessURL(ctx context.Context, url string) error {
 	return nil
 }
 
-func Resolve(url string) (string, error) {
-	resp, err := http.Head(url)
-	return resp.Request.URL.String(), err
+// HandleRefetchLink runs a links.RefetchJob, fetching a link's page again
+// for its article and preview.
+func HandleRefetchLink(ctx context.Context, payload json.RawMessage) error {
+	linkRepo := links.NewLinkRepo()
+	link, err := linkForJob(ctx, linkRepo, payload)
+	if err != nil {
+		return err
+	}
+
+	page, pageURL, err := fetchPage(ctx, link.Url)
+	if err != nil {
+		return err
+	}
+	if err := saveArticle(ctx, linkRepo, link.LinkID, page, pageURL); err != nil {
+		return err
+	}
+	return savePreview(ctx, linkRepo, link.LinkID, page, pageURL)
 }
 
-func GetTitle(url string) (string, error) {
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", url, strings.NewReader(""))
-	req.Header.Set("User-Agent", "Nous/1.1")
-	var title string
-	res, err := client.Do(req)
+// HandleArchiveLink runs a links.ArchiveJob, archiving a link again with the
+// archiver it was last archived with.
+func HandleArchiveLink(ctx context.Context, payload json.RawMessage) error {
+	linkRepo := links.NewLinkRepo()
+	link, err := linkForJob(ctx, linkRepo, payload)
 	if err != nil {
-		logger.Logger.Println(err.Error())
-		return title, err
+		return err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		logger.Logger.Printf("status code error: %d %s\n", res.StatusCode, res.Status)
-		return title, err
+	archiver, err := NewArchiver(link.Archiver)
+	if err != nil {
+		return fmt.Errorf("%w: %s", jobs.ErrPermanent, err)
+	}
+	return archiveLink(ctx, linkRepo, link.LinkID, link.Url, link.Archiver, archiver)
+}
+
+func linkForJob(ctx context.Context, linkRepo *links.LinkRepo, payload json.RawMessage) (links.Link, error) {
+	var p links.JobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return links.Link{}, fmt.Errorf("%w: %s", jobs.ErrPermanent, err)
 	}
 
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+	link, err := linkRepo.Get(ctx, p.LinkID)
+	if errors.Is(err, links.ErrLinkNotFound) {
+		return link, fmt.Errorf("%w: %s", jobs.ErrPermanent, err)
+	}
+	return link, err
+}
 
+// saveArticle stores a link's article and indexes the notes that link to it
+// with its text.
+func saveArticle(ctx context.Context, linkRepo *links.LinkRepo, id links.LinkID, page []byte, pageURL *gurl.URL) error {
+	article, err := ExtractArticle(bytes.NewReader(page), pageURL)
 	if err != nil {
-		logger.Logger.Println(err.Error())
-		return title, err
+		return err
 	}
+	if err := linkRepo.EditArticle(ctx, id, article); err != nil {
+		return err
+	}
+	return linkRepo.IndexNotes(ctx, id)
+}
 
-	title = doc.Find("title").Text()
+// indexLinkingNotes records url as a way a link is written, and if that
+// joins new notes to the link, indexes them with the link's article.
+func indexLinkingNotes(ctx context.Context, linkRepo *links.LinkRepo, id links.LinkID, url string) error {
+	attached, err := linkRepo.AddSourceURL(ctx, id, url)
+	if err != nil || !attached {
+		return err
+	}
+	return linkRepo.IndexNotes(ctx, id)
+}
+
+// CanonicaliseLinks fills in the canonical URL of links saved before they
+// were canonicalised, so they are found when the same page is linked again.
+func CanonicaliseLinks(ctx context.Context) error {
+	linkRepo := links.NewLinkRepo()
+	urls, err := linkRepo.GetUncanonicalised(ctx)
+	if err != nil {
+		return err
+	}
 
-	if title == "" {
+	for id, url := range urls {
+		canonicalURL, err := Canonicalise(url)
+		if err != nil {
+			logger.Logger.Println(err.Error())
+			continue
+		}
+		if err := linkRepo.EditCanonicalURL(ctx, id, canonicalURL); err != nil {
+			logger.Logger.Println(err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Resolve follows a URL's redirects to find where it ends up.
+func Resolve(url string) (string, error) {
+	return defaultFetcher.Resolve(context.Background(), url)
+}
+
+func GetTitle(url string) (string, error) {
+	article, err := GetArticle(url)
+	if article.Title == "" && err == nil {
 		logger.Logger.Println("No title found for url: ", url)
 	}
+	return article.Title, err
+}
 
-	return title, nil
+// GetArticle fetches a page and extracts its title and article text.
+func GetArticle(url string) (links.Article, error) {
+	page, pageURL, err := fetchPage(context.Background(), url)
+	if err != nil {
+		return links.Article{}, err
+	}
+	return ExtractArticle(bytes.NewReader(page), pageURL)
 }
 
+// fetchPage fetches an HTML page, returning it with the URL it was found at
+// after any redirects.
+func fetchPage(ctx context.Context, url string) ([]byte, *gurl.URL, error) {
+	res, err := defaultFetcher.Get(ctx, url, HTMLTypes...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Body, res.URL, nil
+}
+
+// archiveClient talks to the Wayback Machine, which we trust, so it doesn't
+// need the fetcher's address checks.
+var archiveClient = &http.Client{Timeout: time.Minute}
+
+const maxArchiveResponse = 1 << 20
+
 type ArchiveResponse struct {
 	Url     string `json:"url"`
 	JobID   string `json:"job_id"`
@@ -128,7 +318,6 @@ type ArchiveResponse struct {
 // "https://web.archive.org/save"
 func SubmitToArchive(url string, archiveURL string) (ArchiveResponse, error) {
 	var archiveResponse ArchiveResponse
-	client := &http.Client{}
 	data := gurl.Values{}
 
 	data.Set("url", url)
@@ -137,18 +326,19 @@ func SubmitToArchive(url string, archiveURL string) (ArchiveResponse, error) {
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Accept", "application/json")
 
-	res, err := client.Do(req)
-	if res.StatusCode != 200 {
-		err = errors.New("There was a problem with the request. Status code: " + res.Status)
-		return archiveResponse, err
-	}
+	res, err := archiveClient.Do(req)
 	if err != nil {
 		logger.Logger.Println(err.Error())
 		return archiveResponse, err
 	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		err = errors.New("There was a problem with the request. Status code: " + res.Status)
+		return archiveResponse, err
+	}
 
 	defer res.Body.Close()
-	buf, err := io.ReadAll(res.Body)
+	buf, err := io.ReadAll(io.LimitReader(res.Body, maxArchiveResponse))
 	err = json.Unmarshal(buf, &archiveResponse)
 
 	if err != nil {
@@ -159,6 +349,31 @@ func SubmitToArchive(url string, archiveURL string) (ArchiveResponse, error) {
 	return archiveResponse, err
 }
 
+// ErrArchiveFailed means the archive gave up on a job, so checking it again
+// won't help.
+var ErrArchiveFailed = errors.New("archiving failed")
+
+// RateLimitError is returned when the archive asks us to slow down.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of
+// seconds or a date. Zero means the header was missing or unreadable.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
 type JobStatusResponse struct {
 	OriginalURL string `json:"original_url"`
 	JobID       string `json:"job_id"`
@@ -170,25 +385,30 @@ type JobStatusResponse struct {
 
 func CheckArchiveJobStatus(jobID string, checkStatusURL string) (archiveURL string, err error) {
 	var jobStatusResponse JobStatusResponse
-	client := &http.Client{}
 
 	req, _ := http.NewRequest("GET", checkStatusURL, strings.NewReader(""))
 	req.Header.Add("Authorization", "LOW "+os.Getenv("ARCHIVE_ACCESS_KEY")+":"+os.Getenv("ARCHIVE_SECRET_KEY"))
 	req.Header.Add("Accept", "application/json")
 
-	res, err := client.Do(req)
-	if res.StatusCode != 200 {
-		err = errors.New("There was a problem with the request. Status code: " + res.Status)
+	res, err := archiveClient.Do(req)
+	if err != nil {
+		logger.Logger.Println(err.Error())
 		return archiveURL, err
 	}
 
-	if err != nil {
-		logger.Logger.Println(err.Error())
+	if res.StatusCode == http.StatusTooManyRequests {
+		res.Body.Close()
+		return archiveURL, &RateLimitError{RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now())}
+	}
+
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		err = errors.New("There was a problem with the request. Status code: " + res.Status)
 		return archiveURL, err
 	}
 
 	defer res.Body.Close()
-	buf, err := io.ReadAll(res.Body)
+	buf, err := io.ReadAll(io.LimitReader(res.Body, maxArchiveResponse))
 	err = json.Unmarshal(buf, &jobStatusResponse)
 
 	if err != nil {
@@ -202,7 +422,7 @@ func CheckArchiveJobStatus(jobID string, checkStatusURL string) (archiveURL stri
 	case "pending":
 		return "", nil
 	case "error":
-		err = errors.New("There was a problem with the archiving job job. Exception: " + jobStatusResponse.Exception)
+		err = fmt.Errorf("%w: %s", ErrArchiveFailed, jobStatusResponse.Exception)
 		return archiveURL, err
 	}
 
@@ -0,0 +1,165 @@
+package url
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	gurl "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const robotsTTL = 24 * time.Hour
+
+// robotsCache keeps each host's robots.txt rules for a day.
+type robotsCache struct {
+	mu    sync.Mutex
+	hosts map[string]robotsEntry
+}
+
+type robotsEntry struct {
+	rules   robotsRules
+	fetched time.Time
+}
+
+func newRobotsCache() *robotsCache {
+	return &robotsCache{hosts: make(map[string]robotsEntry)}
+}
+
+// allowed checks a URL against its host's robots.txt. A robots.txt that
+// can't be had allows everything, as crawlers usually take it.
+func (c *robotsCache) allowed(ctx context.Context, f *Fetcher, url *gurl.URL) (bool, error) {
+	origin := url.Scheme + "://" + url.Host
+
+	c.mu.Lock()
+	entry, ok := c.hosts[origin]
+	c.mu.Unlock()
+
+	if !ok || time.Since(entry.fetched) > robotsTTL {
+		entry = robotsEntry{fetched: time.Now()}
+
+		res, err := f.Get(ctx, origin+"/robots.txt", "text/plain")
+		if err == nil {
+			entry.rules = parseRobots(res.Body, f.userAgent)
+		} else if res.StatusCode == 0 && ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		c.mu.Lock()
+		c.hosts[origin] = entry
+		c.mu.Unlock()
+	}
+
+	path := url.EscapedPath()
+	if url.RawQuery != "" {
+		path += "?" + url.RawQuery
+	}
+	return entry.rules.allows(path), nil
+}
+
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+type robotsRules []robotsRule
+
+// parseRobots reads the rules for userAgent from a robots.txt, or the rules
+// for every agent if none name it.
+func parseRobots(body []byte, userAgent string) robotsRules {
+	product := strings.ToLower(strings.SplitN(userAgent, "/", 2)[0])
+
+	var ours, everyone robotsRules
+	var foundOurs bool
+	var agents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// A user-agent after rules starts a new group
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			// An empty disallow allows everything
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{pattern: value, allow: key == "allow"}
+			for _, agent := range agents {
+				switch {
+				case agent == "*":
+					everyone = append(everyone, rule)
+				case product != "" && strings.Contains(product, agent):
+					foundOurs = true
+					ours = append(ours, rule)
+				}
+			}
+		}
+	}
+
+	if foundOurs {
+		return ours
+	}
+	return everyone
+}
+
+// allows applies the most specific matching rule, with allow winning a tie.
+func (rules robotsRules) allows(path string) bool {
+	allowed := true
+	longest := -1
+	for _, rule := range rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			longest = len(rule.pattern)
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+// robotsMatch matches a robots.txt path pattern, where * matches anything
+// and a trailing $ anchors the end.
+func robotsMatch(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for _, part := range parts[1:] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+
+	if anchored && rest != "" {
+		// The last part may match later in the path
+		last := parts[len(parts)-1]
+		return len(parts) > 1 && strings.HasSuffix(path, last)
+	}
+	return true
+}